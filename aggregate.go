@@ -0,0 +1,52 @@
+package mmdbmeld
+
+import (
+	"net"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"go4.org/netipx"
+)
+
+// networkAggregator collects consecutive networks with identical records and
+// merges them into the largest possible networks.
+// Only consecutive networks are merged, so that the order of inserts, and
+// with it the merge result, does not change.
+type networkAggregator struct {
+	record  mmdbtype.Map
+	builder netipx.IPSetBuilder
+}
+
+// add adds the network with the given record. It returns false, if the record
+// differs from the pending record. Call flush before adding it again.
+func (na *networkAggregator) add(network *net.IPNet, record mmdbtype.Map) bool {
+	if na.record != nil && !na.record.Equal(record) {
+		return false
+	}
+	prefix, ok := netipx.FromStdIPNet(network)
+	if !ok {
+		return false
+	}
+
+	na.record = record
+	na.builder.AddPrefix(prefix)
+	return true
+}
+
+// flush returns the pending record with its merged networks and resets the
+// aggregator.
+func (na *networkAggregator) flush() (mmdbtype.Map, []*net.IPNet) {
+	record := na.record
+	set, _ := na.builder.IPSet()
+	na.record = nil
+	na.builder = netipx.IPSetBuilder{}
+	if record == nil || set == nil {
+		return nil, nil
+	}
+
+	prefixes := set.Prefixes()
+	networks := make([]*net.IPNet, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		networks = append(networks, netipx.PrefixIPNet(prefix))
+	}
+	return record, networks
+}
@@ -0,0 +1,95 @@
+package mmdbmeld
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Builder builds databases with shared defaults and additional source
+// formats, eg. for long-running services that build many databases in one
+// process. It is safe for concurrent use, and every build is isolated.
+//
+// Shared by all builds are only the defaults, which are not modified, and the
+// registered formats. Everything else is per build: the database config is
+// copied before the defaults are applied to it, and every build loads its own
+// sources and keeps its own trees, interned values and statistics.
+type Builder struct {
+	defaults DefaultConfig
+
+	lock    sync.RWMutex
+	loaders map[string]SourceLoader
+}
+
+// NewBuilder returns a new Builder, which applies the given defaults to the
+// config of every build.
+func NewBuilder(defaults DefaultConfig) *Builder {
+	return &Builder{
+		defaults: defaults,
+		loaders:  make(map[string]SourceLoader),
+	}
+}
+
+// RegisterFormat registers a loader for inputs with the given format.
+// Registered formats are only selected by the format of inputs, not by file
+// suffixes. Built-in formats cannot be replaced.
+func (b *Builder) RegisterFormat(format string, loader SourceLoader) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch {
+	case format == "" || strings.TrimSpace(format) != format:
+		return fmt.Errorf("invalid format name %q", format)
+	case loader == nil:
+		return fmt.Errorf("loader of format %s is nil", format)
+	case slices.Contains(sourceFormats(false, b.loaders), format):
+		return fmt.Errorf("format %s is already registered", format)
+	}
+	b.loaders[format] = loader
+	return nil
+}
+
+// Config returns a copy of the given config with the defaults applied.
+// The maps of the config are copied, so that the given config is not
+// modified.
+func (b *Builder) Config(dbConfig DatabaseConfig) DatabaseConfig {
+	dbConfig.Types = maps.Clone(dbConfig.Types)
+	dbConfig.Mappings = maps.Clone(dbConfig.Mappings)
+	dbConfig.Optimize.FieldFloatDecimals = maps.Clone(dbConfig.Optimize.FieldFloatDecimals)
+	dbConfig.Merge.FieldArrayMerge = maps.Clone(dbConfig.Merge.FieldArrayMerge)
+	b.defaults.ApplyTo(&dbConfig)
+	return dbConfig
+}
+
+// LoadSources loads the input files of the given config like LoadSources,
+// with the defaults applied and the registered formats.
+func (b *Builder) LoadSources(dbConfig DatabaseConfig) ([]Source, error) {
+	return loadSources(context.Background(), b.Config(dbConfig), b.formats())
+}
+
+// Build builds the database of the given config with the defaults applied,
+// and writes it to its outputs. Updates are sent to the channel, if set, which
+// is closed when the build is finished.
+func (b *Builder) Build(ctx context.Context, dbConfig DatabaseConfig, updates chan string) (*BuildStats, error) {
+	dbConfig = b.Config(dbConfig)
+	sources, err := loadSources(ctx, dbConfig, b.formats())
+	if err != nil {
+		if updates != nil {
+			close(updates)
+		}
+		return nil, err
+	}
+	return WriteMMDBWithStats(ctx, dbConfig, sources, updates)
+}
+
+// formats returns a copy of the registered formats, so that builds are not
+// affected by formats registered later.
+func (b *Builder) formats() map[string]SourceLoader {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	return maps.Clone(b.loaders)
+}
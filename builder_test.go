@@ -0,0 +1,83 @@
+package mmdbmeld
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+func TestBuilder(t *testing.T) {
+	t.Parallel()
+
+	builder := NewBuilder(DefaultConfig{
+		Types:    map[string]string{"source": "string"},
+		Optimize: Optimizations{NormalizeStrings: []string{NormalizeUpper}},
+	})
+	err := builder.RegisterFormat("test", func(input DatabaseInput, _ map[string]string) (Source, error) {
+		return newTestSource(input.Name, "192.0.2.0/24"), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Built-in and registered formats cannot be replaced.
+	for _, format := range []string{"csv", "test", ""} {
+		if err := builder.RegisterFormat(format, func(DatabaseInput, map[string]string) (Source, error) { return nil, nil }); err == nil {
+			t.Fatalf("expected error for format %q", format)
+		}
+	}
+
+	// Build concurrently from the same config.
+	dir := t.TempDir()
+	dbConfig := DatabaseConfig{
+		Name:   "Test",
+		MMDB:   MMDBConfig{IPVersion: 4, RecordSize: 24},
+		Types:  map[string]string{},
+		Inputs: []DatabaseInput{{Name: "a", File: "feed", Format: "test"}},
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			dbConfig := dbConfig
+			dbConfig.Output = filepath.Join(dir, fmt.Sprintf("test-%d.mmdb", i))
+			_, errs[i] = builder.Build(context.Background(), dbConfig, nil)
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reader, err := maxminddb.Open(filepath.Join(dir, fmt.Sprintf("test-%d.mmdb", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var record map[string]any
+		if err := reader.Lookup([]byte{192, 0, 2, 1}, &record); err != nil {
+			t.Fatal(err)
+		}
+		_ = reader.Close()
+		if fmt.Sprintf("%v", record) != "map[source:A]" {
+			t.Fatalf("unexpected record of build %d: %v", i, record)
+		}
+	}
+
+	// The config of the caller is not modified.
+	if len(dbConfig.Types) != 0 {
+		t.Fatalf("defaults were applied to the given config: %v", dbConfig.Types)
+	}
+
+	// Registered formats are only available to the builder.
+	if _, err := LoadSources(dbConfig); err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+}
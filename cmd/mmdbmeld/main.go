@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"sync"
 
 	"github.com/safing/mmdbmeld"
 )
 
+// stdoutOutput is the output file name that writes the database to stdout.
+const stdoutOutput = "-"
+
 func main() {
 	if len(os.Args) != 2 {
 		fmt.Printf("usage: %s <config.yml>\n", os.Args[0])
@@ -20,17 +26,38 @@ func main() {
 		os.Exit(2)
 	}
 
+	// Log to stderr if a database is written to stdout.
+	var log io.Writer = os.Stdout
 	for _, db := range c.Databases {
-		fmt.Printf("\n==========\nbuilding %s\n", db.Name)
+		if db.Output == stdoutOutput {
+			log = os.Stderr
+		}
+	}
+
+	// Stop building when interrupted.
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	for _, db := range c.Databases {
+		fmt.Fprintf(log, "\n==========\nbuilding %s\n", db.Name)
 
 		// Apply defaults.
 		dbP := &db //nolint:gosec,scopelint // Only used within loop.
 		c.Defaults.ApplyTo(dbP)
 
+		// Write database to stdout, eg. for piping.
+		if db.Output == stdoutOutput {
+			if err := mmdbmeld.BuildToWriter(db, os.Stdout); err != nil {
+				fmt.Fprintln(log, err)
+				os.Exit(4) //nolint:gocritic // Exit immediately.
+			}
+			continue
+		}
+
 		// Load sources for database.
-		sources, err := mmdbmeld.LoadSources(db)
+		sources, err := mmdbmeld.LoadSourcesContext(ctx, db)
 		if err != nil {
-			fmt.Println(err)
+			fmt.Fprintln(log, err)
 			os.Exit(3)
 		}
 
@@ -43,15 +70,16 @@ func main() {
 		go func() {
 			defer wg.Done()
 			for msg := range updates {
-				fmt.Println(msg)
+				fmt.Fprintln(log, msg)
 			}
 		}()
 
 		// Read all sources and write to mmdb.
-		err = mmdbmeld.WriteMMDB(db, sources, updates)
+		err = mmdbmeld.WriteMMDBContext(ctx, db, sources, updates)
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(4)
+			wg.Wait()
+			fmt.Fprintln(log, err)
+			os.Exit(4) //nolint:gocritic // Exit immediately.
 		}
 
 		wg.Wait()
@@ -1,8 +1,14 @@
 package mmdbmeld
 
 import (
+	"context"
+	"fmt"
+	"net"
 	"os"
+	"sync/atomic"
+	"time"
 
+	"github.com/maxmind/mmdbwriter/mmdbtype"
 	"gopkg.in/yaml.v3"
 )
 
@@ -14,42 +20,332 @@ type Config struct {
 
 // DatabaseConfig holds the config for building one database.
 type DatabaseConfig struct {
-	Name     string            `yaml:"name"`
-	MMDB     MMDBConfig        `yaml:"mmdb"`
-	Types    map[string]string `yaml:"types"`
-	Inputs   []DatabaseInput   `yaml:"inputs"`
-	Output   string            `yaml:"output"`
-	Optimize Optimizations     `yaml:"optimize"`
-	Merge    MergeConfig       `yaml:"merge"`
+	Name   string            `yaml:"name"`
+	MMDB   MMDBConfig        `yaml:"mmdb"`
+	Types  map[string]string `yaml:"types"`
+	Inputs []DatabaseInput   `yaml:"inputs"`
+	Output string            `yaml:"output"`
+	// Compress compresses the output, see OutputConfig.Compress. It is also
+	// used by BuildToWriter.
+	Compress string `yaml:"compress"`
+	// Outputs defines additional outputs, eg. with a subset of the fields.
+	// All outputs are built from the same sources, which are read once.
+	Outputs  []OutputConfig     `yaml:"outputs"`
+	Optimize Optimizations      `yaml:"optimize"`
+	Merge    MergeConfig        `yaml:"merge"`
+	Mappings map[string]Mapping `yaml:"mappings"`
+
+	// Workers defines how many sources are read and converted in parallel.
+	// Entries are still inserted in the order of the inputs.
+	Workers int `yaml:"workers"`
+
+	// StrictOverlap fails the build if networks overlap and the merge
+	// strategy is not deep.
+	StrictOverlap bool `yaml:"strictOverlap"`
+	// SkipSpecialUse skips networks within special-use networks, like private
+	// and documentation networks, see SpecialUseNetworks. Networks that
+	// partially overlap are clipped to the remaining networks.
+	SkipSpecialUse bool `yaml:"skipSpecialUse"`
+	// RequireNonEmpty fails the build if any source yields no entries,
+	// eg. because a feed was downloaded as an empty file.
+	RequireNonEmpty bool `yaml:"requireNonEmpty"`
+	// OnOverlap is called for every network that overlaps with a previously
+	// inserted network.
+	OnOverlap func(Overlap) `yaml:"-"`
+	// OnConflict is called when a record is inserted into a network that
+	// already has a record, with the inserted network and copies of the
+	// existing and the incoming record. The returned value is stored instead
+	// of merging the records by the merge strategy, unless it is nil.
+	// Returning an error aborts the build. If the network contains multiple
+	// existing records, it is called for each of them.
+	OnConflict func(network *net.IPNet, existing, incoming mmdbtype.DataType) (mmdbtype.DataType, error) `yaml:"-"`
+	// ProgressFunc is called with the amount of processed entries of a source
+	// every 10000 entries, and with the total when the source is finished.
+	ProgressFunc func(sourceName string, processed int64) `yaml:"-"`
+	// ProgressPercentFunc is called with the estimated percentage of processed
+	// entries of a source, at the same times as ProgressFunc. It is only called
+	// for sources that can estimate their amount of entries, see
+	// EstimateCount.
+	ProgressPercentFunc func(sourceName string, percent float64) `yaml:"-"`
 }
 
 // DefaultConfig holds a subset of DatabaseConfig fields to be used as a default config.
 type DefaultConfig struct {
-	Types    map[string]string `yaml:"types"`
-	Optimize Optimizations     `yaml:"optimize"`
-	Merge    MergeConfig       `yaml:"merge"`
+	Types    map[string]string  `yaml:"types"`
+	Optimize Optimizations      `yaml:"optimize"`
+	Merge    MergeConfig        `yaml:"merge"`
+	Mappings map[string]Mapping `yaml:"mappings"`
 }
 
 // MMDBConfig holds mmdb specific config.
 type MMDBConfig struct {
-    IPVersion   int      `yaml:"ipVersion"`
-    RecordSize  int      `yaml:"recordSize"`
-    Description map[string]string `yaml:"description"`
-    Languages   []string `yaml:"languages"`
+	IPVersion   int               `yaml:"ipVersion"`
+	RecordSize  int               `yaml:"recordSize"`
+	Description map[string]string `yaml:"description"`
+	Languages   []string          `yaml:"languages"`
+
+	// DatabaseType is written to the metadata. Defaults to the database name.
+	DatabaseType string `yaml:"databaseType"`
+	// BuildEpoch is written to the metadata instead of the current time, if set.
+	BuildEpoch int64 `yaml:"buildEpoch"`
+
+	// DisableIPv4Aliasing disables aliasing the IPv4 networks of IPv6
+	// databases into the IPv4-mapped and other IPv4 embedding ranges.
+	// Defaults to true, unlike in mmdbwriter, as databases were always built
+	// this way before the option existed.
+	DisableIPv4Aliasing *bool `yaml:"disableIPv4Aliasing"`
+	// IncludeReservedNetworks allows inserting into reserved networks, like
+	// private and documentation networks. Defaults to true, unlike in
+	// mmdbwriter, so that inputs with data for reserved networks, eg. of
+	// internal networks, keep building as before the option existed.
+	IncludeReservedNetworks *bool `yaml:"includeReservedNetworks"`
+}
+
+// DefaultRecordSize is the record size used if none is configured.
+const DefaultRecordSize = 28
+
+// RecordSizeOrDefault returns the configured record size or the default.
+func (c MMDBConfig) RecordSizeOrDefault() int {
+	if c.RecordSize == 0 {
+		return DefaultRecordSize
+	}
+	return c.RecordSize
+}
+
+// IPv4AliasingDisabled reports whether DisableIPv4Aliasing is unset or true.
+func (c MMDBConfig) IPv4AliasingDisabled() bool {
+	return c.DisableIPv4Aliasing == nil || *c.DisableIPv4Aliasing
+}
+
+// ReservedNetworksIncluded reports whether IncludeReservedNetworks is unset
+// or true.
+func (c MMDBConfig) ReservedNetworksIncluded() bool {
+	return c.IncludeReservedNetworks == nil || *c.IncludeReservedNetworks
+}
+
+// Validate checks if the mmdb config is valid.
+func (c MMDBConfig) Validate() error {
+	switch c.RecordSizeOrDefault() {
+	case 24, 28, 32:
+		return nil
+	default:
+		return fmt.Errorf("invalid record size %d: must be 24, 28 or 32", c.RecordSize)
+	}
 }
 
 // DatabaseInput holds database input config.
 type DatabaseInput struct {
+	// Name identifies the source of the input in logs, errors and stats.
+	// Defaults to the file.
+	Name string `yaml:"name"`
+	// AddSourceField is the key under which the name of the source is added
+	// to every entry, eg. for provenance. With the deep merge strategy, the
+	// names of all sources of a network are merged into an array.
+	AddSourceField string `yaml:"addSourceField"`
+	// File is the path or URL of the input file. Local paths may be glob
+	// patterns, like "chunk-*.csv", which are read as one input per file.
 	File     string            `yaml:"file"`
+	Format   string            `yaml:"format"`
 	Fields   []string          `yaml:"fields"`
 	FieldMap map[string]string `yaml:"fieldMap"`
+	// Types overrides the types of the database for this input.
+	Types map[string]string `yaml:"types"`
+	// Priority defines the precedence of the input. Inputs with a higher
+	// priority win conflicts, regardless of the merge strategy. Inputs with
+	// the same priority keep their config order.
+	Priority int `yaml:"priority"`
+
+	// DropUnmapped ignores all source fields that are not in FieldMap.
+	DropUnmapped bool `yaml:"dropUnmapped"`
+	// AllowDuplicateKeys allows multiple source fields to map to the same key,
+	// in which case the last one wins. By default, this is an error.
+	AllowDuplicateKeys bool `yaml:"allowDuplicateKeys"`
+	// AllowEmptyGlob allows a glob pattern of File to match no files.
+	AllowEmptyGlob bool `yaml:"allowEmptyGlob"`
+	// ArchiveEntry is the file to read from a zip archive.
+	ArchiveEntry string `yaml:"archiveEntry"`
+	// Query is the SQL query used to read entries from a sqlite database.
+	Query string `yaml:"query"`
+	// Sheet is the name or 1-based index of the sheet read from an xlsx
+	// workbook. Defaults to the first sheet.
+	Sheet string `yaml:"sheet"`
+	// IncludeNetworks and ExcludeNetworks filter the networks of entries.
+	// If includes are set, networks must be contained in one of them.
+	// Networks contained in any of the excludes are skipped.
+	IncludeNetworks []string `yaml:"includeNetworks"`
+	ExcludeNetworks []string `yaml:"excludeNetworks"`
+	// Defaults holds values for fields that are missing or empty.
+	Defaults map[string]string `yaml:"defaults"`
+	// TrimSpace removes leading and trailing whitespace from all values,
+	// except from the fields in PreserveSpace.
+	TrimSpace     bool     `yaml:"trimSpace"`
+	PreserveSpace []string `yaml:"preserveSpace"`
+	// Transforms defines transforms of raw values by field, eg. "upper" or
+	// "trimPrefix:AS". They are applied before the values are converted.
+	Transforms map[string]string `yaml:"transforms"`
+	// Validate defines rules that processed values must pass, by field:
+	// a "min:max" range for numeric fields or a regular expression for all
+	// other fields. Values are checked after they are converted to their
+	// type. Invalid entries are handled by OnError.
+	Validate map[string]string `yaml:"validate"`
+	// IncludeIf defines conditions by field, eg. "is_proxy==true". Fields
+	// are omitted if their condition does not hold for the raw values.
+	IncludeIf map[string]string `yaml:"includeIf"`
+	// NullValues are raw values that mean "no data", eg. "N/A".
+	// Fields with these values are omitted, ignoring case.
+	NullValues []string `yaml:"nullValues"`
+
+	// SkipRows defines the amount of lines discarded at the start of a CSV
+	// file, before the header is read, eg. for vendor metadata.
+	SkipRows int `yaml:"skipRows"`
+	// HasHeader defines whether the first row of a CSV file is a header.
+	// If no fields are defined, the header is used as the fields.
+	HasHeader bool `yaml:"hasHeader"`
+	// Delimiter and Comment define the field delimiter and comment character
+	// of a CSV file. Both must be a single character, if set.
+	Delimiter string `yaml:"delimiter"`
+	Comment   string `yaml:"comment"`
+	// LazyQuotes and TrimLeadingSpace relax the parsing of a CSV file, see
+	// the fields of the same name of csv.Reader.
+	LazyQuotes       bool `yaml:"lazyQuotes"`
+	TrimLeadingSpace bool `yaml:"trimLeadingSpace"`
+	// AllowRaggedRows accepts CSV rows with fewer or more columns than
+	// fields. Missing columns are treated as missing values and extra columns
+	// are ignored.
+	AllowRaggedRows bool `yaml:"allowRaggedRows"`
+	// InferTypes enables inferring the types of CSV fields without declared
+	// type from the first rows.
+	InferTypes bool `yaml:"inferTypes"`
+
+	// OnError defines how invalid entries are handled: "return", "fail" or "skip".
+	OnError string `yaml:"onError"`
+	// ErrorCallback is called with the error of every invalid entry.
+	ErrorCallback func(error) `yaml:"-"`
+
+	// Timeout and Cache are used for inputs fetched via HTTP(S).
+	Timeout time.Duration `yaml:"timeout"`
+	Cache   string        `yaml:"cache"`
+
+	// optimize are the optimizations of the database, which are used to
+	// convert values for validation. It is set by LoadSources.
+	optimize Optimizations
+	// ctx cancels loading the input, like fetching it via HTTP(S) or
+	// querying a sqlite database. It is set by LoadSourcesContext.
+	ctx context.Context
+	// archive is the opened zip archive of the input, which is used once
+	// to read the archive entry. It is set by LoadSources.
+	archive *openedArchive
 }
 
 // Optimizations holds optimization config.
 type Optimizations struct {
-	FloatDecimals  int   `yaml:"floatDecimals"`
-	ForceIPVersion *bool `yaml:"forceIPVersion"`
-	MaxPrefix      int   `yaml:"maxPrefix"`
+	// FloatDecimals defines the decimals floats are rounded to. 0 disables
+	// rounding, and negative values round to integers.
+	FloatDecimals int `yaml:"floatDecimals"`
+	// FieldFloatDecimals overrides FloatDecimals by field, with the same
+	// values: use -1, not 0, to round the floats of a field to integers, as
+	// 0 disables rounding for the field.
+	FieldFloatDecimals map[string]int `yaml:"fieldFloatDecimals"`
+	ForceIPVersion     *bool          `yaml:"forceIPVersion"`
+	MaxPrefix          int            `yaml:"maxPrefix"`
+	ShrinkInts         bool           `yaml:"shrinkInts"`
+	ArraySeparator     string         `yaml:"arraySeparator"`
+	OmitZeroValues     bool           `yaml:"omitZeroValues"`
+	KeepZeroValues     []string       `yaml:"keepZeroValues"`
+	AggregateNetworks  bool           `yaml:"aggregateNetworks"`
+	DedupInserts       bool           `yaml:"dedupInserts"`
+	LenientNumbers     bool           `yaml:"lenientNumbers"`
+
+	// Intern lists fields, whose equal string values reuse the same instance
+	// during a build, eg. repeated city names. The amount of reused values is
+	// reported in the BuildStats.
+	Intern []string `yaml:"intern"`
+
+	// NormalizeStrings lists the normalizations applied to all values of the
+	// string type, in order: "trim", "lower" or "upper". If NormalizeFields
+	// is set, only the values of these fields are normalized. Values are
+	// normalized before they are interned.
+	NormalizeStrings []string `yaml:"normalizeStrings"`
+	NormalizeFields  []string `yaml:"normalizeFields"`
+
+	// OverflowMode defines how integer values that do not fit their type, and
+	// values out of range of the percent, permille, latitude and longitude
+	// types, are handled: "error" (default), "clamp" or "skip".
+	OverflowMode string `yaml:"overflowMode"`
+
+	// RoundingWarning is called when rounding a float to FloatDecimals changes
+	// it by more than RoundingEpsilon. It may be called concurrently.
+	RoundingWarning func(field string, original, rounded float64) `yaml:"-"`
+	RoundingEpsilon float64                                       `yaml:"roundingEpsilon"`
+
+	// field is the field the optimizations are used for, as set by ForField.
+	field string
+	// overflows counts the handled integer overflows, if set.
+	overflows *atomic.Int64
+	// interner interns the values of the Intern fields, if set.
+	interner *valueInterner
+}
+
+// Overflow modes define how integer values that do not fit their type are handled.
+const (
+	// OverflowModeError fails the conversion of the value. This is the default.
+	OverflowModeError = "error"
+	// OverflowModeClamp stores the nearest value that fits the type.
+	OverflowModeClamp = "clamp"
+	// OverflowModeSkip omits the value.
+	OverflowModeSkip = "skip"
+)
+
+// String normalizations of NormalizeStrings.
+const (
+	// NormalizeTrim removes leading and trailing whitespace.
+	NormalizeTrim = "trim"
+	// NormalizeLower converts strings to lower case.
+	NormalizeLower = "lower"
+	// NormalizeUpper converts strings to upper case.
+	NormalizeUpper = "upper"
+)
+
+// Validate checks if the optimizations are valid.
+func (o Optimizations) Validate() error {
+	for _, normalization := range o.NormalizeStrings {
+		switch normalization {
+		case NormalizeTrim, NormalizeLower, NormalizeUpper:
+		default:
+			return fmt.Errorf("unknown string normalization %q", normalization)
+		}
+	}
+	switch o.OverflowMode {
+	case "", OverflowModeError, OverflowModeClamp, OverflowModeSkip:
+		return nil
+	default:
+		return fmt.Errorf("unknown overflow mode %q", o.OverflowMode)
+	}
+}
+
+// handleOverflow handles the overflow error of an integer value according to
+// the overflow mode. It returns whether the value should be skipped, or the
+// error if the overflow is not handled. Clamped values are returned by the
+// parse functions of strconv.
+func (o Optimizations) handleOverflow(err error) (skip bool, _ error) {
+	switch o.OverflowMode {
+	case OverflowModeClamp, OverflowModeSkip:
+		if o.overflows != nil {
+			o.overflows.Add(1)
+		}
+		return o.OverflowMode == OverflowModeSkip, nil
+	default:
+		return false, err
+	}
+}
+
+// ForField returns the optimizations to use for the given field.
+func (o Optimizations) ForField(key string) Optimizations {
+	if decimals, ok := o.FieldFloatDecimals[key]; ok {
+		o.FloatDecimals = decimals
+	}
+	o.field = key
+	return o
 }
 
 // ForceIPVersionEnabled reports whether ForceIPVersion is set and true.
@@ -57,11 +353,101 @@ func (o Optimizations) ForceIPVersionEnabled() bool {
 	return o.ForceIPVersion != nil && *o.ForceIPVersion
 }
 
+// Merge strategies define how values of overlapping networks are merged.
+const (
+	// MergeStrategyTopLevel merges top level keys, new values replace existing
+	// ones. This is the default.
+	MergeStrategyTopLevel = "top-level"
+	// MergeStrategyDeep merges nested maps recursively, new values replace
+	// existing ones.
+	MergeStrategyDeep = "deep"
+	// MergeStrategyOverwrite replaces existing values entirely.
+	MergeStrategyOverwrite = "overwrite"
+	// MergeStrategyFirstWins merges nested maps recursively, but never
+	// replaces existing values.
+	MergeStrategyFirstWins = "first-wins"
+)
+
 // MergeConfig holds merge configuration.
 type MergeConfig struct {
+	Strategy          string                   `yaml:"strategy"`
 	AlwaysReplace     bool                     `yaml:"alwaysReplace"`
 	MergeArrays       bool                     `yaml:"mergeArrays"`
 	ConditionalResets []ConditionalResetConfig `yaml:"conditionalResets"`
+
+	// ArrayMerge defines how arrays of merged values are merged: "replace",
+	// "append" or "append-unique". It defaults to "append" if MergeArrays
+	// is enabled, and to "replace" otherwise.
+	ArrayMerge string `yaml:"arrayMerge"`
+	// FieldArrayMerge overrides ArrayMerge for specific fields.
+	FieldArrayMerge map[string]string `yaml:"fieldArrayMerge"`
+
+	// sourceFields are the keys of the added source names, which are merged
+	// into arrays by deep merges. They are set per build.
+	sourceFields []string
+	// onConflict is the conflict callback of the database, set per build.
+	onConflict func(network *net.IPNet, existing, incoming mmdbtype.DataType) (mmdbtype.DataType, error)
+}
+
+// Array merge policies define how arrays of merged values are merged.
+const (
+	// ArrayMergeReplace replaces the array according to the merge strategy.
+	ArrayMergeReplace = "replace"
+	// ArrayMergeAppend concatenates the arrays.
+	ArrayMergeAppend = "append"
+	// ArrayMergeAppendUnique concatenates the arrays and removes duplicate
+	// entries, keeping the first occurrence.
+	ArrayMergeAppendUnique = "append-unique"
+)
+
+// arrayMergeFor returns the array merge policy of the given field.
+func (m MergeConfig) arrayMergeFor(key string) string {
+	if policy, ok := m.FieldArrayMerge[key]; ok {
+		return policy
+	}
+	switch {
+	case m.ArrayMerge != "":
+		return m.ArrayMerge
+	case m.MergeArrays:
+		return ArrayMergeAppend
+	default:
+		return ArrayMergeReplace
+	}
+}
+
+// StrategyName returns the name of the configured strategy, including the default.
+func (m MergeConfig) StrategyName() string {
+	if m.Strategy == "" {
+		return MergeStrategyTopLevel
+	}
+	return m.Strategy
+}
+
+// Validate checks if the merge config is valid.
+func (m MergeConfig) Validate() error {
+	switch m.StrategyName() {
+	case MergeStrategyTopLevel, MergeStrategyDeep, MergeStrategyOverwrite, MergeStrategyFirstWins:
+	default:
+		return fmt.Errorf("unknown merge strategy %q", m.Strategy)
+	}
+	if err := validateArrayMerge(m.ArrayMerge); err != nil {
+		return err
+	}
+	for field, policy := range m.FieldArrayMerge {
+		if err := validateArrayMerge(policy); err != nil {
+			return fmt.Errorf("%s: %w", field, err)
+		}
+	}
+	return nil
+}
+
+func validateArrayMerge(policy string) error {
+	switch policy {
+	case "", ArrayMergeReplace, ArrayMergeAppend, ArrayMergeAppendUnique:
+		return nil
+	default:
+		return fmt.Errorf("unknown array merge policy %q", policy)
+	}
 }
 
 // ConditionalResetConfig defines a conditional reset merge config.
@@ -70,6 +456,38 @@ type ConditionalResetConfig struct {
 	Reset     []string `yaml:"reset"`
 }
 
+// Mapping defines a value mapping table, used with the "map:<name>" type.
+type Mapping struct {
+	Type    string            `yaml:"type"`
+	Values  map[string]string `yaml:"values"`
+	Default string            `yaml:"default"`
+	Strict  bool              `yaml:"strict"`
+}
+
+// ValueType returns the type of the mapped values.
+func (m Mapping) ValueType() string {
+	if m.Type == "" {
+		return "string"
+	}
+	return m.Type
+}
+
+// Map returns the mapped value for the given raw value.
+// Unmapped values return an error in strict mode and the default otherwise.
+func (m Mapping) Map(value string) (string, error) {
+	mapped, ok := m.Values[value]
+	switch {
+	case ok:
+		return mapped, nil
+	case m.Strict:
+		return "", fmt.Errorf("value %q is not mapped", value)
+	case m.Default == "":
+		return "", fmt.Errorf("value %q is not mapped and no default is set", value)
+	default:
+		return m.Default, nil
+	}
+}
+
 // LoadConfig loads a configuration file.
 func LoadConfig(filePath string) (*Config, error) {
 	data, err := os.ReadFile(filePath)
@@ -93,19 +511,92 @@ func (d DefaultConfig) ApplyTo(c *DatabaseConfig) {
 		}
 	}
 
+	// Add all missing default mappings.
+	if c.Mappings == nil && len(d.Mappings) > 0 {
+		c.Mappings = make(map[string]Mapping)
+	}
+	for k, v := range d.Mappings {
+		_, ok := c.Mappings[k]
+		if !ok {
+			c.Mappings[k] = v
+		}
+	}
+
 	// Apply Optimizations.
 	if c.Optimize.FloatDecimals == 0 && d.Optimize.FloatDecimals != 0 {
 		c.Optimize.FloatDecimals = d.Optimize.FloatDecimals
 	}
+	if c.Optimize.FieldFloatDecimals == nil && len(d.Optimize.FieldFloatDecimals) > 0 {
+		c.Optimize.FieldFloatDecimals = make(map[string]int)
+	}
+	for k, v := range d.Optimize.FieldFloatDecimals {
+		_, ok := c.Optimize.FieldFloatDecimals[k]
+		if !ok {
+			c.Optimize.FieldFloatDecimals[k] = v
+		}
+	}
 	if c.Optimize.ForceIPVersion == nil && d.Optimize.ForceIPVersion != nil {
 		c.Optimize.ForceIPVersion = d.Optimize.ForceIPVersion
 	}
 	if c.Optimize.MaxPrefix == 0 && d.Optimize.MaxPrefix != 0 {
 		c.Optimize.MaxPrefix = d.Optimize.MaxPrefix
 	}
+	if !c.Optimize.ShrinkInts && d.Optimize.ShrinkInts {
+		c.Optimize.ShrinkInts = d.Optimize.ShrinkInts
+	}
+	if c.Optimize.ArraySeparator == "" && d.Optimize.ArraySeparator != "" {
+		c.Optimize.ArraySeparator = d.Optimize.ArraySeparator
+	}
+	if !c.Optimize.OmitZeroValues && d.Optimize.OmitZeroValues {
+		c.Optimize.OmitZeroValues = d.Optimize.OmitZeroValues
+	}
+	if len(c.Optimize.KeepZeroValues) == 0 && len(d.Optimize.KeepZeroValues) != 0 {
+		c.Optimize.KeepZeroValues = d.Optimize.KeepZeroValues
+	}
+	if !c.Optimize.AggregateNetworks && d.Optimize.AggregateNetworks {
+		c.Optimize.AggregateNetworks = d.Optimize.AggregateNetworks
+	}
+	if !c.Optimize.DedupInserts && d.Optimize.DedupInserts {
+		c.Optimize.DedupInserts = d.Optimize.DedupInserts
+	}
+	if len(c.Optimize.Intern) == 0 && len(d.Optimize.Intern) != 0 {
+		c.Optimize.Intern = d.Optimize.Intern
+	}
+	if !c.Optimize.LenientNumbers && d.Optimize.LenientNumbers {
+		c.Optimize.LenientNumbers = d.Optimize.LenientNumbers
+	}
+	if len(c.Optimize.NormalizeStrings) == 0 && len(d.Optimize.NormalizeStrings) != 0 {
+		c.Optimize.NormalizeStrings = d.Optimize.NormalizeStrings
+	}
+	if len(c.Optimize.NormalizeFields) == 0 && len(d.Optimize.NormalizeFields) != 0 {
+		c.Optimize.NormalizeFields = d.Optimize.NormalizeFields
+	}
+	if c.Optimize.OverflowMode == "" && d.Optimize.OverflowMode != "" {
+		c.Optimize.OverflowMode = d.Optimize.OverflowMode
+	}
+	if c.Optimize.RoundingWarning == nil && d.Optimize.RoundingWarning != nil {
+		c.Optimize.RoundingWarning = d.Optimize.RoundingWarning
+	}
+	if c.Optimize.RoundingEpsilon == 0 && d.Optimize.RoundingEpsilon != 0 {
+		c.Optimize.RoundingEpsilon = d.Optimize.RoundingEpsilon
+	}
 
 	// Apply Merge Config.
+	if c.Merge.Strategy == "" && d.Merge.Strategy != "" {
+		c.Merge.Strategy = d.Merge.Strategy
+	}
 	if len(c.Merge.ConditionalResets) == 0 && len(d.Merge.ConditionalResets) != 0 {
 		c.Merge.ConditionalResets = d.Merge.ConditionalResets
 	}
+	if c.Merge.ArrayMerge == "" && d.Merge.ArrayMerge != "" {
+		c.Merge.ArrayMerge = d.Merge.ArrayMerge
+	}
+	if c.Merge.FieldArrayMerge == nil && len(d.Merge.FieldArrayMerge) > 0 {
+		c.Merge.FieldArrayMerge = make(map[string]string)
+	}
+	for k, v := range d.Merge.FieldArrayMerge {
+		if _, ok := c.Merge.FieldArrayMerge[k]; !ok {
+			c.Merge.FieldArrayMerge[k] = v
+		}
+	}
 }
@@ -0,0 +1,55 @@
+package mmdbmeld
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// dumpEntry is a network with its record, as written by DumpJSON.
+type dumpEntry struct {
+	Network string `json:"network"`
+	Values  any    `json:"values"`
+}
+
+// DumpJSON builds the database of the given config in memory and writes
+// every network with its record to w, as one json object per line, eg.
+// {"network":"192.0.2.0/24","values":{"country":{"iso_code":"AT"}}}.
+// As the dump is read from the built database, it reflects everything that
+// is written to it, including filters, merges and optimizations. Networks
+// are written in order, IPv4 networks of IPv6 databases only once.
+// Bytes are encoded as base64 strings.
+func DumpJSON(dbConfig DatabaseConfig, w io.Writer) error {
+	var buf bytes.Buffer
+	dbConfig.Compress = "" // Read back from memory.
+	if err := BuildToWriter(dbConfig, &buf); err != nil {
+		return err
+	}
+	reader, err := maxminddb.FromBytes(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to read built database: %w", err)
+	}
+
+	encoder := json.NewEncoder(w)
+	networks := reader.Networks(maxminddb.SkipAliasedNetworks)
+	for networks.Next() {
+		var record any
+		network, err := networks.Network(&record)
+		if err != nil {
+			return fmt.Errorf("failed to read network: %w", err)
+		}
+		if err := encoder.Encode(dumpEntry{
+			Network: network.String(),
+			Values:  record,
+		}); err != nil {
+			return fmt.Errorf("failed to write %s: %w", network, err)
+		}
+	}
+	if err := networks.Err(); err != nil {
+		return fmt.Errorf("failed to read networks: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package mmdbmeld
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDumpJSON(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"a.csv": "192.0.2.0,192.0.2.255,AT\n198.51.100.0,198.51.100.255,DE\n",
+		"b.csv": "192.0.2.128,192.0.2.255,CH,64496\n203.0.113.0,203.0.113.255,FR,64497\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dbConfig := DatabaseConfig{
+		Name: "Test",
+		MMDB: MMDBConfig{IPVersion: 6, RecordSize: 24},
+		Types: map[string]string{
+			"country.iso_code":         "string",
+			"autonomous_system_number": "uint32",
+		},
+		Inputs: []DatabaseInput{{
+			File:   filepath.Join(dir, "a.csv"),
+			Fields: []string{"from", "to", "country.iso_code"},
+		}, {
+			File:            filepath.Join(dir, "b.csv"),
+			Fields:          []string{"from", "to", "country.iso_code", "autonomous_system_number"},
+			ExcludeNetworks: []string{"203.0.113.0/24"},
+		}},
+	}
+
+	var buf bytes.Buffer
+	if err := DumpJSON(dbConfig, &buf); err != nil {
+		t.Fatal(err)
+	}
+	// Overlapping networks are merged and filtered networks are missing.
+	expected := `{"network":"192.0.2.0/25","values":{"country":{"iso_code":"AT"}}}
+{"network":"192.0.2.128/25","values":{"autonomous_system_number":64496,"country":{"iso_code":"CH"}}}
+{"network":"198.51.100.0/24","values":{"country":{"iso_code":"DE"}}}
+`
+	if buf.String() != expected {
+		t.Fatalf("unexpected dump:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,40 @@
+package mmdbmeld
+
+import (
+	"fmt"
+)
+
+// ForEachEntry loads all sources of the given config and calls fn for every
+// entry, in the same order as they are inserted into the database.
+// Entries are passed as read from the source, before mappings are applied.
+// Reading stops at the first error returned by fn, which is then returned.
+// Invalid entries also stop reading, unless the input is configured to
+// skip them with onError.
+func ForEachEntry(dbConfig DatabaseConfig, fn func(*SourceEntry) error) error {
+	sources, err := LoadSources(dbConfig)
+	if err != nil {
+		return err
+	}
+	defer closeSources(sources)
+
+	for _, source := range sources {
+		for {
+			entry, err := source.NextEntry()
+			if err != nil {
+				return fmt.Errorf("failed to parse entry: %w", err)
+			}
+			if entry == nil {
+				break
+			}
+
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+		if source.Err() != nil {
+			return fmt.Errorf("source %s failed: %w", source.Name(), source.Err())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package mmdbmeld
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestForEachEntry(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.csv")
+	if err := os.WriteFile(first, []byte("192.0.2.0,192.0.2.255,AT\n198.51.100.0,198.51.100.255,DE\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	second := filepath.Join(dir, "second.csv")
+	if err := os.WriteFile(second, []byte("203.0.113.0,203.0.113.255,CH\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dbConfig := DatabaseConfig{
+		Types: map[string]string{"country.iso_code": "string"},
+		Inputs: []DatabaseInput{
+			{File: first, Fields: []string{"from", "to", "country.iso_code"}},
+			{File: second, Fields: []string{"from", "to", "country.iso_code"}},
+		},
+	}
+
+	// Entries are visited in input order.
+	var countries []string
+	err := ForEachEntry(dbConfig, func(entry *SourceEntry) error {
+		countries = append(countries, entry.Values["country.iso_code"].Value)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(countries, ",") != "AT,DE,CH" {
+		t.Fatalf("unexpected entries: %v", countries)
+	}
+
+	// Errors of the callback stop reading.
+	stop := errors.New("stop")
+	var visited int
+	err = ForEachEntry(dbConfig, func(entry *SourceEntry) error {
+		visited++
+		return stop
+	})
+	if !errors.Is(err, stop) || visited != 1 {
+		t.Fatalf("unexpected result: %v after %d entries", err, visited)
+	}
+}
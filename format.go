@@ -0,0 +1,262 @@
+package mmdbmeld
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// FormatMMDBValue returns the raw value of the given field type, which is
+// converted to the given mmdb value by SourceValue.ToMMDBType. It reverses
+// the conversion, eg. to display and edit values of a built database.
+// Array entries are joined with the separator of the type, or the
+// ArraySeparator of the optimizations, or a space.
+// Values that would not convert back to the same value, like arrays with
+// entries containing the separator, return an error, as do mapped types.
+func FormatMMDBValue(fieldType string, value mmdbtype.DataType, optim Optimizations) (string, error) {
+	subType, isArrayType := strings.CutPrefix(fieldType, "array:")
+	if !isArrayType {
+		return formatMMDBType(fieldType, value)
+	}
+
+	entryType, separator, ok := cutArraySeparator(subType)
+	if !ok {
+		separator = optim.ArraySeparator
+	}
+	array, ok := value.(mmdbtype.Slice)
+	if !ok {
+		return "", fmt.Errorf("%s value must be a slice, not a %T", fieldType, value)
+	}
+	fields := make([]string, 0, len(array))
+	for i, entry := range array {
+		field, err := formatMMDBType(entryType, entry)
+		if err != nil {
+			return "", fmt.Errorf("array entry #%d is invalid: %w", i, err)
+		}
+		if len(splitArray(field, separator)) != 1 || strings.TrimSpace(field) != field {
+			return "", fmt.Errorf("array entry #%d %q cannot be separated", i, field)
+		}
+		fields = append(fields, field)
+	}
+	if separator == "" {
+		separator = " "
+	}
+	return strings.Join(fields, separator), nil
+}
+
+func formatMMDBType(fieldType string, value mmdbtype.DataType) (string, error) {
+	// Handle types with parameters.
+	if layout, ok := strings.CutPrefix(fieldType, "datetime:"); ok {
+		return formatMMDBDatetime(value, layout)
+	}
+	if spec, ok := strings.CutPrefix(fieldType, "scaledint:"); ok {
+		return formatMMDBScaledInt(spec, value)
+	}
+	if strings.HasPrefix(fieldType, "map:") {
+		return "", errors.New("mapped values cannot be formatted")
+	}
+
+	if _, ok := intTypes[fieldType]; ok {
+		if v, ok := mmdbInteger(value); ok {
+			return v.String(), nil
+		}
+		return "", fmt.Errorf("%s value must be an integer, not a %T", fieldType, value)
+	}
+
+	switch fieldType {
+	case "bool":
+		if v, ok := value.(mmdbtype.Bool); ok {
+			return strconv.FormatBool(bool(v)), nil
+		}
+
+	case "string", "ip", "network":
+		if v, ok := value.(mmdbtype.String); ok {
+			return string(v), nil
+		}
+
+	case "hexbytes":
+		if v, ok := value.(mmdbtype.Bytes); ok {
+			return hex.EncodeToString(v), nil
+		}
+
+	case "base64bytes":
+		if v, ok := value.(mmdbtype.Bytes); ok {
+			return base64.StdEncoding.EncodeToString(v), nil
+		}
+
+	case "base64url":
+		if v, ok := value.(mmdbtype.Bytes); ok {
+			return base64.RawURLEncoding.EncodeToString(v), nil
+		}
+
+	case "uint128":
+		if v, ok := value.(*mmdbtype.Uint128); ok {
+			return (*big.Int)(v).String(), nil
+		}
+
+	case "float32":
+		if v, ok := value.(mmdbtype.Float32); ok {
+			return strconv.FormatFloat(float64(v), 'f', -1, 32), nil
+		}
+
+	case "float64", "latitude", "longitude":
+		if v, ok := value.(mmdbtype.Float64); ok {
+			return strconv.FormatFloat(float64(v), 'f', -1, 64), nil
+		}
+
+	case "percent", "permille":
+		if v, ok := value.(mmdbtype.Float32); ok {
+			base := 100.0
+			if fieldType == "permille" {
+				base = 1000
+			}
+			return strconv.FormatFloat(float64(v)*base, 'f', -1, 32), nil
+		}
+
+	case "json":
+		switch value.(type) {
+		case mmdbtype.Map, mmdbtype.Slice:
+			v, err := mmdbToJSON(value)
+			if err != nil {
+				return "", err
+			}
+			data, err := json.Marshal(v)
+			if err != nil {
+				return "", err
+			}
+			return string(data), nil
+		}
+
+	case "datetime":
+		return formatMMDBDatetime(value, time.RFC3339)
+
+	case "duration":
+		if v, ok := mmdbInteger(value); ok && v.Sign() >= 0 && v.Cmp(big.NewInt(math.MaxUint32)) <= 0 {
+			return (time.Duration(v.Int64()) * time.Second).String(), nil
+		}
+
+	case "ipbytes":
+		if v, ok := value.(mmdbtype.Bytes); ok && (len(v) == net.IPv4len || len(v) == net.IPv6len) {
+			return net.IP(v).String(), nil
+		}
+
+	default:
+		return "", errors.New("unsupported type")
+	}
+	return "", fmt.Errorf("invalid %s value of type %T", fieldType, value)
+}
+
+// mmdbInteger returns the value of an mmdb integer type.
+func mmdbInteger(value mmdbtype.DataType) (*big.Int, bool) {
+	switch v := value.(type) {
+	case mmdbtype.Int32:
+		return big.NewInt(int64(v)), true
+	case mmdbtype.Uint16:
+		return new(big.Int).SetUint64(uint64(v)), true
+	case mmdbtype.Uint32:
+		return new(big.Int).SetUint64(uint64(v)), true
+	case mmdbtype.Uint64:
+		return new(big.Int).SetUint64(uint64(v)), true
+	case *mmdbtype.Uint128:
+		return new(big.Int).Set((*big.Int)(v)), true
+	default:
+		return nil, false
+	}
+}
+
+// formatMMDBDatetime formats unix epoch seconds in UTC with the given layout.
+func formatMMDBDatetime(value mmdbtype.DataType, layout string) (string, error) {
+	v, ok := value.(mmdbtype.Uint64)
+	if !ok || v > math.MaxInt64 {
+		return "", fmt.Errorf("datetime value must be unix epoch seconds, not a %T", value)
+	}
+	return time.Unix(int64(v), 0).UTC().Format(layout), nil
+}
+
+// formatMMDBScaledInt divides the stored integer by the scale of the spec
+// "<type>:<scale>".
+func formatMMDBScaledInt(spec string, value mmdbtype.DataType) (string, error) {
+	typeName, scaleSpec, _ := strings.Cut(spec, ":")
+	if _, ok := intTypes[typeName]; !ok {
+		return "", fmt.Errorf("invalid integer type %q of scaledint", typeName)
+	}
+	scale, err := strconv.ParseFloat(scaleSpec, 64)
+	if err != nil || scale <= 0 || math.IsInf(scale, 0) {
+		return "", fmt.Errorf("invalid scale %q of scaledint, must be a positive number", scaleSpec)
+	}
+	v, ok := mmdbInteger(value)
+	if !ok {
+		return "", fmt.Errorf("scaledint value must be an integer, not a %T", value)
+	}
+	f, _ := new(big.Float).SetInt(v).Float64()
+	return strconv.FormatFloat(f/scale, 'f', -1, 64), nil
+}
+
+// mmdbToJSON converts mmdb values to values that encode to json, which
+// jsonToMMDB converts back to the same mmdb types. Floats are encoded with
+// a decimal point, so that they are not read back as integers.
+func mmdbToJSON(value mmdbtype.DataType) (any, error) {
+	switch v := value.(type) {
+	case mmdbtype.Map:
+		m := make(map[string]any, len(v))
+		for key, subValue := range v {
+			converted, err := mmdbToJSON(subValue)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			m[string(key)] = converted
+		}
+		return m, nil
+
+	case mmdbtype.Slice:
+		array := make([]any, 0, len(v))
+		for i, entry := range v {
+			converted, err := mmdbToJSON(entry)
+			if err != nil {
+				return nil, fmt.Errorf("array entry #%d is invalid: %w", i, err)
+			}
+			array = append(array, converted)
+		}
+		return array, nil
+
+	case mmdbtype.String:
+		return string(v), nil
+
+	case mmdbtype.Bool:
+		return bool(v), nil
+
+	case mmdbtype.Float32:
+		return jsonFloat(float64(v), 32)
+
+	case mmdbtype.Float64:
+		return jsonFloat(float64(v), 64)
+
+	default:
+		if i, ok := mmdbInteger(value); ok {
+			return json.Number(i.String()), nil
+		}
+		return nil, fmt.Errorf("unsupported json value type %T", value)
+	}
+}
+
+// jsonFloat returns the float as json number with a decimal point.
+func jsonFloat(v float64, bitSize int) (json.Number, error) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return "", fmt.Errorf("unsupported float value %v", v)
+	}
+	formatted := strconv.FormatFloat(v, 'f', -1, bitSize)
+	if !strings.Contains(formatted, ".") {
+		formatted += ".0"
+	}
+	return json.Number(formatted), nil
+}
@@ -0,0 +1,77 @@
+package mmdbmeld
+
+import (
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+func TestFormatMMDBValue(t *testing.T) {
+	t.Parallel()
+
+	optim := Optimizations{ShrinkInts: true}
+	for _, test := range []struct {
+		fieldType, value string
+	}{
+		{"int8", "-8"},
+		{"int32", "-5"},
+		{"int64", "3000000000"},
+		{"uint8", "8"},
+		{"uint16", "65535"},
+		{"uint32", "70000"},
+		{"uint64", "18446744073709551615"},
+		{"uint128", "340282366920938463463374607431768211455"},
+		{"scaledint:int32:10000", "48.2082"},
+		{"bool", "true"},
+		{"string", "Wien"},
+		{"hexbytes", "c0ffee"},
+		{"base64bytes", "wP/u"},
+		{"base64url", "wP_u"},
+		{"float32", "1.5"},
+		{"float64", "-16.37245"},
+		{"json", `{"a":[1,2.0,"x",true],"b":{"c":3000000000}}`},
+		{"datetime", "2024-01-02T03:04:05Z"},
+		{"datetime:2006-01-02", "2024-01-02"},
+		{"duration", "1h30m0s"},
+		{"percent", "42.5"},
+		{"permille", "5"},
+		{"latitude", "-90"},
+		{"longitude", "16.37245"},
+		{"array:datetime", "2024-01-02T03:04:05Z 2024-01-03T00:00:00Z"},
+		{"ip", "2001:db8::1"},
+		{"ipbytes", "192.0.2.1"},
+		{"network", "192.0.2.0/24"},
+		{"array:uint32", "1 2 70000"},
+		{"array:string:,", "a b,c"},
+		{"array:scaledint:int32:10", "1.2 -3.1"},
+	} {
+		value, err := SourceValue{Type: test.fieldType, Value: test.value}.ToMMDBType(optim)
+		if err != nil {
+			t.Fatalf("%s %s: %s", test.fieldType, test.value, err)
+		}
+		formatted, err := FormatMMDBValue(test.fieldType, value, optim)
+		if err != nil {
+			t.Fatalf("%s %s: failed to format: %s", test.fieldType, test.value, err)
+		}
+		if formatted != test.value {
+			t.Fatalf("%s: unexpected formatted value for %s: %s", test.fieldType, test.value, formatted)
+		}
+	}
+
+	// Values that cannot be converted back fail.
+	for _, test := range []struct {
+		fieldType string
+		value     mmdbtype.DataType
+	}{
+		{"array:string", mmdbtype.Slice{mmdbtype.String("a b")}},
+		{"array:string:,", mmdbtype.Slice{mmdbtype.String("")}},
+		{"string", mmdbtype.Bool(true)},
+		{"map:countries", mmdbtype.String("AT")},
+		{"ipbytes", mmdbtype.Bytes{1, 2, 3}},
+		{"json", mmdbtype.String("{}")},
+	} {
+		if formatted, err := FormatMMDBValue(test.fieldType, test.value, optim); err == nil {
+			t.Fatalf("%s: expected error for %v, got %q", test.fieldType, test.value, formatted)
+		}
+	}
+}
@@ -0,0 +1,524 @@
+package mmdbmeld
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DefaultHTTPTimeout is the timeout used for fetching inputs over HTTP(S),
+// if no timeout is configured.
+const DefaultHTTPTimeout = 10 * time.Minute
+
+// isURL reports whether the given input file is a HTTP(S) URL.
+func isURL(file string) bool {
+	return strings.HasPrefix(file, "http://") || strings.HasPrefix(file, "https://")
+}
+
+// expandPath expands environment variables in the form $VAR or ${VAR} in
+// the path. Variables that are not set fail, unless a default is given in the
+// form ${VAR:-default}, which is also used if the variable is empty.
+func expandPath(path string) (string, error) {
+	var err error
+	expanded := os.Expand(path, func(name string) string {
+		name, fallback, hasFallback := strings.Cut(name, ":-")
+		value, ok := os.LookupEnv(name)
+		switch {
+		case value != "":
+			return value
+		case hasFallback:
+			return fallback
+		case !ok && err == nil:
+			err = fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value
+	})
+	if err != nil {
+		return "", err
+	}
+	return expanded, nil
+}
+
+// expandInputs returns the inputs with the environment variables in their
+// paths expanded. Inputs with a glob pattern as path, like "chunk-*.csv",
+// are replaced by an input for every matching file, in sorted order. A
+// pattern matching no files fails, unless AllowEmptyGlob is set.
+func expandInputs(inputs []DatabaseInput) ([]DatabaseInput, error) {
+	expanded := make([]DatabaseInput, 0, len(inputs))
+	for _, input := range inputs {
+		file, err := expandPath(input.File)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path of input file %s: %w", input.File, err)
+		}
+		input.File = file
+		if isURL(file) || isStdin(input) || !strings.ContainsAny(file, "*?[") {
+			expanded = append(expanded, input)
+			continue
+		}
+
+		matches, err := filepath.Glob(file)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern of input file %s: %w", file, err)
+		}
+		if len(matches) == 0 && !input.AllowEmptyGlob {
+			return nil, fmt.Errorf("input file pattern %s matches no files", file)
+		}
+		slices.Sort(matches)
+		for _, match := range matches {
+			matched := input
+			matched.File = match
+			if input.Name != "" {
+				matched.Name = fmt.Sprintf("%s (%s)", input.Name, filepath.Base(match))
+			}
+			expanded = append(expanded, matched)
+		}
+	}
+	return expanded, nil
+}
+
+// sourceSuffixes maps the file suffixes of all supported source formats to
+// their format.
+var sourceSuffixes = []struct{ suffix, format string }{
+	{".csv", "csv"},
+	{".tsv", "tsv"},
+	{".json", "json"},
+	{".jsonl", "jsonl"},
+	{".ndjson", "ndjson"},
+	{".mmdb", "mmdb"},
+	{".yaml", "yaml"},
+	{".yml", "yaml"},
+	{".geofeed", "geofeed"},
+	{".sqlite", "sqlite"},
+	{".db", "sqlite"},
+	{".ipfire.txt", "ipfire"},
+	{".mrt", "mrt"},
+	{".xlsx", "xlsx"},
+}
+
+// rirFilePrefix is the name prefix of RIR statistics files, which are
+// detected by name, eg. delegated-ripencc-extended-latest.
+const rirFilePrefix = "delegated-"
+
+// stdinFile is the input file that reads from stdin.
+const stdinFile = "-"
+
+// sourceFormats returns the sorted names of the supported source formats,
+// including the formats of the given loaders. Formats that need a file, like
+// SQLite, are left out for stdin.
+func sourceFormats(stdin bool, loaders map[string]SourceLoader) []string {
+	formats := make([]string, 0, len(sourceSuffixes)+len(loaders))
+	for _, s := range sourceSuffixes {
+		if stdin && s.format == "sqlite" {
+			continue
+		}
+		formats = append(formats, s.format)
+	}
+	formats = append(formats, "rir")
+	for format := range loaders {
+		formats = append(formats, format)
+	}
+	slices.Sort(formats)
+	return slices.Compact(formats)
+}
+
+// inputFormat returns the format of the input. The format of the config
+// takes precedence, otherwise it is detected from the file suffix, without
+// the suffix of a compression. Formats of the loaders are only selected by
+// the format of the config.
+func inputFormat(input DatabaseInput, loaders map[string]SourceLoader) (string, error) {
+	if input.Format != "" {
+		if formats := sourceFormats(false, loaders); !slices.Contains(formats, input.Format) {
+			return "", fmt.Errorf("unsupported format %q, must be one of: %s", input.Format, strings.Join(formats, ", "))
+		}
+		return input.Format, nil
+	}
+
+	fileName := trimCompressionSuffix(inputPath(input))
+	for _, s := range sourceSuffixes {
+		if strings.HasSuffix(fileName, s.suffix) {
+			return s.format, nil
+		}
+	}
+	// RIR statistics have no suffix, but are published with a common name.
+	if strings.HasPrefix(path.Base(fileName), rirFilePrefix) {
+		return "rir", nil
+	}
+	return "", errors.New("unsupported file suffix, set the format")
+}
+
+// isStdin reports whether the input reads from stdin.
+func isStdin(input DatabaseInput) bool {
+	return input.File == stdinFile
+}
+
+// filePath returns the path of the input file. For URLs, this is the path
+// component of the URL.
+func filePath(input DatabaseInput) string {
+	if isURL(input.File) {
+		if u, err := url.Parse(input.File); err == nil {
+			return u.Path
+		}
+	}
+	return input.File
+}
+
+// inputPath returns the path of the input file, which is used to detect the
+// format. For URLs, this is the path component of the URL. For archives, this
+// is the name of the archive entry.
+func inputPath(input DatabaseInput) string {
+	if isArchive(input) {
+		return input.ArchiveEntry
+	}
+	return filePath(input)
+}
+
+// isArchive reports whether the input file is a zip archive.
+func isArchive(input DatabaseInput) bool {
+	return strings.HasSuffix(filePath(input), ".zip")
+}
+
+// openInput opens the given input file for reading.
+// Files may be fetched via HTTP(S), entries are read from zip archives and
+// files ending in ".gz" or ".bz2" are transparently decompressed.
+func openInput(input DatabaseInput) (io.ReadCloser, error) {
+	var (
+		file io.ReadCloser
+		err  error
+	)
+	if isArchive(input) {
+		file, err = openArchiveEntry(input)
+	} else {
+		file, err = openFile(input)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(inputPath(input), ".bz2") {
+		return &bzip2File{
+			Reader: bzip2.NewReader(bufio.NewReader(file)),
+			file:   file,
+		}, nil
+	}
+	if !strings.HasSuffix(inputPath(input), ".gz") {
+		return file, nil
+	}
+
+	gzipReader, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to open gzip stream: %w", err)
+	}
+	return &gzipFile{
+		Reader: gzipReader,
+		file:   file,
+	}, nil
+}
+
+// openFile opens the input file, fetching it via HTTP(S) if needed.
+func openFile(input DatabaseInput) (io.ReadCloser, error) {
+	if isURL(input.File) {
+		return fetchInput(input)
+	}
+	if isStdin(input) {
+		// Do not close stdin with the source.
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(input.File)
+}
+
+// openArchive opens the input file as a zip archive.
+// Remote archives are read into memory.
+func openArchive(input DatabaseInput) (*zip.Reader, io.Closer, error) {
+	file, err := openFile(input)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		readerAt io.ReaderAt
+		size     int64
+	)
+	if osFile, ok := file.(*os.File); ok {
+		stat, err := osFile.Stat()
+		if err != nil {
+			_ = file.Close()
+			return nil, nil, err
+		}
+		readerAt, size = osFile, stat.Size()
+	} else {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			_ = file.Close()
+			return nil, nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		readerAt, size = bytes.NewReader(data), int64(len(data))
+	}
+
+	archive, err := zip.NewReader(readerAt, size)
+	if err != nil {
+		_ = file.Close()
+		return nil, nil, fmt.Errorf("failed to open zip archive: %w", err)
+	}
+	return archive, file, nil
+}
+
+// openedArchive is a zip archive that was opened to resolve the archive
+// entry of an input, and is kept to read the entry, so that the archive is
+// not opened, or fetched, again.
+type openedArchive struct {
+	reader *zip.Reader
+	closer io.Closer
+	taken  bool
+}
+
+// take returns the archive for reading the entry. It returns false, if the
+// archive was already taken.
+func (oa *openedArchive) take() (*zip.Reader, io.Closer, bool) {
+	if oa == nil || oa.taken {
+		return nil, nil, false
+	}
+	oa.taken = true
+	return oa.reader, oa.closer, true
+}
+
+// release closes the archive, if it was not taken.
+func (oa *openedArchive) release() {
+	if _, file, ok := oa.take(); ok {
+		_ = file.Close()
+	}
+}
+
+// openArchiveEntry opens the configured entry of the zip archive. The
+// archive opened by resolveArchiveEntry is used, if it was not taken yet.
+func openArchiveEntry(input DatabaseInput) (io.ReadCloser, error) {
+	if input.ArchiveEntry == "" {
+		return nil, errors.New("no archive entry defined")
+	}
+	archive, file, ok := input.archive.take()
+	if !ok {
+		var err error
+		archive, file, err = openArchive(input)
+		if err != nil {
+			return nil, err
+		}
+	}
+	entry, err := archive.Open(input.ArchiveEntry)
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to open archive entry: %w", err)
+	}
+	return &archiveEntry{
+		Reader:  entry,
+		entry:   entry,
+		archive: file,
+	}, nil
+}
+
+// resolveArchiveEntry opens the archive of the input, if it is one, and keeps
+// it to read the archive entry. If no entry is configured, the only entry
+// with a supported suffix is used. The archive must be released after the
+// source is loaded.
+func resolveArchiveEntry(input DatabaseInput) (DatabaseInput, error) {
+	if !isArchive(input) {
+		return input, nil
+	}
+
+	archive, file, err := openArchive(input)
+	if err != nil {
+		return input, err
+	}
+	if input.ArchiveEntry != "" {
+		input.archive = &openedArchive{reader: archive, closer: file}
+		return input, nil
+	}
+
+	// With an explicit format, any file may be the input.
+	var candidates []string
+	for _, entry := range archive.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+		if input.Format != "" {
+			candidates = append(candidates, entry.Name)
+			continue
+		}
+		name := trimCompressionSuffix(entry.Name)
+		for _, s := range sourceSuffixes {
+			if strings.HasSuffix(name, s.suffix) {
+				candidates = append(candidates, entry.Name)
+				break
+			}
+		}
+	}
+	if len(candidates) != 1 {
+		_ = file.Close()
+		return input, fmt.Errorf("archive must contain exactly one supported file, or set archiveEntry: found %q", candidates)
+	}
+	input.ArchiveEntry = candidates[0]
+	input.archive = &openedArchive{reader: archive, closer: file}
+	return input, nil
+}
+
+// archiveEntry closes both the archive entry and the underlying file.
+type archiveEntry struct {
+	io.Reader
+	entry   io.Closer
+	archive io.Closer
+}
+
+func (ae *archiveEntry) Close() error {
+	entryErr := ae.entry.Close()
+	archiveErr := ae.archive.Close()
+	if entryErr != nil {
+		return entryErr
+	}
+	return archiveErr
+}
+
+// trimCompressionSuffix returns the file name without the suffix of a
+// compression that is transparently decompressed.
+func trimCompressionSuffix(name string) string {
+	if trimmed, ok := strings.CutSuffix(name, ".gz"); ok {
+		return trimmed
+	}
+	return strings.TrimSuffix(name, ".bz2")
+}
+
+// bzip2File closes the underlying file of the bzip2 reader.
+type bzip2File struct {
+	io.Reader
+	file io.Closer
+}
+
+func (bf *bzip2File) Close() error {
+	return bf.file.Close()
+}
+
+// gzipFile closes both the gzip reader and the underlying file.
+type gzipFile struct {
+	*gzip.Reader
+	file io.Closer
+}
+
+func (gf *gzipFile) Close() error {
+	gzipErr := gf.Reader.Close()
+	fileErr := gf.file.Close()
+	if gzipErr != nil {
+		return gzipErr
+	}
+	return fileErr
+}
+
+// inputContext returns the context of the input, which is canceled to stop
+// loading the input.
+func inputContext(input DatabaseInput) context.Context {
+	if input.ctx == nil {
+		return context.Background()
+	}
+	return input.ctx
+}
+
+// fetchInput fetches the input file via HTTP(S), until the context of the
+// input is canceled.
+// If a cache file is configured, the download is stored there and conditional
+// requests are used to only download the file again if it changed.
+// The ETag is stored next to the cache file, with an additional ".etag" suffix.
+func fetchInput(input DatabaseInput) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(inputContext(input), http.MethodGet, input.File, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add conditional request headers from cache.
+	if input.Cache != "" {
+		if stat, err := os.Stat(input.Cache); err == nil {
+			req.Header.Set("If-Modified-Since", stat.ModTime().UTC().Format(http.TimeFormat))
+			if etag, err := os.ReadFile(input.Cache + ".etag"); err == nil && len(etag) > 0 {
+				req.Header.Set("If-None-Match", strings.TrimSpace(string(etag)))
+			}
+		}
+	}
+
+	// Send request.
+	timeout := input.Timeout
+	if timeout == 0 {
+		timeout = DefaultHTTPTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch: %w", err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && input.Cache != "":
+		// Use cached file.
+		_ = resp.Body.Close()
+		return os.Open(input.Cache)
+
+	case resp.StatusCode != http.StatusOK:
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("failed to fetch: unexpected status %s", resp.Status)
+
+	case input.Cache == "":
+		// Stream response directly.
+		return resp.Body, nil
+	}
+
+	// Save response to cache and then read from there.
+	defer resp.Body.Close() //nolint:errcheck
+	if err := saveToCache(input.Cache, resp); err != nil {
+		return nil, fmt.Errorf("failed to save to cache: %w", err)
+	}
+	return os.Open(input.Cache)
+}
+
+func saveToCache(cacheFile string, resp *http.Response) error {
+	// Write to temporary file first, so that the cache stays intact on failure.
+	tmpFile := cacheFile + ".tmp"
+	file, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile)
+		return err
+	}
+
+	// Set modification time to the last modified time of the server.
+	if lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		_ = os.Chtimes(tmpFile, lastModified, lastModified)
+	}
+
+	// Move into place and save ETag.
+	if err := os.Rename(tmpFile, cacheFile); err != nil {
+		return err
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		err = os.Remove(cacheFile + ".etag")
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return err
+	}
+	return os.WriteFile(cacheFile+".etag", []byte(etag), 0o600)
+}
@@ -0,0 +1,339 @@
+package mmdbmeld
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFetchInput(t *testing.T) {
+	t.Parallel()
+
+	var requests, notModified int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("192.0.2.0,192.0.2.255,AT\n"))
+	}))
+	defer server.Close()
+
+	input := DatabaseInput{
+		File:  server.URL + "/feed.csv?key=value",
+		Cache: filepath.Join(t.TempDir(), "feed.csv"),
+	}
+	if inputPath(input) != "/feed.csv" {
+		t.Fatalf("unexpected input path: %s", inputPath(input))
+	}
+
+	// Fetch twice, second request must be served from cache.
+	for i := 0; i < 2; i++ {
+		file, err := openInput(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(file)
+		_ = file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "192.0.2.0,192.0.2.255,AT\n" {
+			t.Fatalf("unexpected data: %q", data)
+		}
+	}
+	if requests != 2 || notModified != 1 {
+		t.Fatalf("expected 2 requests with 1 not modified, got %d and %d", requests, notModified)
+	}
+}
+
+func TestZipInput(t *testing.T) {
+	t.Parallel()
+
+	// Create archive.
+	file := filepath.Join(t.TempDir(), "test.zip")
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"README.txt":       "Example data.",
+		"data/example.csv": "192.0.2.0,192.0.2.255,AT\n",
+	} {
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	dbConfig := DatabaseConfig{
+		Types: map[string]string{"country.iso_code": "string"},
+		Inputs: []DatabaseInput{{
+			File:   file,
+			Fields: []string{"from", "to", "country.iso_code"},
+		}},
+	}
+	for _, entryName := range []string{"", "data/example.csv"} {
+		dbConfig.Inputs[0].ArchiveEntry = entryName
+		sources, err := LoadSources(dbConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry, err := sources[0].NextEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil || entry.Values["country.iso_code"].Value != "AT" {
+			t.Fatalf("unexpected entry: %+v", entry)
+		}
+	}
+
+	// Unknown entries must fail.
+	dbConfig.Inputs[0].ArchiveEntry = "missing.csv"
+	if _, err := LoadSources(dbConfig); err == nil {
+		t.Fatal("expected error for missing archive entry")
+	}
+
+	// Remote archives are fetched once.
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.ServeFile(w, r, file)
+	}))
+	defer server.Close()
+	dbConfig.Inputs[0].File = server.URL + "/test.zip"
+	dbConfig.Inputs[0].ArchiveEntry = ""
+	sources, err := LoadSources(dbConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, err := sources[0].NextEntry(); err != nil || entry == nil {
+		t.Fatalf("unexpected entry: %+v, %v", entry, err)
+	}
+	_ = sources[0].Close()
+	if requests != 1 {
+		t.Fatalf("expected 1 request, got %d", requests)
+	}
+
+	// Fetching stops when the context is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := LoadSourcesContext(ctx, dbConfig); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled error, got %v", err)
+	}
+}
+
+func TestInputFormat(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "feed.dat")
+	if err := os.WriteFile(file, []byte("192.0.2.0,192.0.2.255,AT\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dbConfig := DatabaseConfig{
+		Types: map[string]string{"country.iso_code": "string"},
+		Inputs: []DatabaseInput{{
+			File:   file,
+			Fields: []string{"from", "to", "country.iso_code"},
+		}},
+	}
+
+	// Unknown suffixes require a format.
+	if _, err := LoadSources(dbConfig); err == nil {
+		t.Fatal("expected error for unknown suffix")
+	}
+
+	dbConfig.Inputs[0].Format = "csv"
+	sources, err := LoadSources(dbConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sources[0].(*CSVSource); !ok {
+		t.Fatalf("expected csv source, got %T", sources[0])
+	}
+	entry, err := sources[0].NextEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil || entry.Values["country.iso_code"].Value != "AT" {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+
+	// Unknown formats fail.
+	dbConfig.Inputs[0].Format = "dat"
+	if _, err := LoadSources(dbConfig); err == nil || !strings.Contains(err.Error(), `unsupported format "dat"`) {
+		t.Fatalf("expected error for unknown format, got %v", err)
+	}
+}
+
+func TestExpandPath(t *testing.T) {
+	// Environment variables cannot be set in parallel tests.
+	dir := t.TempDir()
+	t.Setenv("MMDBMELD_TEST_DIR", dir)
+	t.Setenv("MMDBMELD_TEST_EMPTY", "")
+	if err := os.WriteFile(filepath.Join(dir, "test.csv"), []byte("192.0.2.0,192.0.2.255,AT\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, expected := range map[string]string{
+		"$MMDBMELD_TEST_DIR/test.csv":                   dir + "/test.csv",
+		"${MMDBMELD_TEST_DIR}/test.csv":                 dir + "/test.csv",
+		"${MMDBMELD_TEST_UNSET:-/data}/test.csv":        "/data/test.csv",
+		"${MMDBMELD_TEST_EMPTY:-/data}/test.csv":        "/data/test.csv",
+		"${MMDBMELD_TEST_DIR:-/data}/test.csv":          dir + "/test.csv",
+		"${MMDBMELD_TEST_EMPTY}/test.csv":               "/test.csv",
+		"/data/${MMDBMELD_TEST_UNSET:-}test.csv":        "/data/test.csv",
+		"/data/${MMDBMELD_TEST_UNSET:-default}/file.db": "/data/default/file.db",
+	} {
+		expanded, err := expandPath(path)
+		if err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		if expanded != expected {
+			t.Fatalf("%s: unexpected path %s", path, expanded)
+		}
+	}
+	if _, err := expandPath("$MMDBMELD_TEST_UNSET/test.csv"); err == nil {
+		t.Fatal("expected error for unset variable")
+	}
+
+	// Input files are expanded, but their errors keep the configured path.
+	dbConfig := DatabaseConfig{
+		Types:  map[string]string{"country.iso_code": "string"},
+		Inputs: []DatabaseInput{{File: "${MMDBMELD_TEST_DIR}/test.csv", Fields: []string{"from", "to", "country.iso_code"}}},
+	}
+	sources, err := LoadSources(dbConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeSources(sources)
+	dbConfig.Inputs[0].File = "${MMDBMELD_TEST_UNSET}/test.csv"
+	if _, err := LoadSources(dbConfig); err == nil || !strings.Contains(err.Error(), "${MMDBMELD_TEST_UNSET}/test.csv") {
+		t.Fatalf("unexpected error for unset variable: %v", err)
+	}
+}
+
+func TestGlobInput(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"chunk-2.csv": "192.0.3.0,192.0.3.255,DE\n",
+		"chunk-1.csv": "192.0.2.0,192.0.2.255,AT\n",
+		"other.csv":   "192.0.4.0,192.0.4.255,CH\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dbConfig := DatabaseConfig{
+		Types: map[string]string{"country.iso_code": "string"},
+		Inputs: []DatabaseInput{{
+			Name:   "daily",
+			File:   filepath.Join(dir, "chunk-*.csv"),
+			Fields: []string{"from", "to", "country.iso_code"},
+		}},
+	}
+	sources, err := LoadSources(dbConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeSources(sources)
+	var entries []string
+	for _, source := range sources {
+		entry, err := source.NextEntry()
+		if err != nil || entry == nil {
+			t.Fatalf("unexpected entry of %s: %+v, %v", source.Name(), entry, err)
+		}
+		entries = append(entries, source.Name()+":"+entry.Values["country.iso_code"].Value)
+	}
+	if fmt.Sprintf("%v", entries) != "[daily (chunk-1.csv):AT daily (chunk-2.csv):DE]" {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+
+	// Patterns matching no files fail, unless allowed.
+	dbConfig.Inputs[0].File = filepath.Join(dir, "missing-*.csv")
+	if _, err := LoadSources(dbConfig); err == nil || !strings.Contains(err.Error(), "matches no files") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dbConfig.Inputs[0].AllowEmptyGlob = true
+	if sources, err := LoadSources(dbConfig); err != nil || len(sources) != 0 {
+		t.Fatalf("unexpected sources: %v, %v", sources, err)
+	}
+}
+
+func TestStdinInput(t *testing.T) {
+	// Stdin cannot be replaced in parallel tests.
+	file := filepath.Join(t.TempDir(), "stdin")
+	if err := os.WriteFile(file, []byte("192.0.2.0,192.0.2.255,AT\n198.51.100.0,198.51.100.255,DE\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close() //nolint:errcheck
+	originalStdin := os.Stdin
+	os.Stdin = stdin
+	t.Cleanup(func() { os.Stdin = originalStdin })
+
+	dbConfig := DatabaseConfig{
+		Types:  map[string]string{"country.iso_code": "string"},
+		Inputs: []DatabaseInput{{File: "-", Format: "csv", Fields: []string{"from", "to", "country.iso_code"}}},
+	}
+	sources, err := LoadSources(dbConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sources[0].Name() != "stdin" {
+		t.Fatalf("unexpected name: %s", sources[0].Name())
+	}
+	var countries []string
+	for {
+		entry, err := sources[0].NextEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil {
+			break
+		}
+		countries = append(countries, entry.Values["country.iso_code"].Value)
+	}
+	if err := sources[0].Close(); err != nil || sources[0].Err() != nil {
+		t.Fatalf("failed to read stdin: %v, %v", err, sources[0].Err())
+	}
+	if strings.Join(countries, ",") != "AT,DE" {
+		t.Fatalf("unexpected entries: %v", countries)
+	}
+
+	// Stdin needs a format and can only be read once.
+	for _, inputs := range [][]DatabaseInput{
+		{{File: "-"}},
+		{{File: "-", Format: "sqlite"}},
+		{{File: "-", Format: "csv"}, {File: "-", Format: "csv"}},
+	} {
+		if _, err := LoadSources(DatabaseConfig{Inputs: inputs}); err == nil || !strings.Contains(err.Error(), "stdin") {
+			t.Fatalf("unexpected error for %+v: %v", inputs, err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package mmdbmeld
+
+import (
+	"sync"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// valueInterner reuses the same string instances for equal values of the
+// fields selected by Optimizations.Intern, so that repeated values share
+// their memory. It is safe for concurrent use.
+type valueInterner struct {
+	lock    sync.Mutex
+	values  map[internKey]mmdbtype.String
+	lookups int
+	hits    int
+}
+
+// internKey identifies an interned value by its field type and value.
+type internKey struct {
+	fieldType string
+	value     mmdbtype.String
+}
+
+func newValueInterner() *valueInterner {
+	return &valueInterner{values: make(map[internKey]mmdbtype.String)}
+}
+
+// intern returns the interned instance of the value of the given type.
+// Only strings are interned, other values are returned as they are.
+func (vi *valueInterner) intern(fieldType string, value mmdbtype.DataType) mmdbtype.DataType {
+	s, ok := value.(mmdbtype.String)
+	if !ok {
+		return value
+	}
+
+	vi.lock.Lock()
+	defer vi.lock.Unlock()
+
+	vi.lookups++
+	key := internKey{fieldType: fieldType, value: s}
+	if interned, ok := vi.values[key]; ok {
+		vi.hits++
+		return interned
+	}
+	vi.values[key] = s
+	return s
+}
+
+// stats returns the amount of lookups and hits.
+func (vi *valueInterner) stats() (lookups, hits int) {
+	vi.lock.Lock()
+	defer vi.lock.Unlock()
+
+	return vi.lookups, vi.hits
+}
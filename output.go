@@ -0,0 +1,178 @@
+package mmdbmeld
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// CompressGzip compresses an output with gzip.
+const CompressGzip = "gzip"
+
+// OutputConfig defines an additional output of a database, which is built
+// from the same sources.
+type OutputConfig struct {
+	Output string `yaml:"output"`
+	// Fields selects the dotted keys written to the output, eg. "country"
+	// selects the whole country map and "country.iso_code" only the iso code
+	// within it. Arrays can only be selected as a whole.
+	// All fields are written if empty.
+	Fields []string `yaml:"fields"`
+	// Compress compresses the output: "gzip" or empty for none. With gzip,
+	// ".gz" is appended to the output, unless it already ends with it.
+	Compress string `yaml:"compress"`
+}
+
+// outputs returns all outputs of the database: the output with all fields,
+// if set, followed by the additional outputs.
+func (dbConfig DatabaseConfig) outputs() []OutputConfig {
+	outputs := make([]OutputConfig, 0, 1+len(dbConfig.Outputs))
+	if dbConfig.Output != "" {
+		outputs = append(outputs, OutputConfig{Output: dbConfig.Output, Compress: dbConfig.Compress})
+	}
+	return append(outputs, dbConfig.Outputs...)
+}
+
+// compressedPath returns the path of the output with the suffix of its
+// compression.
+func compressedPath(output OutputConfig) string {
+	if output.Compress == CompressGzip && !strings.HasSuffix(output.Output, ".gz") {
+		return output.Output + ".gz"
+	}
+	return output.Output
+}
+
+// validateCompress checks that the compression is supported.
+func validateCompress(compress string) error {
+	switch compress {
+	case "", CompressGzip:
+		return nil
+	default:
+		return fmt.Errorf("unsupported compression %q", compress)
+	}
+}
+
+// writeOutput writes the tree to w with the given compression. It returns
+// the bytes written to w and the uncompressed size of the database.
+func writeOutput(tree *mmdbwriter.Tree, w io.Writer, compress string) (written, size int64, err error) {
+	if compress != CompressGzip {
+		written, err = tree.WriteTo(w)
+		return written, written, err
+	}
+
+	counter := &countingWriter{w: w}
+	gzipWriter := gzip.NewWriter(counter)
+	size, err = tree.WriteTo(gzipWriter)
+	if err != nil {
+		return counter.n, size, err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return counter.n, size, err
+	}
+	return counter.n, size, nil
+}
+
+// countingWriter counts the bytes written to the underlying writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// validateOutputs checks that the outputs are set, distinct and select valid
+// fields.
+func validateOutputs(outputs []OutputConfig) error {
+	if len(outputs) == 0 {
+		return errors.New("no output defined")
+	}
+	seen := make(map[string]bool, len(outputs))
+	for _, output := range outputs {
+		switch {
+		case output.Output == "":
+			return errors.New("output with empty file name")
+		case seen[output.Output]:
+			return fmt.Errorf("output %s is defined multiple times", output.Output)
+		}
+		seen[output.Output] = true
+		if err := validateCompress(output.Compress); err != nil {
+			return fmt.Errorf("output %s: %w", output.Output, err)
+		}
+		for _, field := range output.Fields {
+			if field == "" || slices.Contains(strings.Split(field, "."), "") {
+				return fmt.Errorf("output %s selects invalid field %q", output.Output, field)
+			}
+		}
+	}
+	return nil
+}
+
+// outputFields returns the selected fields of the outputs, split into their
+// key parts. Deeper keys are sorted first, so that selecting a whole map
+// replaces the keys selected within it.
+func outputFields(outputs []OutputConfig) [][][]string {
+	fields := make([][][]string, len(outputs))
+	for i, output := range outputs {
+		for _, field := range output.Fields {
+			fields[i] = append(fields[i], strings.Split(field, "."))
+		}
+		slices.SortStableFunc(fields[i], func(a, b []string) int {
+			return len(b) - len(a)
+		})
+	}
+	return fields
+}
+
+// projectRecord returns the record with only the selected fields, or nil if
+// none of them are set. Without selected fields, the record is returned as
+// it is.
+func projectRecord(record mmdbtype.Map, fields [][]string) mmdbtype.Map {
+	if len(fields) == 0 {
+		return record
+	}
+	projected := make(mmdbtype.Map)
+	for _, path := range fields {
+		projectField(projected, record, path)
+	}
+	if len(projected) == 0 {
+		return nil
+	}
+	return projected
+}
+
+// projectField copies the value at the given key path from src to dst,
+// creating the maps on the path.
+func projectField(dst, src mmdbtype.Map, path []string) {
+	key := mmdbtype.String(path[0])
+	value, ok := src[key]
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		dst[key] = value
+		return
+	}
+
+	srcMap, ok := value.(mmdbtype.Map)
+	if !ok {
+		return
+	}
+	dstMap, ok := dst[key].(mmdbtype.Map)
+	if !ok {
+		dstMap = make(mmdbtype.Map)
+	}
+	projectField(dstMap, srcMap, path[1:])
+	if len(dstMap) > 0 {
+		dst[key] = dstMap
+	}
+}
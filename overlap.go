@@ -0,0 +1,101 @@
+package mmdbmeld
+
+import (
+	"fmt"
+	"net"
+)
+
+// Overlap describes a network that overlaps with a previously inserted network.
+type Overlap struct {
+	Network         *net.IPNet
+	Source          string
+	ExistingNetwork *net.IPNet
+	ExistingSource  string
+}
+
+func (o Overlap) String() string {
+	return fmt.Sprintf(
+		"network %s of %s overlaps with %s of %s",
+		o.Network, o.Source,
+		o.ExistingNetwork, o.ExistingSource,
+	)
+}
+
+// overlapTracker tracks inserted networks in a binary trie to detect overlaps.
+type overlapTracker struct {
+	v4 overlapNode
+	v6 overlapNode
+}
+
+type overlapNode struct {
+	children [2]*overlapNode
+	network  *net.IPNet
+	source   string
+}
+
+// insert adds the network to the tracker and returns an overlap with a
+// previously inserted network, if there is one.
+func (ot *overlapTracker) insert(network *net.IPNet, source string) *Overlap {
+	// Select trie by IP version.
+	node := &ot.v6
+	ip := network.IP.To16()
+	if v4 := network.IP.To4(); v4 != nil && len(network.Mask) == net.IPv4len {
+		node = &ot.v4
+		ip = v4
+	}
+	prefixBits, _ := network.Mask.Size()
+
+	// Walk down the trie to the network.
+	var overlap *Overlap
+	for i := 0; i < prefixBits; i++ {
+		// Check if an existing network contains the new network.
+		if overlap == nil && node.network != nil {
+			overlap = newOverlap(network, source, node)
+		}
+
+		bit := (ip[i/8] >> (7 - i%8)) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &overlapNode{}
+		}
+		node = node.children[bit]
+	}
+
+	// Check if the new network equals or contains an existing network.
+	if overlap == nil {
+		if existing := node.findNetwork(); existing != nil {
+			overlap = newOverlap(network, source, existing)
+		}
+	}
+
+	// Save network.
+	if node.network == nil {
+		node.network = network
+		node.source = source
+	}
+
+	return overlap
+}
+
+// findNetwork returns the first node with a network in the sub trie.
+func (node *overlapNode) findNetwork() *overlapNode {
+	if node.network != nil {
+		return node
+	}
+	for _, child := range node.children {
+		if child != nil {
+			if found := child.findNetwork(); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
+
+func newOverlap(network *net.IPNet, source string, existing *overlapNode) *Overlap {
+	return &Overlap{
+		Network:         network,
+		Source:          source,
+		ExistingNetwork: existing.network,
+		ExistingSource:  existing.source,
+	}
+}
@@ -1,22 +1,45 @@
 package mmdbmeld
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math"
+	"math/big"
 	"net"
+	"net/netip"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"go4.org/netipx"
 )
 
 // Source describes a generic geoip data source.
 type Source interface {
 	Name() string
 	NextEntry() (*SourceEntry, error)
+	NextEntryContext(ctx context.Context) (*SourceEntry, error)
 	Err() error
+	Close() error
+}
+
+// errSourceClosed is the error of sources that were closed before all entries were read.
+var errSourceClosed = errors.New("source is closed")
+
+// closeSources closes all given sources, ignoring errors.
+func closeSources(sources []Source) {
+	for _, source := range sources {
+		_ = source.Close()
+	}
 }
 
 // SourceEntry describes a geoip data source entry.
@@ -26,6 +49,9 @@ type SourceEntry struct {
 	From   net.IP
 	To     net.IP
 	Values map[string]SourceValue
+
+	// Line is the line number of the entry in the source, if known.
+	Line int
 }
 
 // SourceValue holds an unprocessed source data value, including its type.
@@ -34,45 +60,828 @@ type SourceValue struct {
 	Value string
 }
 
+// ConversionError is returned if a source value cannot be converted to its
+// mmdb type. Use errors.As to get it from the errors of conversions, eg. to
+// aggregate failures by type.
+type ConversionError struct {
+	// Key is the key of the value, if known.
+	Key   string
+	Type  string
+	Value string
+	// Line is the line number of the entry in the source, if known.
+	Line int
+	// Err is the cause of the failed conversion.
+	Err error
+}
+
+func (e *ConversionError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("failed to transform value %s (of type %s): %s", e.Value, e.Type, e.Err)
+	}
+	return fmt.Sprintf("failed to transform %s with value %s (of type %s): %s", e.Key, e.Value, e.Type, e.Err)
+}
+
+func (e *ConversionError) Unwrap() error {
+	return e.Err
+}
+
+// SourceLoader loads the source of an input with the given types, like
+// LoadCSVSource. See Builder.RegisterFormat.
+type SourceLoader func(input DatabaseInput, types map[string]string) (Source, error)
+
 // LoadSources loads the given input files from the database config.
+// The sources are returned in processing order, see DatabaseInput.Priority.
 func LoadSources(dbConfig DatabaseConfig) ([]Source, error) {
-	sources := make([]Source, 0, len(dbConfig.Inputs))
-	for _, input := range dbConfig.Inputs {
-		switch {
-		case strings.HasSuffix(input.File, ".csv"):
-			s, err := LoadCSVSource(input, dbConfig.Types)
-			if err != nil {
-				return nil, fmt.Errorf("failed to load input file %s: %w", input.File, err)
+	return loadSources(context.Background(), dbConfig, nil)
+}
+
+// LoadSourcesContext loads the input files like LoadSources. Inputs are
+// fetched via HTTP(S), and sqlite databases queried, until the context is
+// canceled.
+func LoadSourcesContext(ctx context.Context, dbConfig DatabaseConfig) ([]Source, error) {
+	return loadSources(ctx, dbConfig, nil)
+}
+
+// loadSources loads the input files like LoadSourcesContext, with the
+// additional formats of the given loaders. If an input fails to load, the
+// sources loaded before are closed.
+func loadSources(ctx context.Context, dbConfig DatabaseConfig, loaders map[string]SourceLoader) (_ []Source, err error) {
+	if err := checkStdinInputs(dbConfig.Inputs, loaders); err != nil {
+		return nil, err
+	}
+
+	inputs, err := expandInputs(dbConfig.Inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make([]Source, 0, len(inputs))
+	defer func() {
+		if err != nil {
+			closeSources(sources)
+		}
+	}()
+	for _, input := range inputsByPriority(inputs, dbConfig.Merge) {
+		input.optimize = dbConfig.Optimize
+		input.ctx = ctx
+		types := inputTypes(dbConfig.Types, input)
+		switch input.OnError {
+		case "", OnErrorReturn, OnErrorFail, OnErrorSkip:
+		default:
+			return nil, fmt.Errorf("invalid onError mode %q for input file %s", input.OnError, input.File)
+		}
+		for field := range input.Defaults {
+			if _, ok := fieldTypeFor(types, field); !ok {
+				return nil, fmt.Errorf("default value for %s of input file %s has no type", field, input.File)
 			}
-			sources = append(sources, s)
-		case strings.HasSuffix(input.File, ".ipfire.txt"):
-			s, err := LoadIPFireSource(input, dbConfig.Types)
-			if err != nil {
-				return nil, fmt.Errorf("failed to load input file %s: %w", input.File, err)
+		}
+		for field, spec := range input.Transforms {
+			if _, err := parseTransform(spec); err != nil {
+				return nil, fmt.Errorf("invalid transform for %s of input file %s: %w", field, input.File, err)
+			}
+		}
+		for field, rule := range input.Validate {
+			fieldType, ok := fieldTypeFor(types, field)
+			if !ok {
+				return nil, fmt.Errorf("validation rule for %s of input file %s has no type", field, input.File)
+			}
+			if _, err := parseValidator(fieldType, rule, dbConfig.Optimize); err != nil {
+				return nil, fmt.Errorf("invalid validation rule for %s of input file %s: %w", field, input.File, err)
+			}
+		}
+
+		for field, condition := range input.IncludeIf {
+			if _, err := parseCondition(condition); err != nil {
+				return nil, fmt.Errorf("invalid condition for %s of input file %s: %w", field, input.File, err)
+			}
+		}
+
+		// Select file of archives.
+		input, err := resolveArchiveEntry(input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load input file %s: %w", input.File, err)
+		}
+
+		// Select the source by the format.
+		format, err := inputFormat(input, loaders)
+		if err != nil {
+			input.archive.release()
+			return nil, fmt.Errorf("unsupported input file %s: %w", input.File, err)
+		}
+		var s Source
+		switch format {
+		case "csv":
+			s, err = LoadCSVSource(input, types)
+		case "tsv":
+			s, err = LoadTSVSource(input, types)
+		case "json":
+			s, err = LoadJSONSource(input, types)
+		case "jsonl", "ndjson":
+			s, err = LoadJSONLinesSource(input, types)
+		case "mmdb":
+			s, err = LoadMMDBSource(input, types)
+		case "yaml":
+			s, err = LoadYAMLSource(input, types)
+		case "geofeed":
+			s, err = LoadGeofeedSource(input, types)
+		case "mrt":
+			s, err = LoadMRTSource(input, types)
+		case "rir":
+			s, err = LoadRIRSource(input, types)
+		case "sqlite":
+			s, err = LoadSQLiteSource(input, types)
+		case "xlsx":
+			s, err = LoadXLSXSource(input, types)
+		case "ipfire":
+			// The binary database shares the suffix with SQLite.
+			if strings.HasSuffix(trimCompressionSuffix(inputPath(input)), ".db") {
+				s, err = LoadIPFireDBSource(input, types)
+			} else {
+				s, err = LoadIPFireSource(input, types)
 			}
-			sources = append(sources, s)
 		default:
-			return nil, fmt.Errorf("unsupported input file: %s", input.File)
+			s, err = loaders[format](input, types)
 		}
+		input.archive.release()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load input file %s: %w", input.File, err)
+		}
+		sources = append(sources, s)
 	}
 
 	return sources, nil
 }
 
+// checkStdinInputs checks that at most one input reads from stdin, and that
+// it defines a format, as there is no suffix to detect it.
+func checkStdinInputs(inputs []DatabaseInput, loaders map[string]SourceLoader) error {
+	var readsStdin bool
+	for _, input := range inputs {
+		if !isStdin(input) {
+			continue
+		}
+		if readsStdin {
+			return errors.New("only one input can read from stdin")
+		}
+		readsStdin = true
+		if formats := sourceFormats(true, loaders); !slices.Contains(formats, input.Format) {
+			return fmt.Errorf("input from stdin requires a format, one of: %s", strings.Join(formats, ", "))
+		}
+	}
+	return nil
+}
+
+// inputsByPriority returns the inputs in processing order, so that inputs
+// with a higher priority take precedence: by ascending priority, or by
+// descending priority for the first-wins strategy, where earlier values win.
+// Inputs with the same priority keep their config order.
+func inputsByPriority(inputs []DatabaseInput, merge MergeConfig) []DatabaseInput {
+	sorted := slices.Clone(inputs)
+	slices.SortStableFunc(sorted, func(a, b DatabaseInput) int {
+		if merge.Strategy == MergeStrategyFirstWins {
+			return cmp.Compare(b.Priority, a.Priority)
+		}
+		return cmp.Compare(a.Priority, b.Priority)
+	})
+	return sorted
+}
+
+// inputName returns the name of the source of the input.
+func inputName(input DatabaseInput) string {
+	if input.Name != "" {
+		return input.Name
+	}
+	if isStdin(input) {
+		return "stdin"
+	}
+	return input.File
+}
+
+// inputTypes returns the given types, overridden by the types of the input.
+func inputTypes(types map[string]string, input DatabaseInput) map[string]string {
+	if len(input.Types) == 0 {
+		return types
+	}
+	merged := make(map[string]string, len(types)+len(input.Types))
+	for field, fieldType := range types {
+		merged[field] = fieldType
+	}
+	for field, fieldType := range input.Types {
+		merged[field] = fieldType
+	}
+	return merged
+}
+
+// Error handling modes for invalid entries of inputs.
+const (
+	// OnErrorReturn returns errors of invalid entries to the caller, which may
+	// continue reading. This is the default.
+	OnErrorReturn = "return"
+	// OnErrorFail stops reading the source at the first invalid entry.
+	// The error is returned by Err().
+	OnErrorFail = "fail"
+	// OnErrorSkip skips invalid entries and continues with the next one.
+	OnErrorSkip = "skip"
+)
+
+// errorHandling implements the configured error handling for invalid entries.
+// It is embedded into sources.
+type errorHandling struct {
+	onError  string
+	callback func(error)
+	skipped  int
+}
+
+func newErrorHandling(input DatabaseInput) errorHandling {
+	return errorHandling{
+		onError:  input.OnError,
+		callback: input.ErrorCallback,
+	}
+}
+
+// Skipped returns the amount of invalid entries that were skipped.
+func (eh *errorHandling) Skipped() int {
+	return eh.skipped
+}
+
+// skip reports the error of an invalid entry and returns whether the entry
+// should be skipped.
+func (eh *errorHandling) skip(err error) bool {
+	if eh.callback != nil {
+		eh.callback(err)
+	}
+	if eh.onError == OnErrorSkip {
+		eh.skipped++
+		return true
+	}
+	return false
+}
+
+// fail returns whether reading should stop at an invalid entry.
+func (eh *errorHandling) fail() bool {
+	return eh.onError == OnErrorFail
+}
+
+// networkFilter implements the configured filtering of the networks of
+// entries. It is embedded into sources.
+type networkFilter struct {
+	include []*net.IPNet
+	exclude []*net.IPNet
+}
+
+func newNetworkFilter(input DatabaseInput) (networkFilter, error) {
+	include, err := parseNetworks(input.IncludeNetworks)
+	if err != nil {
+		return networkFilter{}, fmt.Errorf("invalid include network: %w", err)
+	}
+	exclude, err := parseNetworks(input.ExcludeNetworks)
+	if err != nil {
+		return networkFilter{}, fmt.Errorf("invalid exclude network: %w", err)
+	}
+	return networkFilter{
+		include: include,
+		exclude: exclude,
+	}, nil
+}
+
+func parseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, NormalizeNetwork(network))
+	}
+	return networks, nil
+}
+
+// IncludesNetwork returns whether the given network passes the configured
+// network filter. The network must be contained in any of the included
+// networks, if any are set, and must not be contained in any of the excluded
+// networks.
+func (nf *networkFilter) IncludesNetwork(network *net.IPNet) bool {
+	if len(nf.include) > 0 && !slices.ContainsFunc(nf.include, func(include *net.IPNet) bool {
+		return containsNetwork(include, network)
+	}) {
+		return false
+	}
+	return !slices.ContainsFunc(nf.exclude, func(exclude *net.IPNet) bool {
+		return containsNetwork(exclude, network)
+	})
+}
+
+// containsNetwork returns whether the inner network is fully contained in the outer network.
+func containsNetwork(outer, inner *net.IPNet) bool {
+	outerOnes, outerBits := outer.Mask.Size()
+	innerOnes, innerBits := inner.Mask.Size()
+	return outerBits == innerBits && outerOnes <= innerOnes && outer.Contains(inner.IP)
+}
+
+// lineEstimate implements estimating the entries of line-based sources by
+// counting the lines of the input. It is embedded into sources.
+type lineEstimate struct {
+	input     DatabaseInput
+	skipLines int
+}
+
+func newLineEstimate(input DatabaseInput, skipLines int) lineEstimate {
+	return lineEstimate{
+		input:     input,
+		skipLines: skipLines,
+	}
+}
+
+// EstimateCount returns the estimated amount of entries of the source, which
+// is the amount of lines of the input without the skipped lines. The input
+// is opened separately, so that reading the source is not affected. Inputs
+// fetched via HTTP(S) are not counted, as this would download them again,
+// and neither is stdin, which can only be read once.
+func (le lineEstimate) EstimateCount() (int, bool) {
+	if isURL(le.input.File) || isStdin(le.input) {
+		return 0, false
+	}
+	file, err := openInput(le.input)
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close() //nolint:errcheck
+
+	var (
+		lines int
+		last  byte
+		buf   = make([]byte, 64*1024)
+	)
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			lines += bytes.Count(buf[:n], []byte{'\n'})
+			last = buf[n-1]
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, false
+		}
+	}
+	// Count the last line, if it does not end with a newline.
+	if last != 0 && last != '\n' {
+		lines++
+	}
+	if lines < le.skipLines {
+		return 0, true
+	}
+	return lines - le.skipLines, true
+}
+
+// valueProcessing implements the configured processing of raw values of
+// entries. It is embedded into sources.
+type valueProcessing struct {
+	defaults      map[string]SourceValue
+	trimSpace     bool
+	preserveSpace []string
+	nullValues    []string
+	transforms    map[string]TransformFunc
+	validators    map[string]validateFunc
+	conditions    map[string]conditionFunc
+	sourceField   string
+	sourceName    string
+	// arraySeparator joins the entries of arrays read from structured
+	// inputs, if their type defines no separator.
+	arraySeparator string
+}
+
+func newValueProcessing(input DatabaseInput, types map[string]string) valueProcessing {
+	return valueProcessing{
+		defaults:      newDefaults(input, types),
+		trimSpace:     input.TrimSpace,
+		preserveSpace: input.PreserveSpace,
+		nullValues:    input.NullValues,
+		transforms:    newTransforms(input),
+		validators:    newValidators(input, types),
+		conditions:    newConditions(input),
+		sourceField:   input.AddSourceField,
+		sourceName:    inputName(input),
+
+		arraySeparator: input.optimize.ArraySeparator,
+	}
+}
+
+// newTransforms returns the transforms of the input by field.
+// Invalid transforms return their error when applied.
+func newTransforms(input DatabaseInput) map[string]TransformFunc {
+	if len(input.Transforms) == 0 {
+		return nil
+	}
+
+	fns := make(map[string]TransformFunc, len(input.Transforms))
+	for field, spec := range input.Transforms {
+		fn, err := parseTransform(spec)
+		if err != nil {
+			fn = func(string) (string, error) { return "", err }
+		}
+		fns[field] = fn
+	}
+	return fns
+}
+
+// newValidators returns the validators of the input by field.
+// Rules for fields without a type are ignored, and invalid rules return
+// their error when applied.
+func newValidators(input DatabaseInput, types map[string]string) map[string]validateFunc {
+	if len(input.Validate) == 0 {
+		return nil
+	}
+
+	fns := make(map[string]validateFunc, len(input.Validate))
+	for field, rule := range input.Validate {
+		fieldType, ok := fieldTypeFor(types, field)
+		if !ok {
+			continue
+		}
+		fn, err := parseValidator(fieldType, rule, input.optimize.ForField(field))
+		if err != nil {
+			fn = func(SourceValue) error { return err }
+		}
+		fns[field] = fn
+	}
+	return fns
+}
+
+// newConditions returns the conditions of the input by field.
+// Invalid conditions are rejected when loading the input, and never hold.
+func newConditions(input DatabaseInput) map[string]conditionFunc {
+	if len(input.IncludeIf) == 0 {
+		return nil
+	}
+
+	fns := make(map[string]conditionFunc, len(input.IncludeIf))
+	for field, condition := range input.IncludeIf {
+		fn, err := parseCondition(condition)
+		if err != nil {
+			fn = func(map[string]SourceValue) bool { return false }
+		}
+		fns[field] = fn
+	}
+	return fns
+}
+
+// newDefaults returns the typed default values of the input.
+// Defaults for fields without a type are ignored.
+func newDefaults(input DatabaseInput, types map[string]string) map[string]SourceValue {
+	if len(input.Defaults) == 0 {
+		return nil
+	}
+
+	defaults := make(map[string]SourceValue, len(input.Defaults))
+	for field, value := range input.Defaults {
+		if fieldType, ok := fieldTypeFor(types, field); ok {
+			defaults[field] = SourceValue{
+				Type:  fieldType,
+				Value: value,
+			}
+		}
+	}
+	return defaults
+}
+
+// processValues trims the values, if enabled, removes null values, applies
+// the transforms, sets the default values for all fields that are missing or
+// empty in the entry, omits the fields whose condition does not hold,
+// validates the values and then adds the source name, if configured.
+func (vp *valueProcessing) processValues(se *SourceEntry) error {
+	if vp.trimSpace {
+		for field, value := range se.Values {
+			if !slices.Contains(vp.preserveSpace, field) {
+				value.Value = strings.TrimSpace(value.Value)
+				se.Values[field] = value
+			}
+		}
+	}
+
+	if len(vp.nullValues) > 0 {
+		for field, value := range se.Values {
+			if isNullValue(value.Value, vp.nullValues) {
+				delete(se.Values, field)
+			}
+		}
+	}
+
+	for field, fn := range vp.transforms {
+		if value, ok := se.Values[field]; ok {
+			transformed, err := fn(value.Value)
+			if err != nil {
+				return fmt.Errorf("failed to transform %s: %w", field, err)
+			}
+			value.Value = transformed
+			se.Values[field] = value
+		}
+	}
+
+	for field, defaultValue := range vp.defaults {
+		if value, ok := se.Values[field]; !ok || value.Value == "" {
+			se.Values[field] = defaultValue
+		}
+	}
+
+	if len(vp.conditions) > 0 {
+		// Evaluate all conditions before omitting any field, so that they do
+		// not depend on each other.
+		var omit []string
+		for field, fn := range vp.conditions {
+			if _, ok := se.Values[field]; ok && !fn(se.Values) {
+				omit = append(omit, field)
+			}
+		}
+		for _, field := range omit {
+			delete(se.Values, field)
+		}
+	}
+
+	for field, fn := range vp.validators {
+		if value, ok := se.Values[field]; ok {
+			if err := fn(value); err != nil {
+				return fmt.Errorf("invalid %s %q: %w", field, value.Value, err)
+			}
+		}
+	}
+
+	if vp.sourceField != "" {
+		se.Values[vp.sourceField] = SourceValue{
+			Type:  "string",
+			Value: vp.sourceName,
+		}
+	}
+	return nil
+}
+
+// fieldMapping renames source fields to the keys used in the database.
+type fieldMapping struct {
+	fieldMap        map[string]string
+	dropUnmapped    bool
+	allowDuplicates bool
+}
+
+func newFieldMapping(input DatabaseInput) fieldMapping {
+	return fieldMapping{
+		fieldMap:        input.FieldMap,
+		dropUnmapped:    input.DropUnmapped,
+		allowDuplicates: input.AllowDuplicateKeys,
+	}
+}
+
+// targetKey returns the key to use for the given source field.
+// Unmapped fields are returned as they are, or as "-" if they are dropped.
+// The special fields defining the IP range are never dropped.
+func (fm fieldMapping) targetKey(field string) string {
+	if key, ok := fm.fieldMap[field]; ok {
+		return key
+	}
+	if fm.dropUnmapped {
+		switch field {
+		case "from", "to", "network", "start", "end":
+		default:
+			return "-"
+		}
+	}
+	return field
+}
+
+// targetKeys returns the target keys for all given source fields.
+// It fails if multiple fields map to the same key, unless duplicate keys are
+// allowed or the key collects the fields into an array.
+func (fm fieldMapping) targetKeys(fields []string) ([]string, error) {
+	keys := make([]string, 0, len(fields))
+	for i, field := range fields {
+		key := fm.targetKey(field)
+		if !fm.allowDuplicates && key != "" && key != "-" && !strings.HasSuffix(key, arrayKeySuffix) {
+			if j := slices.Index(keys, key); j >= 0 {
+				return nil, fmt.Errorf("fields %s and %s both map to %s", fields[j], fields[i], key)
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// arrayKeySuffix marks the keys of fields that collect the values of
+// multiple columns into an array, eg. "languages[]".
+const arrayKeySuffix = "[]"
+
+// arrayFieldSeparator joins the values of collected columns, if the array
+// type of their field defines no separator.
+const arrayFieldSeparator = "\x1f"
+
+// checkArrayFields checks that the fields collecting columns into an array,
+// like "languages[]", have an array type and do not conflict with a field
+// of the same key.
+func checkArrayFields(fields []string, types map[string]string) error {
+	for _, field := range fields {
+		key, ok := strings.CutSuffix(field, arrayKeySuffix)
+		if !ok {
+			continue
+		}
+		if slices.Contains(fields, key) {
+			return fmt.Errorf("fields %s and %s both map to %s", key, field, key)
+		}
+		fieldType, ok := fieldTypeFor(types, key)
+		if !ok {
+			continue
+		}
+		if _, _, err := arrayFieldType(fieldType); err != nil {
+			return fmt.Errorf("field %s collects columns: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// arrayFieldType returns the array type of collected columns and the
+// separator their values are joined with. Types without separator get one.
+func arrayFieldType(fieldType string) (arrayType, separator string, err error) {
+	subType, ok := strings.CutPrefix(fieldType, "array:")
+	if !ok {
+		return "", "", fmt.Errorf("type %s is not an array type", fieldType)
+	}
+	entryType := strings.TrimPrefix(subType, "map:")
+	if _, separator, ok := cutArraySeparator(entryType); ok {
+		return fieldType, separator, nil
+	}
+	if _, _, ok := cutArraySeparator(entryType + ":" + arrayFieldSeparator); !ok {
+		return "", "", fmt.Errorf("entries of type %s cannot define a separator", entryType)
+	}
+	return fieldType + ":" + arrayFieldSeparator, arrayFieldSeparator, nil
+}
+
+// setValue sets the value of the target key of the source field.
+// It fails if the key is already set, unless duplicate keys are allowed.
+func (fm fieldMapping) setValue(se *SourceEntry, field, key string, value SourceValue) error {
+	if _, ok := se.Values[key]; ok && !fm.allowDuplicates {
+		return fmt.Errorf("fields %s and %s both map to %s", fm.otherField(field, key), field, key)
+	}
+	se.Values[key] = value
+	return nil
+}
+
+// otherField returns the source field, other than the given one, that maps
+// to the given key.
+func (fm fieldMapping) otherField(field, key string) string {
+	others := make([]string, 0, 1)
+	for source, target := range fm.fieldMap {
+		if target == key && source != field {
+			others = append(others, source)
+		}
+	}
+	if len(others) == 0 {
+		return key
+	}
+	slices.Sort(others)
+	return others[0]
+}
+
+// fieldNames returns the flattened keys of the given object, renamed by the
+// field mapping. Values of fields with the json type are not flattened.
+func (fm fieldMapping) fieldNames(obj map[string]any, prefix string, types map[string]string) []string {
+	names := make([]string, 0, len(obj))
+	for key, value := range obj {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		targetKey := fm.targetKey(key)
+		if subObj, ok := value.(map[string]any); ok {
+			if fieldType, ok := fieldTypeFor(types, targetKey); !ok || fieldType != "json" {
+				names = append(names, fm.fieldNames(subObj, key, types)...)
+				continue
+			}
+		}
+		names = append(names, targetKey)
+	}
+	return names
+}
+
+// mappedFieldNames returns the sorted target keys of the field map of
+// sources with fixed fields.
+func mappedFieldNames(fieldMap map[string]string) []string {
+	names := make([]string, 0, len(fieldMap))
+	for _, name := range fieldMap {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// isNullValue returns whether the value matches any of the null values,
+// ignoring case.
+func isNullValue(value string, nullValues []string) bool {
+	for _, nullValue := range nullValues {
+		if strings.EqualFold(value, nullValue) {
+			return true
+		}
+	}
+	return false
+}
+
+// errorAtLine wraps the error with the source name and line number.
+func errorAtLine(sourceName string, line int, err error) error {
+	return fmt.Errorf("%s line %d: %w", sourceName, line, err)
+}
+
+// fieldTypeFor returns the type defined for the given field.
+// The second return value is false if the field has no type or is ignored.
+func fieldTypeFor(types map[string]string, fieldName string) (string, bool) {
+	fieldType, ok := types[fieldName]
+	if !ok || fieldType == "" || fieldType == "-" {
+		return "", false
+	}
+	return fieldType, true
+}
+
 // ToMMDBMap transforms the source entry to a mmdb map type.
+// Values that fail to convert return a *ConversionError with the line of the
+// entry.
 func (se SourceEntry) ToMMDBMap(optim Optimizations) (mmdbtype.Map, error) {
-	m := mmdbtype.Map{}
-	for key, entry := range se.Values {
+	m, err := BuildNestedMap(se.Values, optim)
+	if err != nil {
+		se.setErrorLine(err)
+		return nil, err
+	}
+	return m, nil
+}
+
+// ToMMDBMapCollect is like ToMMDBMap, but does not stop at the first error.
+// It transforms all values it can and returns all errors encountered.
+func (se SourceEntry) ToMMDBMapCollect(optim Optimizations) (mmdbtype.Map, []error) {
+	m, errs := buildNestedMap(se.Values, optim, false)
+	for _, err := range errs {
+		se.setErrorLine(err)
+	}
+	return m, errs
+}
+
+// setErrorLine sets the line of the entry on conversion errors.
+func (se SourceEntry) setErrorLine(err error) {
+	var convErr *ConversionError
+	if errors.As(err, &convErr) {
+		convErr.Line = se.Line
+	}
+}
+
+// BuildNestedMap transforms the given values to a mmdb map type.
+// Dotted keys are nested into sub maps, eg. "country.iso_code" is stored as
+// "iso_code" in the "country" map. Numeric key parts are indexes of slices,
+// eg. "subdivisions.0.iso_code".
+func BuildNestedMap(values map[string]SourceValue, optim Optimizations) (mmdbtype.Map, error) {
+	m, errs := buildNestedMap(values, optim, true)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return m, nil
+}
+
+func buildNestedMap(values map[string]SourceValue, optim Optimizations, stopOnError bool) (m mmdbtype.Map, errs []error) {
+	// Sort keys for a deterministic result and error order.
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	m = mmdbtype.Map{}
+	for _, key := range keys {
+		entry := values[key]
 		// Transform value to mmdb type.
-		mmdbVal, err := entry.ToMMDBType(optim)
+		mmdbVal, err := entry.ToMMDBType(optim.ForField(key))
 		if err != nil {
-			return nil, fmt.Errorf("failed to transform %s with value %s (of type %s): %w", key, entry.Value, entry.Type, err)
+			var convErr *ConversionError
+			if errors.As(err, &convErr) {
+				convErr.Key = key
+			}
+			errs = append(errs, err)
+			if stopOnError {
+				return nil, errs
+			}
+			continue
+		}
+
+		// Omit skipped values.
+		if mmdbVal == nil {
+			continue
+		}
+
+		// Reuse equal values of interned fields.
+		if optim.interner != nil && slices.Contains(optim.Intern, key) {
+			mmdbVal = optim.interner.intern(entry.Type, mmdbVal)
+		}
+
+		// Omit zero values, before creating any sub maps for them.
+		if optim.OmitZeroValues && isZeroValue(mmdbVal) && !slices.Contains(optim.KeepZeroValues, key) {
+			continue
 		}
 
 		// Get sub map for entry.
 		keyParts := strings.Split(key, ".")
 		mapForEntry := m
-		for i := 0; i < len(keyParts)-1; i++ {
+		for i := 0; mapForEntry != nil && i < len(keyParts)-1; i++ {
 			subMapVal, ok := mapForEntry[mmdbtype.String(keyParts[i])]
 			if !ok {
 				nextMapForEntry := mmdbtype.Map{}
@@ -81,39 +890,274 @@ func (se SourceEntry) ToMMDBMap(optim Optimizations) (mmdbtype.Map, error) {
 			} else {
 				mapForEntry, ok = subMapVal.(mmdbtype.Map)
 				if !ok {
-					return nil, fmt.Errorf("failed to transform %s: submap %s already exists but is a %T, and not a map", key, strings.Join(keyParts[:1], "."), subMapVal)
+					errs = append(errs, fmt.Errorf("failed to transform %s: submap %s already exists but is a %T, and not a map", key, strings.Join(keyParts[:i+1], "."), subMapVal))
+					if stopOnError {
+						return nil, errs
+					}
 				}
 			}
 		}
+		if mapForEntry == nil {
+			continue
+		}
 
 		// Set value in (sub) map.
 		mapForEntry[mmdbtype.String(keyParts[len(keyParts)-1])] = mmdbVal
 	}
 
-	return m, nil
+	// Convert sub maps with indexes as keys to slices.
+	if convErrs := convertIndexedMaps(m, ""); len(convErrs) > 0 {
+		errs = append(errs, convErrs...)
+		if stopOnError {
+			return nil, errs
+		}
+	}
+
+	return m, errs
+}
+
+// convertIndexedMaps replaces all sub maps of the given map that have indexes
+// as keys with slices, eg. "subdivisions.0.iso_code" becomes the "iso_code"
+// of the first map in the "subdivisions" slice. Indexes must start at zero
+// and must not have gaps. Sub maps that cannot be converted stay maps.
+func convertIndexedMaps(m mmdbtype.Map, path string) (errs []error) {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, string(key))
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		subMap, ok := m[mmdbtype.String(key)].(mmdbtype.Map)
+		if !ok {
+			continue
+		}
+		subPath := key
+		if path != "" {
+			subPath = path + "." + key
+		}
+
+		// Convert nested maps first.
+		errs = append(errs, convertIndexedMaps(subMap, subPath)...)
+
+		slice, isIndexed, err := indexedMapToSlice(subMap)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("failed to transform %s: %w", subPath, err))
+		case isIndexed:
+			m[mmdbtype.String(key)] = slice
+		}
+	}
+	return errs
+}
+
+// indexedMapToSlice returns the values of the map as a slice, if its keys are indexes.
+func indexedMapToSlice(m mmdbtype.Map) (slice mmdbtype.Slice, isIndexed bool, err error) {
+	var indexes int
+	for key := range m {
+		if _, ok := parseIndex(string(key)); ok {
+			indexes++
+		}
+	}
+	switch indexes {
+	case 0:
+		return nil, false, nil
+	case len(m):
+	default:
+		return nil, false, errors.New("mixes indexes and keys")
+	}
+
+	slice = make(mmdbtype.Slice, len(m))
+	for key, value := range m {
+		index, _ := parseIndex(string(key))
+		if index >= len(slice) {
+			return nil, false, fmt.Errorf("index %d is out of range, indexes must start at 0 without gaps", index)
+		}
+		slice[index] = value
+	}
+	return slice, true, nil
+}
+
+// parseIndex parses a key as a slice index.
+// Indexes with leading zeros or signs are not accepted.
+func parseIndex(key string) (int, bool) {
+	index, err := strconv.Atoi(key)
+	if err != nil || index < 0 || strconv.Itoa(index) != key {
+		return 0, false
+	}
+	return index, true
+}
+
+// isZeroValue reports whether the mmdb value is the zero value of its type.
+func isZeroValue(v mmdbtype.DataType) bool {
+	switch v := v.(type) {
+	case mmdbtype.Bool:
+		return !bool(v)
+	case mmdbtype.String:
+		return v == ""
+	case mmdbtype.Bytes:
+		return len(v) == 0
+	case mmdbtype.Int32:
+		return v == 0
+	case mmdbtype.Uint16:
+		return v == 0
+	case mmdbtype.Uint32:
+		return v == 0
+	case mmdbtype.Uint64:
+		return v == 0
+	case *mmdbtype.Uint128:
+		return (*big.Int)(v).Sign() == 0
+	case mmdbtype.Float32:
+		return v == 0
+	case mmdbtype.Float64:
+		return v == 0
+	case mmdbtype.Slice:
+		return len(v) == 0
+	case mmdbtype.Map:
+		return len(v) == 0
+	default:
+		return false
+	}
+}
+
+// Networks returns the networks of the source entry.
+// If Net is set, it is returned as the only network. Otherwise, the range
+// from From to To is decomposed into the minimal set of networks.
+func (se SourceEntry) Networks() ([]*net.IPNet, error) {
+	if se.Net != nil {
+		return []*net.IPNet{se.Net}, nil
+	}
+
+	start, ok1 := netip.AddrFromSlice(se.From)
+	end, ok2 := netip.AddrFromSlice(se.To)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("range with invalid IPs: %s - %s", se.From, se.To)
+	}
+	if start.BitLen() != end.BitLen() {
+		return nil, fmt.Errorf("range mixes IPv4 and IPv6: %s - %s", se.From, se.To)
+	}
+	r := netipx.IPRangeFrom(start, end)
+	if !r.IsValid() {
+		return nil, fmt.Errorf("range is invalid: %s - %s", se.From, se.To)
+	}
+
+	prefixes := r.Prefixes()
+	networks := make([]*net.IPNet, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		networks = append(networks, netipx.PrefixIPNet(prefix))
+	}
+	return networks, nil
+}
+
+// ApplyMappings resolves all values with a "map:<name>" type using the given
+// mappings. The mapped values are assigned the type of their mapping.
+// The optimizations supply the default array separator.
+func (se SourceEntry) ApplyMappings(mappings map[string]Mapping, optim Optimizations) error {
+	for key, entry := range se.Values {
+		subType, isArrayType := strings.CutPrefix(entry.Type, "array:")
+		mappingName, ok := strings.CutPrefix(subType, "map:")
+		if !ok {
+			continue
+		}
+		var (
+			separator    = optim.ArraySeparator
+			hasSeparator bool
+		)
+		if isArrayType {
+			var typeSeparator string
+			mappingName, typeSeparator, hasSeparator = cutArraySeparator(mappingName)
+			if hasSeparator {
+				separator = typeSeparator
+			}
+		}
+		mapping, ok := mappings[mappingName]
+		if !ok {
+			return fmt.Errorf("failed to map %s: mapping %s is not defined", key, mappingName)
+		}
+
+		// Map value.
+		if isArrayType {
+			fields := splitArray(entry.Value, separator)
+			for i, field := range fields {
+				mapped, err := mapping.Map(field)
+				if err != nil {
+					return fmt.Errorf("failed to map %s array entry #%d with mapping %s: %w", key, i, mappingName, err)
+				}
+				fields[i] = mapped
+			}
+			if separator == "" {
+				entry.Value = strings.Join(fields, " ")
+			} else {
+				entry.Value = strings.Join(fields, separator)
+			}
+			entry.Type = "array:" + mapping.ValueType()
+			if hasSeparator {
+				entry.Type += ":" + separator
+			}
+		} else {
+			mapped, err := mapping.Map(entry.Value)
+			if err != nil {
+				return fmt.Errorf("failed to map %s with mapping %s: %w", key, mappingName, err)
+			}
+			entry.Value = mapped
+			entry.Type = mapping.ValueType()
+		}
+		se.Values[key] = entry
+	}
+
+	return nil
 }
 
 // ToMMDBType transforms the source value to the correct mmdb type.
-func (sv SourceValue) ToMMDBType(optim Optimizations) (mmdbtype.DataType, error) {
+// If the value is skipped because of its overflow mode, nil, nil is returned.
+// Values that fail to convert return a *ConversionError.
+func (sv SourceValue) ToMMDBType(optim Optimizations) (v mmdbtype.DataType, err error) {
 	subType, isArrayType := strings.CutPrefix(sv.Type, "array:")
 	if isArrayType {
-		return toMMDBArray(subType, sv.Value, optim)
+		entryType, separator, ok := cutArraySeparator(subType)
+		if !ok {
+			separator = optim.ArraySeparator
+		}
+		v, err = toMMDBArray(entryType, separator, sv.Value, optim)
+	} else {
+		v, err = toMMDBType(sv.Type, sv.Value, optim)
 	}
-
-	return toMMDBType(sv.Type, sv.Value, optim)
+	if err != nil {
+		return nil, &ConversionError{Type: sv.Type, Value: sv.Value, Err: err}
+	}
+	return v, nil
 }
 
 func toMMDBType(fieldType, fieldValue string, optim Optimizations) (mmdbtype.DataType, error) {
+	// Handle types with parameters.
+	if layout, ok := strings.CutPrefix(fieldType, "datetime:"); ok {
+		return toMMDBDatetime(fieldValue, layout)
+	}
+	if spec, ok := strings.CutPrefix(fieldType, "scaledint:"); ok {
+		return toMMDBScaledInt(spec, optim.numberValue(fieldValue), optim)
+	}
+
+	if it, ok := intTypes[fieldType]; ok {
+		return toMMDBInt(it, optim.numberValue(fieldValue), optim)
+	}
+
 	switch fieldType {
 	case "bool":
 		v, err := strconv.ParseBool(fieldValue)
 		if err != nil {
+			// Fall back to other common spellings.
+			switch strings.ToLower(fieldValue) {
+			case "true", "yes", "y", "on":
+				return mmdbtype.Bool(true), nil
+			case "false", "no", "n", "off":
+				return mmdbtype.Bool(false), nil
+			}
 			return nil, err
 		}
 		return mmdbtype.Bool(v), nil
 
 	case "string":
-		return mmdbtype.String(fieldValue), nil
+		return mmdbtype.String(optim.normalizeString(fieldValue)), nil
 
 	case "hexbytes":
 		v, err := hex.DecodeString(fieldValue)
@@ -122,74 +1166,553 @@ func toMMDBType(fieldType, fieldValue string, optim Optimizations) (mmdbtype.Dat
 		}
 		return mmdbtype.Bytes(v), nil
 
-	case "int32":
-		v, err := strconv.ParseInt(fieldValue, 10, 32)
+	case "base64bytes":
+		v, err := base64.StdEncoding.DecodeString(fieldValue)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("invalid base64: %w", err)
 		}
-		return mmdbtype.Int32(int32(v)), nil
+		return mmdbtype.Bytes(v), nil
 
-	case "uint16":
-		v, err := strconv.ParseUint(fieldValue, 10, 16)
+	case "base64url":
+		// Accept both padded and unpadded values.
+		v, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(fieldValue, "="))
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("invalid base64url: %w", err)
 		}
-		return mmdbtype.Uint16(uint16(v)), nil
+		return mmdbtype.Bytes(v), nil
 
-	case "uint32":
-		v, err := strconv.ParseUint(fieldValue, 10, 32)
+	case "uint128":
+		fieldValue = optim.numberValue(fieldValue)
+		v, ok := new(big.Int), false
+		if hexValue, isHex := strings.CutPrefix(strings.ToLower(fieldValue), "0x"); isHex {
+			_, ok = v.SetString(hexValue, 16)
+		} else {
+			_, ok = v.SetString(fieldValue, 10)
+		}
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("invalid uint128 %q", fieldValue)
+		case v.Sign() < 0:
+			return nil, errors.New("uint128 values must not be negative")
+		case v.BitLen() > 128:
+			return nil, errors.New("uint128 values must not exceed 128 bits")
+		}
+		return (*mmdbtype.Uint128)(v), nil
+
+	case "float32":
+		v, err := strconv.ParseFloat(optim.numberValue(fieldValue), 32)
 		if err != nil {
 			return nil, err
 		}
-		return mmdbtype.Uint32(uint32(v)), nil
+		v = optim.roundFloat(v)
+		return mmdbtype.Float32(v), nil
 
-	case "uint64":
-		v, err := strconv.ParseUint(fieldValue, 10, 64)
+	case "float64":
+		v, err := strconv.ParseFloat(optim.numberValue(fieldValue), 64)
 		if err != nil {
 			return nil, err
 		}
-		return mmdbtype.Uint64(v), nil
+		v = optim.roundFloat(v)
+		return mmdbtype.Float64(v), nil
 
-	case "float32":
-		v, err := strconv.ParseFloat(fieldValue, 32)
+	case "percent":
+		return toMMDBRatio(fieldValue, 100, optim)
+
+	case "permille":
+		return toMMDBRatio(fieldValue, 1000, optim)
+
+	case "latitude":
+		return toMMDBCoordinate(fieldValue, 90, optim)
+
+	case "longitude":
+		return toMMDBCoordinate(fieldValue, 180, optim)
+
+	case "json":
+		return toMMDBJSON(fieldValue, optim)
+
+	case "datetime":
+		return toMMDBDatetime(fieldValue, time.RFC3339)
+
+	case "duration":
+		return toMMDBDuration(fieldValue, optim)
+
+	case "ip":
+		ip := net.ParseIP(fieldValue)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip address %q", fieldValue)
+		}
+		return mmdbtype.String(ip.String()), nil
+
+	case "ipbytes":
+		ip := net.ParseIP(fieldValue)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip address %q", fieldValue)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		return mmdbtype.Bytes(ip), nil
+
+	case "network":
+		_, ipNet, err := net.ParseCIDR(fieldValue)
 		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", fieldValue)
+		}
+		return mmdbtype.String(NormalizeNetwork(ipNet).String()), nil
+
+	default:
+		return nil, errors.New("unsupport type")
+	}
+}
+
+// intType describes how values of an integer type are parsed and stored.
+type intType struct {
+	bitSize int
+	signed  bool
+
+	// fromInt converts a parsed signed value to the mmdb type.
+	fromInt func(v int64, optim Optimizations) (mmdbtype.DataType, error)
+	// fromUint converts a parsed unsigned value to the mmdb type.
+	fromUint func(v uint64, optim Optimizations) mmdbtype.DataType
+}
+
+// intTypes holds the integer types by their type name.
+// mmdb has no 8-bit integer types, so these are stored in the smallest fitting type.
+var intTypes = map[string]intType{
+	"int8":   {bitSize: 8, signed: true, fromInt: toMMDBInt32},
+	"int16":  {bitSize: 16, signed: true, fromInt: toMMDBInt32},
+	"int32":  {bitSize: 32, signed: true, fromInt: toMMDBInt32},
+	"int64":  {bitSize: 64, signed: true, fromInt: toMMDBInt64},
+	"uint8":  {bitSize: 8, fromUint: toMMDBUint16},
+	"uint16": {bitSize: 16, fromUint: toMMDBUint16},
+	"uint32": {bitSize: 32, fromUint: toMMDBUint32},
+	"uint64": {bitSize: 64, fromUint: toMMDBUint64},
+}
+
+// toMMDBInt parses an integer of the given type.
+// Values out of range are handled by the overflow mode.
+func toMMDBInt(it intType, fieldValue string, optim Optimizations) (mmdbtype.DataType, error) {
+	var (
+		signedValue   int64
+		unsignedValue uint64
+		err           error
+	)
+	if it.signed {
+		signedValue, err = strconv.ParseInt(fieldValue, 10, it.bitSize)
+	} else {
+		unsignedValue, err = parseUint(fieldValue, it.bitSize)
+	}
+	if err != nil {
+		if !errors.Is(err, strconv.ErrRange) {
 			return nil, err
 		}
-		if optim.FloatDecimals != 0 {
-			v = roundToDecimalPlaces(v, optim.FloatDecimals)
+		if it.signed && it.bitSize == 64 {
+			err = fmt.Errorf("int64 values must be between %d and %d: %w", math.MinInt64, math.MaxInt64, err)
 		}
-		return mmdbtype.Float32(v), nil
+		// The parsed value is clamped to the limits of the type.
+		if skip, err := optim.handleOverflow(err); skip || err != nil {
+			return nil, err
+		}
+	}
 
-	case "float64":
-		v, err := strconv.ParseFloat(fieldValue, 64)
-		if err != nil {
+	if it.signed {
+		return it.fromInt(signedValue, optim)
+	}
+	return it.fromUint(unsignedValue, optim), nil
+}
+
+// toMMDBScaledInt parses a number, multiplies it by the scale and stores it
+// rounded as the integer type, as defined by the spec "<type>:<scale>".
+// Readers must divide the stored value by the scale.
+func toMMDBScaledInt(spec, fieldValue string, optim Optimizations) (mmdbtype.DataType, error) {
+	typeName, scaleSpec, _ := strings.Cut(spec, ":")
+	it, ok := intTypes[typeName]
+	if !ok {
+		return nil, fmt.Errorf("invalid integer type %q of scaledint", typeName)
+	}
+	scale, err := strconv.ParseFloat(scaleSpec, 64)
+	if err != nil || scale <= 0 || math.IsInf(scale, 0) {
+		return nil, fmt.Errorf("invalid scale %q of scaledint, must be a positive number", scaleSpec)
+	}
+
+	v, err := strconv.ParseFloat(fieldValue, 64)
+	if err != nil {
+		return nil, err
+	}
+	scaled := math.Round(v * scale)
+	if math.IsNaN(scaled) || math.IsInf(scaled, 0) {
+		return nil, fmt.Errorf("invalid scaled value %v", scaled)
+	}
+	// Parse the scaled value as integer, so that overflows are handled.
+	return toMMDBInt(it, strconv.FormatFloat(scaled, 'f', 0, 64), optim)
+}
+
+func toMMDBInt32(v int64, _ Optimizations) (mmdbtype.DataType, error) {
+	return mmdbtype.Int32(int32(v)), nil
+}
+
+func toMMDBInt64(v int64, optim Optimizations) (mmdbtype.DataType, error) {
+	// mmdb has no signed 64-bit integer type, so use the closest fitting type.
+	switch {
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		return mmdbtype.Int32(int32(v)), nil
+	case v > 0:
+		return mmdbtype.Uint64(uint64(v)), nil
+	default:
+		err := fmt.Errorf("negative int64 values must be at least %d, as mmdb only supports signed 32-bit integers", math.MinInt32)
+		if skip, err := optim.handleOverflow(err); skip || err != nil {
 			return nil, err
 		}
-		if optim.FloatDecimals != 0 {
-			v = roundToDecimalPlaces(v, optim.FloatDecimals)
+		return mmdbtype.Int32(math.MinInt32), nil
+	}
+}
+
+func toMMDBUint16(v uint64, _ Optimizations) mmdbtype.DataType {
+	return mmdbtype.Uint16(uint16(v))
+}
+
+func toMMDBUint32(v uint64, optim Optimizations) mmdbtype.DataType {
+	if optim.ShrinkInts {
+		return shrinkUint(v)
+	}
+	return mmdbtype.Uint32(uint32(v))
+}
+
+func toMMDBUint64(v uint64, optim Optimizations) mmdbtype.DataType {
+	if optim.ShrinkInts {
+		return shrinkUint(v)
+	}
+	return mmdbtype.Uint64(v)
+}
+
+// parseUint parses an unsigned integer like strconv.ParseUint, but also
+// reports negative integers as out of range, with zero as the clamped value.
+func parseUint(value string, bitSize int) (uint64, error) {
+	v, err := strconv.ParseUint(value, 10, bitSize)
+	if err != nil && strings.HasPrefix(value, "-") {
+		if _, intErr := strconv.ParseInt(value, 10, 64); intErr == nil || errors.Is(intErr, strconv.ErrRange) {
+			return 0, &strconv.NumError{Func: "ParseUint", Num: value, Err: strconv.ErrRange}
 		}
-		return mmdbtype.Float64(v), nil
+	}
+	return v, err
+}
+
+// toMMDBDatetime parses a time with the given layout and returns it as unix
+// epoch seconds.
+func toMMDBDatetime(fieldValue, layout string) (mmdbtype.DataType, error) {
+	t, err := time.Parse(layout, fieldValue)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse time with layout %q: %w", layout, err)
+	}
+	if t.Unix() < 0 {
+		return nil, fmt.Errorf("time %s (parsed with layout %q) is before the unix epoch", t, layout)
+	}
+	return mmdbtype.Uint64(uint64(t.Unix())), nil
+}
+
+// toMMDBDuration parses a Go duration, eg. "1h30m", and returns it as uint32
+// seconds rounded to the nearest second. Durations out of range are handled
+// by the overflow mode.
+func toMMDBDuration(fieldValue string, optim Optimizations) (mmdbtype.DataType, error) {
+	d, err := time.ParseDuration(fieldValue)
+	if err != nil {
+		return nil, err
+	}
+	seconds := d.Round(time.Second) / time.Second
+	// Parse the seconds as integer, so that overflows are handled.
+	v, err := toMMDBInt(intTypes["uint32"], strconv.FormatInt(int64(seconds), 10), optim)
+	if err != nil {
+		return nil, fmt.Errorf("duration %s must be between 0s and %s: %w", d, time.Duration(math.MaxUint32)*time.Second, err)
+	}
+	return v, nil
+}
+
+// toMMDBRatio parses a number between 0 and the given base, eg. 100 for
+// percentages, and returns it divided by the base as float32. Values out of
+// range are handled by the overflow mode.
+func toMMDBRatio(fieldValue string, base float64, optim Optimizations) (mmdbtype.DataType, error) {
+	v, err := strconv.ParseFloat(optim.numberValue(fieldValue), 64)
+	if err != nil {
+		return nil, err
+	}
+	if math.IsNaN(v) {
+		return nil, fmt.Errorf("invalid number %q", fieldValue)
+	}
+	if v < 0 || v > base {
+		err := fmt.Errorf("value %v must be between 0 and %v", v, base)
+		if skip, err := optim.handleOverflow(err); skip || err != nil {
+			return nil, err
+		}
+		v = math.Max(0, math.Min(v, base))
+	}
+	return mmdbtype.Float32(optim.roundFloat(v / base)), nil
+}
 
+// toMMDBCoordinate parses a coordinate between -limit and limit, eg. 90 for
+// latitudes, and returns it as float64. Values out of range are handled by
+// the overflow mode.
+func toMMDBCoordinate(fieldValue string, limit float64, optim Optimizations) (mmdbtype.DataType, error) {
+	v, err := strconv.ParseFloat(optim.numberValue(fieldValue), 64)
+	if err != nil {
+		return nil, err
+	}
+	if math.IsNaN(v) {
+		return nil, fmt.Errorf("invalid number %q", fieldValue)
+	}
+	if v < -limit || v > limit {
+		err := fmt.Errorf("value %v must be between %v and %v", v, -limit, limit)
+		if skip, err := optim.handleOverflow(err); skip || err != nil {
+			return nil, err
+		}
+		v = math.Max(-limit, math.Min(v, limit))
+	}
+	return mmdbtype.Float64(optim.roundFloat(v)), nil
+}
+
+// toMMDBJSON parses a json object or array and converts it to mmdb types.
+// Integers are stored as int32 if they fit and as uint64 if positive, all
+// other numbers are stored as float64.
+func toMMDBJSON(fieldValue string, optim Optimizations) (mmdbtype.DataType, error) {
+	decoder := json.NewDecoder(strings.NewReader(fieldValue))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+	if decoder.More() {
+		return nil, errors.New("invalid json: unexpected data after value")
+	}
+
+	switch value.(type) {
+	case map[string]any, []any:
+		return jsonToMMDB(value, optim)
 	default:
-		return nil, errors.New("unsupport type")
+		return nil, fmt.Errorf("json value must be an object or array, not a %T", value)
 	}
 }
 
-func toMMDBArray(fieldType, fieldValue string, optim Optimizations) (mmdbtype.DataType, error) {
-	fields := strings.Fields(fieldValue)
+func jsonToMMDB(value any, optim Optimizations) (mmdbtype.DataType, error) {
+	switch v := value.(type) {
+	case map[string]any:
+		m := make(mmdbtype.Map, len(v))
+		for key, subValue := range v {
+			// Ignore null values.
+			if subValue == nil {
+				continue
+			}
+			converted, err := jsonToMMDB(subValue, optim)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			m[mmdbtype.String(key)] = converted
+		}
+		return m, nil
+
+	case []any:
+		array := make(mmdbtype.Slice, 0, len(v))
+		for i, entry := range v {
+			converted, err := jsonToMMDB(entry, optim)
+			if err != nil {
+				return nil, fmt.Errorf("array entry #%d is invalid: %w", i, err)
+			}
+			array = append(array, converted)
+		}
+		return array, nil
+
+	case string:
+		return mmdbtype.String(v), nil
+
+	case bool:
+		return mmdbtype.Bool(v), nil
+
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			switch {
+			case i >= math.MinInt32 && i <= math.MaxInt32:
+				return mmdbtype.Int32(int32(i)), nil
+			case i > 0:
+				return mmdbtype.Uint64(uint64(i)), nil
+			}
+		} else if u, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			return mmdbtype.Uint64(u), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		f = optim.roundFloat(f)
+		return mmdbtype.Float64(f), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported json value type %T", value)
+	}
+}
+
+func toMMDBArray(fieldType, separator, fieldValue string, optim Optimizations) (mmdbtype.DataType, error) {
+	fields := splitArray(fieldValue, separator)
 	array := make([]mmdbtype.DataType, 0, len(fields))
 
-	for i, field := range strings.Fields(fieldValue) {
+	for i, field := range fields {
 		entry, err := toMMDBType(fieldType, field, optim)
 		if err != nil {
 			return nil, fmt.Errorf("array entry #%d is invalid: %w", i, err)
 		}
+		// Omit skipped entries.
+		if entry == nil {
+			continue
+		}
 		array = append(array, entry)
 	}
 
 	return mmdbtype.Slice(array), nil
 }
 
+// cutArraySeparator splits an array entry type of the form "<type>:<separator>"
+// into the type and separator. Datetime layouts and scaledint types contain
+// colons, so these types cannot define a separator.
+func cutArraySeparator(subType string) (entryType, separator string, ok bool) {
+	if subType == "datetime" || strings.HasPrefix(subType, "datetime:") || strings.HasPrefix(subType, "scaledint:") {
+		return subType, "", false
+	}
+	entryType, separator, ok = strings.Cut(subType, ":")
+	if separator == "" {
+		return entryType, "", false
+	}
+	return entryType, separator, ok
+}
+
+// splitArray splits the array value by the given separator, trimming and
+// dropping empty entries. Without separator, the value is split by whitespace.
+func splitArray(value, separator string) []string {
+	if separator == "" {
+		return strings.Fields(value)
+	}
+
+	fields := strings.Split(value, separator)
+	entries := fields[:0]
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if field != "" {
+			entries = append(entries, field)
+		}
+	}
+	return entries
+}
+
+// joinArray joins array entries for the given array type, using the separator
+// defined in the type, the given default separator or a space, just like
+// they are split when converted.
+func joinArray(fieldType, defaultSeparator string, fields []string) string {
+	_, separator, ok := cutArraySeparator(strings.TrimPrefix(fieldType, "array:"))
+	if !ok {
+		separator = defaultSeparator
+	}
+	if separator == "" {
+		separator = " "
+	}
+	return strings.Join(fields, separator)
+}
+
+// joinArrayExact joins array entries like joinArray, but if the joined value
+// would not split into the same entries again, eg. as an entry contains the
+// separator, the entries are joined with arrayFieldSeparator instead. It
+// returns the array type, which defines the separator in that case.
+func joinArrayExact(fieldType, defaultSeparator string, fields []string) (arrayType, value string, err error) {
+	entryType, separator, ok := cutArraySeparator(strings.TrimPrefix(fieldType, "array:"))
+	if !ok {
+		separator = defaultSeparator
+	}
+	value = joinArray(fieldType, defaultSeparator, fields)
+	if slices.Equal(splitArray(value, separator), fields) {
+		return fieldType, value, nil
+	}
+
+	arrayType = "array:" + entryType + ":" + arrayFieldSeparator
+	if _, _, ok := cutArraySeparator(strings.TrimPrefix(arrayType, "array:")); ok {
+		value = strings.Join(fields, arrayFieldSeparator)
+		if slices.Equal(splitArray(value, arrayFieldSeparator), fields) {
+			return arrayType, value, nil
+		}
+	}
+	return "", "", fmt.Errorf("entries of %s cannot be joined without changing them, eg. as they are empty or have surrounding whitespace", fieldType)
+}
+
+// shrinkUint returns the smallest unsigned mmdb type that fits the value.
+func shrinkUint(v uint64) mmdbtype.DataType {
+	switch {
+	case v <= math.MaxUint16:
+		return mmdbtype.Uint16(uint16(v))
+	case v <= math.MaxUint32:
+		return mmdbtype.Uint32(uint32(v))
+	default:
+		return mmdbtype.Uint64(v)
+	}
+}
+
+// roundFloat rounds the value to the configured decimals and calls the
+// rounding warning if the value changed by more than the rounding epsilon.
+func (o Optimizations) roundFloat(v float64) float64 {
+	if o.FloatDecimals == 0 {
+		return v
+	}
+	rounded := roundToDecimalPlaces(v, o.FloatDecimals)
+	if o.RoundingWarning != nil && math.Abs(rounded-v) > o.RoundingEpsilon {
+		o.RoundingWarning(o.field, v, rounded)
+	}
+	return rounded
+}
+
+// normalizeString applies the NormalizeStrings to the value, if the field is
+// selected by NormalizeFields.
+func (o Optimizations) normalizeString(value string) string {
+	if len(o.NormalizeFields) > 0 && !slices.Contains(o.NormalizeFields, o.field) {
+		return value
+	}
+	for _, normalization := range o.NormalizeStrings {
+		switch normalization {
+		case NormalizeTrim:
+			value = strings.TrimSpace(value)
+		case NormalizeLower:
+			value = strings.ToLower(value)
+		case NormalizeUpper:
+			value = strings.ToUpper(value)
+		}
+	}
+	return value
+}
+
+// numberValue returns the number with a single leading "+" and the "_" digit
+// separators removed, if LenientNumbers is enabled. Separators must be
+// between digits, otherwise the value is returned as is and fails to parse.
+func (o Optimizations) numberValue(value string) string {
+	if !o.LenientNumbers {
+		return value
+	}
+	number, hasPlus := strings.CutPrefix(value, "+")
+	if hasPlus && (strings.HasPrefix(number, "+") || strings.HasPrefix(number, "-")) {
+		return value
+	}
+	if !strings.Contains(number, "_") {
+		return number
+	}
+
+	isDigit := func(i int) bool {
+		return i >= 0 && i < len(number) && number[i] >= '0' && number[i] <= '9'
+	}
+	var b strings.Builder
+	b.Grow(len(number))
+	for i := 0; i < len(number); i++ {
+		if number[i] != '_' {
+			b.WriteByte(number[i])
+			continue
+		}
+		if !isDigit(i-1) || !isDigit(i+1) {
+			return value
+		}
+	}
+	return b.String()
+}
+
 func roundToDecimalPlaces(num float64, decimalPlaces int) float64 {
 	if decimalPlaces < 0 {
 		decimalPlaces = 0
@@ -2,39 +2,498 @@ package mmdbmeld
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
 	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
 	"net"
-	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
+// inferTypesSamples is the amount of rows sampled to infer types.
+const inferTypesSamples = 100
+
 // CSVSource reads geoip data in csv format.
 type CSVSource struct {
 	file   string
 	reader *csv.Reader
+	closer io.Closer
 	fields []string
 	types  map[string]string
 
+	// Lines skipped before the csv reader, added to its line numbers.
+	lineOffset int
+
+	// Rows with fewer or more columns than fields, if allowed.
+	raggedRows int
+
+	// Rows read ahead to infer types.
+	sampled       []csvRow
+	sampledErr    error
+	inferredTypes map[string]string
+
+	lineEstimate
+	valueProcessing
+	errorHandling
+	networkFilter
 	err error
 }
 
 // LoadCSVSource returns a new CSVSource.
 func LoadCSVSource(input DatabaseInput, types map[string]string) (*CSVSource, error) {
-	file, err := os.Open(input.File)
+	return loadCSVSource(input, types, ',')
+}
+
+// LoadTSVSource returns a new CSVSource reading tab-separated values.
+func LoadTSVSource(input DatabaseInput, types map[string]string) (*CSVSource, error) {
+	return loadCSVSource(input, types, '\t')
+}
+
+func loadCSVSource(input DatabaseInput, types map[string]string, delimiter rune) (*CSVSource, error) {
+	filter, err := newNetworkFilter(input)
+	if err != nil {
+		return nil, err
+	}
+	// Check configured delimiter and comment character.
+	if input.Delimiter != "" {
+		r, err := singleRune(input.Delimiter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid delimiter: %w", err)
+		}
+		delimiter = r
+	}
+	var comment rune
+	if input.Comment != "" {
+		r, err := singleRune(input.Comment)
+		if err != nil {
+			return nil, fmt.Errorf("invalid comment character: %w", err)
+		}
+		comment = r
+	}
+
+	file, err := openInput(input)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
-	reader := csv.NewReader(bufio.NewReader(file))
+	bufReader, err := decodeBOM(bufio.NewReader(file))
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if input.SkipRows < 0 {
+		_ = file.Close()
+		return nil, errors.New("skipRows must not be negative")
+	}
+	if err := skipLines(bufReader, input.SkipRows); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	reader := csv.NewReader(bufReader)
+	reader.Comma = delimiter
+	reader.Comment = comment
+	reader.LazyQuotes = input.LazyQuotes
+	reader.TrimLeadingSpace = input.TrimLeadingSpace
 	reader.FieldsPerRecord = len(input.Fields)
+	if input.AllowRaggedRows {
+		reader.FieldsPerRecord = -1
+	}
+
+	// Read header, if the file has one.
+	// The header only defines the fields if they are not configured.
+	fields := input.Fields
+	if input.HasHeader {
+		header, err := reader.Read()
+		if err != nil {
+			_ = file.Close()
+			return nil, fmt.Errorf("failed to read header: %w", err)
+		}
+		if len(fields) == 0 {
+			fields = make([]string, 0, len(header))
+			for _, column := range header {
+				fields = append(fields, strings.TrimSpace(column))
+			}
+		}
+	}
+	if len(fields) == 0 {
+		_ = file.Close()
+		return nil, errors.New("no fields defined and file has no header")
+	}
+	fields, err = newFieldMapping(input).targetKeys(fields)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	types, err = resolveColumnTypes(types, fields)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if err := checkArrayFields(fields, types); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	// Estimate the entries without the skipped lines and the header.
+	skipped := input.SkipRows
+	if input.HasHeader {
+		skipped++
+	}
+
+	csvSource := &CSVSource{
+		file:            inputName(input),
+		reader:          reader,
+		closer:          file,
+		fields:          fields,
+		types:           types,
+		lineOffset:      input.SkipRows,
+		lineEstimate:    newLineEstimate(input, skipped),
+		valueProcessing: newValueProcessing(input, types),
+		errorHandling:   newErrorHandling(input),
+		networkFilter:   filter,
+	}
+	if input.InferTypes {
+		csvSource.inferTypes()
+	}
+	return csvSource, nil
+}
+
+// resolveColumnTypes returns the types with the positional keys, like "$3"
+// for the third column, replaced by the field names of their columns.
+// Positional types take precedence over types of the field names.
+func resolveColumnTypes(types map[string]string, fields []string) (map[string]string, error) {
+	var resolved map[string]string
+	for key, fieldType := range types {
+		column, ok := columnIndex(key)
+		if !ok {
+			continue
+		}
+		if column < 1 || column > len(fields) {
+			return nil, fmt.Errorf("type of column %s is out of range, the input has %d columns", key, len(fields))
+		}
+		switch fieldName := fields[column-1]; {
+		case fieldName == "from" || fieldName == "to" || fieldName == "" || fieldName == "-":
+			return nil, fmt.Errorf("type of column %s cannot be set, the column is not a value field", key)
+		case strings.HasSuffix(fieldName, arrayKeySuffix):
+			return nil, fmt.Errorf("type of column %s cannot be set, the column is collected into an array", key)
+		}
+		if resolved == nil {
+			resolved = make(map[string]string, len(types))
+		}
+		resolved[fields[column-1]] = fieldType
+	}
+	if resolved == nil {
+		return types, nil
+	}
+
+	for key, fieldType := range types {
+		if _, ok := columnIndex(key); ok {
+			continue
+		}
+		if _, ok := resolved[key]; !ok {
+			resolved[key] = fieldType
+		}
+	}
+	return resolved, nil
+}
+
+// columnIndex returns the 1-based column index of a positional type key,
+// like "$3".
+func columnIndex(key string) (int, bool) {
+	digits, ok := strings.CutPrefix(key, "$")
+	if !ok || digits == "" || strings.Trim(digits, "0123456789") != "" {
+		return 0, false
+	}
+	column, err := strconv.Atoi(digits)
+	if err != nil {
+		return 0, true
+	}
+	return column, true
+}
+
+// csvRow is a row read from a csv file, including its line number, or the
+// error of a row that could not be parsed.
+type csvRow struct {
+	values []string
+	line   int
+	err    error
+}
+
+// inferTypes reads the first rows and infers the types of all fields that
+// have no declared type. The rows are kept to be returned as entries.
+func (csv *CSVSource) inferTypes() {
+	// Sample first rows.
+	for len(csv.sampled) < inferTypesSamples {
+		row, err := csv.reader.Read()
+		if err != nil {
+			if csvParseError(err) != nil {
+				csv.sampled = append(csv.sampled, csvRow{err: err})
+				continue
+			}
+			csv.sampledErr = err
+			break
+		}
+		line, _ := csv.reader.FieldPos(0)
+		csv.sampled = append(csv.sampled, csvRow{values: row, line: line + csv.lineOffset})
+	}
+
+	// Infer types of fields without declared type.
+	csv.inferredTypes = make(map[string]string)
+	types := make(map[string]string, len(csv.types)+len(csv.fields))
+	for k, v := range csv.types {
+		types[k] = v
+	}
+	for i, fieldName := range csv.fields {
+		switch fieldName {
+		case "from", "to", "", "-":
+			continue
+		}
+		if strings.HasSuffix(fieldName, arrayKeySuffix) {
+			continue
+		}
+		if _, ok := csv.types[fieldName]; ok {
+			continue
+		}
+
+		samples := make([]string, 0, len(csv.sampled))
+		for _, row := range csv.sampled {
+			if i >= len(row.values) {
+				continue
+			}
+			sample := row.values[i]
+			if csv.trimSpace && !slices.Contains(csv.preserveSpace, fieldName) {
+				sample = strings.TrimSpace(sample)
+			}
+			if sample != "" {
+				samples = append(samples, sample)
+			}
+		}
+		if fieldType := inferType(samples); fieldType != "" {
+			types[fieldName] = fieldType
+			csv.inferredTypes[fieldName] = fieldType
+		}
+	}
+	csv.types = types
+}
+
+// FieldNames returns the names of the fields of the source.
+func (csv *CSVSource) FieldNames() []string {
+	return csv.fields
+}
+
+// RaggedRows returns the amount of rows with fewer or more columns than
+// fields. They are only read if AllowRaggedRows is enabled.
+func (csv *CSVSource) RaggedRows() int {
+	return csv.raggedRows
+}
+
+// InferredTypes returns the types that were inferred for fields without
+// declared type. It returns nil if type inference is disabled.
+func (csv *CSVSource) InferredTypes() map[string]string {
+	return csv.inferredTypes
+}
+
+// inferType returns the narrowest type that parses all samples.
+// It returns an empty string if there are no samples.
+func inferType(samples []string) string {
+	if len(samples) == 0 {
+		return ""
+	}
+
+	for _, candidate := range []struct {
+		fieldType string
+		parses    func(string) bool
+	}{
+		{"bool", func(s string) bool {
+			return strings.EqualFold(s, "true") || strings.EqualFold(s, "false")
+		}},
+		{"uint32", func(s string) bool {
+			_, err := strconv.ParseUint(s, 10, 32)
+			return err == nil
+		}},
+		{"uint64", func(s string) bool {
+			_, err := strconv.ParseUint(s, 10, 64)
+			return err == nil
+		}},
+		{"int32", func(s string) bool {
+			_, err := strconv.ParseInt(s, 10, 32)
+			return err == nil
+		}},
+		{"int64", func(s string) bool {
+			_, err := strconv.ParseInt(s, 10, 64)
+			return err == nil
+		}},
+		{"float64", func(s string) bool {
+			_, err := strconv.ParseFloat(s, 64)
+			return err == nil
+		}},
+	} {
+		if !slices.ContainsFunc(samples, func(s string) bool { return !candidate.parses(s) }) {
+			return candidate.fieldType
+		}
+	}
+	return "string"
+}
+
+// readRow returns the next row, starting with the rows sampled to infer types.
+func (csv *CSVSource) readRow() (row []string, line int, err error) {
+	if len(csv.sampled) > 0 {
+		next := csv.sampled[0]
+		csv.sampled = csv.sampled[1:]
+		return next.values, next.line, next.err
+	}
+	if csv.sampledErr != nil {
+		return nil, 0, csv.sampledErr
+	}
+
+	row, err = csv.reader.Read()
+	if err != nil {
+		return nil, 0, err
+	}
+	line, _ = csv.reader.FieldPos(0)
+	return row, line + csv.lineOffset, nil
+}
+
+// csvParseError returns the error as a parse error of a row, or nil if it is
+// not a parse error.
+func csvParseError(err error) *csv.ParseError {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return parseErr
+	}
+	return nil
+}
+
+// decodeBOM removes a byte order mark at the start of the reader.
+// UTF-16 data, as indicated by its byte order mark, is decoded to UTF-8.
+func decodeBOM(reader *bufio.Reader) (*bufio.Reader, error) {
+	bom, err := reader.Peek(3)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	switch {
+	case bytes.HasPrefix(bom, []byte{0xEF, 0xBB, 0xBF}):
+		_, _ = reader.Discard(3)
+		return reader, nil
+	case bytes.HasPrefix(bom, []byte{0xFF, 0xFE}):
+		_, _ = reader.Discard(2)
+		return bufio.NewReader(&utf16Reader{reader: reader, order: binary.LittleEndian, unread: -1}), nil
+	case bytes.HasPrefix(bom, []byte{0xFE, 0xFF}):
+		_, _ = reader.Discard(2)
+		return bufio.NewReader(&utf16Reader{reader: reader, order: binary.BigEndian, unread: -1}), nil
+	default:
+		return reader, nil
+	}
+}
+
+// utf16Reader decodes UTF-16 data to UTF-8.
+type utf16Reader struct {
+	reader  io.Reader
+	order   binary.ByteOrder
+	pending []byte
+	err     error
+
+	// Code unit read ahead after an invalid surrogate, or -1.
+	unread rune
+}
 
-	return &CSVSource{
-		file:   input.File,
-		reader: reader,
-		fields: input.Fields,
-		types:  types,
-	}, nil
+func (u *utf16Reader) Read(p []byte) (int, error) {
+	for len(u.pending) < len(p) && u.err == nil {
+		var r rune
+		r, u.err = u.readRune()
+		if u.err == nil {
+			u.pending = utf8.AppendRune(u.pending, r)
+		}
+	}
+	n := copy(p, u.pending)
+	u.pending = u.pending[n:]
+	if n == 0 && u.err != nil {
+		return 0, u.err
+	}
+	return n, nil
+}
+
+// readRune reads the next rune, which may be encoded as a surrogate pair.
+// Invalid surrogates are returned as the replacement character.
+func (u *utf16Reader) readRune() (rune, error) {
+	r1, err := u.readUnit()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case r1 < 0xD800 || r1 > 0xDFFF:
+		return r1, nil
+	case r1 > 0xDBFF:
+		// Low surrogate without high surrogate.
+		return utf8.RuneError, nil
+	}
+
+	r2, err := u.readUnit()
+	switch {
+	case errors.Is(err, io.EOF):
+		return 0, errors.New("invalid utf-16 data: incomplete surrogate pair")
+	case err != nil:
+		return 0, err
+	case r2 < 0xDC00 || r2 > 0xDFFF:
+		// High surrogate without low surrogate, keep the next unit.
+		u.unread = r2
+		return utf8.RuneError, nil
+	}
+	return utf16.DecodeRune(r1, r2), nil
+}
+
+// readUnit reads the next 16-bit code unit.
+func (u *utf16Reader) readUnit() (rune, error) {
+	if u.unread >= 0 {
+		r := u.unread
+		u.unread = -1
+		return r, nil
+	}
+	var b [2]byte
+	if _, err := io.ReadFull(u.reader, b[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, errors.New("invalid utf-16 data: odd number of bytes")
+		}
+		return 0, err
+	}
+	return rune(u.order.Uint16(b[:])), nil
+}
+
+// skipLines discards the given amount of lines of the reader.
+// It fails if the reader has no data left after skipping.
+func skipLines(reader *bufio.Reader, lines int) error {
+	for i := 0; i < lines; i++ {
+		if _, err := reader.ReadString('\n'); err != nil {
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("file ended after skipping %d of %d rows", i, lines)
+			}
+			return fmt.Errorf("failed to skip rows: %w", err)
+		}
+	}
+	if lines > 0 {
+		if _, err := reader.Peek(1); err != nil {
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("file ended after skipping %d rows", lines)
+			}
+			return fmt.Errorf("failed to skip rows: %w", err)
+		}
+	}
+	return nil
+}
+
+// singleRune returns the only rune of the given string.
+func singleRune(s string) (rune, error) {
+	r, size := utf8.DecodeRuneInString(s)
+	if r == utf8.RuneError || size != len(s) {
+		return 0, fmt.Errorf("%q must be exactly one character", s)
+	}
+	return r, nil
 }
 
 // Name returns an identifying name for the source.
@@ -45,28 +504,89 @@ func (csv *CSVSource) Name() string {
 // NextEntry returns the next entry of the source.
 // If nil, nil is returned, stop reading and check Err().
 func (csv *CSVSource) NextEntry() (*SourceEntry, error) {
+	return csv.NextEntryContext(context.Background())
+}
+
+// NextEntryContext returns the next entry of the source.
+// If nil, nil is returned, stop reading and check Err().
+// If the context is canceled, reading stops and Err() returns the context error.
+func (csv *CSVSource) NextEntryContext(ctx context.Context) (*SourceEntry, error) {
+	for {
+		se, err := csv.nextEntry(ctx)
+		if se != nil {
+			if err = csv.processValues(se); err != nil {
+				err = fmt.Errorf("%s: %w", entryPosition(csv, se), err)
+				se = nil
+			}
+		}
+		if err != nil {
+			if csv.skip(err) {
+				continue
+			}
+			if csv.fail() {
+				csv.err = err
+				_ = csv.Close()
+				return nil, nil //nolint:nilerr
+			}
+		}
+		return se, err
+	}
+}
+
+func (csv *CSVSource) nextEntry(ctx context.Context) (*SourceEntry, error) {
 	// Check if there is an error, do not read if there is an error.
 	if csv.err != nil {
 		return nil, nil //nolint:nilerr
 	}
 
+	// Check if the context was canceled.
+	if err := ctx.Err(); err != nil {
+		csv.err = err
+		_ = csv.Close()
+		return nil, nil //nolint:nilerr
+	}
+
 	// Read and parse line.
-	row, err := csv.reader.Read()
+	row, line, err := csv.readRow()
+	if parseErr := csvParseError(err); parseErr != nil && csv.onError == OnErrorSkip {
+		// Rows that cannot be parsed are skipped, if enabled, and reading
+		// continues with the next row. Otherwise, reading stops.
+		return nil, errorAtLine(csv.Name(), parseErr.StartLine+csv.lineOffset, parseErr.Err)
+	}
 	if err != nil {
 		csv.err = err
+		_ = csv.Close()
 		return nil, nil //nolint:nilerr
 	}
+	// Only map the columns present in ragged rows.
+	if len(row) != len(csv.fields) {
+		csv.raggedRows++
+	}
+	return rowEntry(csv.Name(), csv.fields, csv.types, csv.trimSpace, row, line)
+}
+
+// rowEntry returns the entry of a row of columns, which are mapped to the
+// given fields by position. Columns missing in the row are left out and
+// extra columns are ignored. The from and to columns are trimmed, if
+// trimRange is set. Columns of fields like "languages[]" are joined into an
+// array value in the order of the fields, skipping empty cells.
+func rowEntry(sourceName string, fields []string, types map[string]string, trimRange bool, row []string, line int) (*SourceEntry, error) {
 	se := &SourceEntry{
 		Values: make(map[string]SourceValue),
+		Line:   line,
 	}
-	for i := 0; i < len(csv.fields); i++ {
-		fieldName := csv.fields[i]
+	for i := 0; i < len(fields) && i < len(row); i++ {
+		fieldName := fields[i]
+
+		if trimRange && (fieldName == "from" || fieldName == "to") {
+			row[i] = strings.TrimSpace(row[i])
+		}
 
 		switch fieldName {
 		case "from":
 			fromIP := net.ParseIP(row[i])
 			if fromIP == nil {
-				return nil, fmt.Errorf("failed to parse IP %q", row[i])
+				return nil, errorAtLine(sourceName, line, fmt.Errorf("failed to parse IP %q", row[i]))
 			}
 			se.From = fromIP
 			// Force IPv4 representation for IPv4 for better further processing.
@@ -76,7 +596,7 @@ func (csv *CSVSource) NextEntry() (*SourceEntry, error) {
 		case "to":
 			toIP := net.ParseIP(row[i])
 			if toIP == nil {
-				return nil, fmt.Errorf("failed to parse IP %q", row[i])
+				return nil, errorAtLine(sourceName, line, fmt.Errorf("failed to parse IP %q", row[i]))
 			}
 			se.To = toIP
 			// Force IPv4 representation for IPv4 for better further processing.
@@ -86,10 +606,13 @@ func (csv *CSVSource) NextEntry() (*SourceEntry, error) {
 		case "", "-":
 			// Ignore
 		default:
-			fieldType, ok := csv.types[fieldName]
-			if ok && fieldType != "" && fieldType != "-" {
+			if key, ok := strings.CutSuffix(fieldName, arrayKeySuffix); ok {
+				addArrayColumn(se, key, types, row[i])
+				continue
+			}
+			if fieldType, ok := fieldTypeFor(types, fieldName); ok {
 				se.Values[fieldName] = SourceValue{
-					Type:  csv.types[fieldName],
+					Type:  fieldType,
 					Value: row[i],
 				}
 			}
@@ -99,6 +622,39 @@ func (csv *CSVSource) NextEntry() (*SourceEntry, error) {
 	return se, nil
 }
 
+// addArrayColumn appends the value of a column to the array value of the key,
+// unless it is empty.
+func addArrayColumn(se *SourceEntry, key string, types map[string]string, value string) {
+	fieldType, ok := fieldTypeFor(types, key)
+	if !ok || strings.TrimSpace(value) == "" {
+		return
+	}
+	// The type was checked when loading the source.
+	arrayType, separator, _ := arrayFieldType(fieldType)
+
+	if existing, ok := se.Values[key]; ok {
+		value = existing.Value + separator + value
+	}
+	se.Values[key] = SourceValue{
+		Type:  arrayType,
+		Value: value,
+	}
+}
+
+// Close closes the underlying file of the source and stops reading.
+// Closing an already closed source does nothing.
+func (csv *CSVSource) Close() error {
+	if csv.closer == nil {
+		return nil
+	}
+	if csv.err == nil {
+		csv.err = errSourceClosed
+	}
+	err := csv.closer.Close()
+	csv.closer = nil
+	return err
+}
+
 // Err returns the processing error encountered by the source.
 func (csv *CSVSource) Err() error {
 	switch {
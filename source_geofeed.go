@@ -0,0 +1,221 @@
+package mmdbmeld
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// geofeedColumns are the geofeed columns following the prefix, with their
+// names as used in the fieldMap and the keys they are stored under if they
+// are not mapped.
+var geofeedColumns = []struct {
+	name string
+	key  string
+}{
+	{"country", "country.iso_code"},
+	{"subdivisions", "subdivisions.0.iso_code"},
+	{"city", "city.names.en"},
+	{"postalcode", "postal.code"},
+}
+
+// GeofeedSource reads geoip data in the geofeed format defined in RFC 8805.
+type GeofeedSource struct {
+	file   string
+	reader *csv.Reader
+	closer io.Closer
+	types  map[string]string
+	// Keys of the geofeed columns, by column.
+	keys []string
+
+	lineEstimate
+	valueProcessing
+	errorHandling
+	networkFilter
+	err error
+}
+
+// LoadGeofeedSource returns a new GeofeedSource.
+func LoadGeofeedSource(input DatabaseInput, types map[string]string) (*GeofeedSource, error) {
+	filter, err := newNetworkFilter(input)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := geofeedKeys(input)
+	if err != nil {
+		return nil, err
+	}
+	file, err := openInput(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	reader := csv.NewReader(bufio.NewReader(file))
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	return &GeofeedSource{
+		file:            inputName(input),
+		reader:          reader,
+		closer:          file,
+		types:           types,
+		keys:            keys,
+		lineEstimate:    newLineEstimate(input, 0),
+		valueProcessing: newValueProcessing(input, types),
+		errorHandling:   newErrorHandling(input),
+		networkFilter:   filter,
+	}, nil
+}
+
+// geofeedKeys returns the keys of the geofeed columns, as mapped by the
+// fieldMap of the input. Unmapped columns use their default key, unless
+// unmapped fields are dropped.
+func geofeedKeys(input DatabaseInput) ([]string, error) {
+	fieldMap := make(map[string]string, len(geofeedColumns)+len(input.FieldMap))
+	names := make([]string, 0, len(geofeedColumns))
+	for _, column := range geofeedColumns {
+		if !input.DropUnmapped {
+			fieldMap[column.name] = column.key
+		}
+		names = append(names, column.name)
+	}
+	for name, key := range input.FieldMap {
+		fieldMap[name] = key
+	}
+	input.FieldMap = fieldMap
+	return newFieldMapping(input).targetKeys(names)
+}
+
+// Name returns an identifying name for the source.
+func (gf *GeofeedSource) Name() string {
+	return gf.file
+}
+
+// NextEntry returns the next entry of the source.
+// If nil, nil is returned, stop reading and check Err().
+func (gf *GeofeedSource) NextEntry() (*SourceEntry, error) {
+	return gf.NextEntryContext(context.Background())
+}
+
+// NextEntryContext returns the next entry of the source.
+// If nil, nil is returned, stop reading and check Err().
+// If the context is canceled, reading stops and Err() returns the context error.
+func (gf *GeofeedSource) NextEntryContext(ctx context.Context) (*SourceEntry, error) {
+	for {
+		se, err := gf.nextEntry(ctx)
+		if se != nil {
+			if err = gf.processValues(se); err != nil {
+				err = fmt.Errorf("%s: %w", entryPosition(gf, se), err)
+				se = nil
+			}
+		}
+		if err != nil {
+			if gf.skip(err) {
+				continue
+			}
+			if gf.fail() {
+				gf.err = err
+				_ = gf.Close()
+				return nil, nil //nolint:nilerr
+			}
+		}
+		return se, err
+	}
+}
+
+func (gf *GeofeedSource) nextEntry(ctx context.Context) (*SourceEntry, error) {
+	// Check if there is an error, do not read if there is an error.
+	if gf.err != nil {
+		return nil, nil //nolint:nilerr
+	}
+
+	// Check if the context was canceled.
+	if err := ctx.Err(); err != nil {
+		gf.err = err
+		_ = gf.Close()
+		return nil, nil //nolint:nilerr
+	}
+
+	// Read and parse line.
+	row, err := gf.reader.Read()
+	if err != nil {
+		gf.err = err
+		_ = gf.Close()
+		return nil, nil //nolint:nilerr
+	}
+	line, _ := gf.reader.FieldPos(0)
+
+	// Parse network.
+	prefix := strings.TrimSpace(row[0])
+	_, ipNet, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return nil, errorAtLine(gf.Name(), line, fmt.Errorf("failed to parse net %s: %w", prefix, err))
+	}
+	se := &SourceEntry{
+		Net:    ipNet,
+		Values: make(map[string]SourceValue),
+		Line:   line,
+	}
+
+	// Parse values, omitting empty columns.
+	for i, fieldName := range gf.keys {
+		if i+1 >= len(row) {
+			break
+		}
+		value := strings.TrimSpace(row[i+1])
+		if value == "" {
+			continue
+		}
+		if fieldType, ok := fieldTypeFor(gf.types, fieldName); ok {
+			se.Values[fieldName] = SourceValue{
+				Type:  fieldType,
+				Value: value,
+			}
+		}
+	}
+
+	return se, nil
+}
+
+// FieldNames returns the names of the fields of the geofeed columns, unless
+// they are dropped.
+func (gf *GeofeedSource) FieldNames() []string {
+	var names []string
+	for _, key := range gf.keys {
+		if key != "" && key != "-" {
+			names = append(names, key)
+		}
+	}
+	return names
+}
+
+// Close closes the underlying file of the source and stops reading.
+// Closing an already closed source does nothing.
+func (gf *GeofeedSource) Close() error {
+	if gf.closer == nil {
+		return nil
+	}
+	if gf.err == nil {
+		gf.err = errSourceClosed
+	}
+	err := gf.closer.Close()
+	gf.closer = nil
+	return err
+}
+
+// Err returns the processing error encountered by the source.
+func (gf *GeofeedSource) Err() error {
+	switch {
+	case gf.err == nil:
+		return nil
+	case errors.Is(gf.err, io.EOF):
+		return nil
+	default:
+		return gf.err
+	}
+}
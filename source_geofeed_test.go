@@ -0,0 +1,93 @@
+package mmdbmeld
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGeofeedSource(t *testing.T) {
+	t.Parallel()
+
+	data := `# prefix,country,region,city,postal
+192.0.2.0/24,AT,AT-9,Vienna,1010
+2001:db8::/32,DE,,,
+`
+	file := filepath.Join(t.TempDir(), "test.geofeed")
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dbConfig := DatabaseConfig{
+		Types: map[string]string{
+			"country.iso_code":        "string",
+			"subdivisions.0.iso_code": "string",
+			"city":                    "string",
+			"postal.code":             "string",
+		},
+		Inputs: []DatabaseInput{{
+			File:     file,
+			FieldMap: map[string]string{"city": "city"},
+		}},
+	}
+
+	// Unmapped columns use their default keys.
+	sources, err := LoadSources(dbConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, ok := sources[0].(*GeofeedSource)
+	if !ok {
+		t.Fatalf("expected geofeed source, got %T", sources[0])
+	}
+	if fmt.Sprintf("%v", source.FieldNames()) != "[country.iso_code subdivisions.0.iso_code city postal.code]" {
+		t.Fatalf("unexpected fields: %v", source.FieldNames())
+	}
+	var entries []string
+	for {
+		entry, err := source.NextEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil {
+			break
+		}
+		record, err := entry.ToMMDBMap(Optimizations{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, fmt.Sprintf("%s:%v", entry.Net, record))
+	}
+	if source.Err() != nil {
+		t.Fatal(source.Err())
+	}
+	expected := "[192.0.2.0/24:map[city:Vienna country:map[iso_code:AT] postal:map[code:1010] subdivisions:[map[iso_code:AT-9]]] 2001:db8::/32:map[country:map[iso_code:DE]]]"
+	if fmt.Sprintf("%v", entries) != expected {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+
+	// Unmapped columns are dropped, if configured.
+	dbConfig.Inputs[0].DropUnmapped = true
+	sources, err = LoadSources(dbConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source = sources[0].(*GeofeedSource) //nolint:forcetypeassert
+	_ = source.Close()
+	if fmt.Sprintf("%v", source.FieldNames()) != "[city]" {
+		t.Fatalf("unexpected fields: %v", source.FieldNames())
+	}
+
+	// Duplicate keys fail, unless allowed.
+	dbConfig.Inputs[0].DropUnmapped = false
+	dbConfig.Inputs[0].FieldMap = map[string]string{"city": "country.iso_code"}
+	if _, err := LoadSources(dbConfig); err == nil {
+		t.Fatal("expected error for duplicate keys")
+	}
+	dbConfig.Inputs[0].AllowDuplicateKeys = true
+	sources, err = LoadSources(dbConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = sources[0].Close()
+}
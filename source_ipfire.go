@@ -2,12 +2,12 @@ package mmdbmeld
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/textproto"
-	"os"
 	"strings"
 )
 
@@ -15,28 +15,40 @@ import (
 type IPFireSource struct {
 	file     string
 	reader   *textproto.Reader
+	closer   io.Closer
+	line     int
 	fieldMap map[string]string
 	types    map[string]string
 
 	asOrgCache map[string]string
 
+	valueProcessing
+	errorHandling
+	networkFilter
 	err error
 }
 
 // LoadIPFireSource returns a new IPFireSource.
 func LoadIPFireSource(input DatabaseInput, types map[string]string) (*IPFireSource, error) {
-	file, err := os.Open(input.File)
+	filter, err := newNetworkFilter(input)
+	if err != nil {
+		return nil, err
+	}
+	file, err := openInput(input)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
 	reader := textproto.NewReader(bufio.NewReader(file))
 
 	// Skip comment section.
+	var lineNum int
 	for {
 		line, err := reader.ReadLine()
 		if err != nil {
-			continue
+			_ = file.Close()
+			return nil, fmt.Errorf("failed to read comment section: %w", err)
 		}
+		lineNum++
 		// Discard lines until the comments stop.
 		if !strings.HasPrefix(line, "#") {
 			break
@@ -44,11 +56,16 @@ func LoadIPFireSource(input DatabaseInput, types map[string]string) (*IPFireSour
 	}
 
 	return &IPFireSource{
-		file:       input.File,
-		reader:     reader,
-		fieldMap:   input.FieldMap,
-		types:      types,
-		asOrgCache: make(map[string]string),
+		file:            inputName(input),
+		reader:          reader,
+		closer:          file,
+		line:            lineNum,
+		fieldMap:        input.FieldMap,
+		types:           types,
+		asOrgCache:      make(map[string]string),
+		valueProcessing: newValueProcessing(input, types),
+		errorHandling:   newErrorHandling(input),
+		networkFilter:   filter,
 	}, nil
 }
 
@@ -60,6 +77,36 @@ func (ipf *IPFireSource) Name() string {
 // NextEntry returns the next entry of the source.
 // If nil, nil is returned, stop reading and check Err().
 func (ipf *IPFireSource) NextEntry() (*SourceEntry, error) {
+	return ipf.NextEntryContext(context.Background())
+}
+
+// NextEntryContext returns the next entry of the source.
+// If nil, nil is returned, stop reading and check Err().
+// If the context is canceled, reading stops and Err() returns the context error.
+func (ipf *IPFireSource) NextEntryContext(ctx context.Context) (*SourceEntry, error) {
+	for {
+		se, err := ipf.nextEntry(ctx)
+		if se != nil {
+			if err = ipf.processValues(se); err != nil {
+				err = fmt.Errorf("%s: %w", entryPosition(ipf, se), err)
+				se = nil
+			}
+		}
+		if err != nil {
+			if ipf.skip(err) {
+				continue
+			}
+			if ipf.fail() {
+				ipf.err = err
+				_ = ipf.Close()
+				return nil, nil //nolint:nilerr
+			}
+		}
+		return se, err
+	}
+}
+
+func (ipf *IPFireSource) nextEntry(ctx context.Context) (*SourceEntry, error) {
 	// Check if there is an error, do not read if there is an error.
 	if ipf.err != nil {
 		return nil, nil //nolint:nilerr
@@ -67,12 +114,28 @@ func (ipf *IPFireSource) NextEntry() (*SourceEntry, error) {
 
 	// Read data sections.
 	for {
+		// Check if the context was canceled.
+		if err := ctx.Err(); err != nil {
+			ipf.err = err
+			_ = ipf.Close()
+			return nil, nil //nolint:nilerr
+		}
+
 		// Read next section.
 		data, err := ipf.reader.ReadMIMEHeader()
 		if err != nil {
 			ipf.err = err
+			_ = ipf.Close()
 			return nil, nil //nolint:nilerr
 		}
+
+		// Track line numbers: Every value is on its own line, followed by an empty line.
+		sectionLine := ipf.line + 1
+		for _, values := range data {
+			ipf.line += len(values)
+		}
+		ipf.line++
+
 		// If the section is empty, continue to next.
 		if len(data) == 0 {
 			continue
@@ -81,8 +144,9 @@ func (ipf *IPFireSource) NextEntry() (*SourceEntry, error) {
 		// Parse data.
 		se, err := ipf.SourceEntryFromMimeHeader(data)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse ipfire entry: %w", err)
+			return nil, errorAtLine(ipf.Name(), sectionLine, fmt.Errorf("failed to parse ipfire entry: %w", err))
 		}
+		se.Line = sectionLine
 
 		asNum, ok1 := se.Values[ipf.fieldMap["aut-num"]]
 		asOrg, ok2 := se.Values[ipf.fieldMap["name"]]
@@ -111,6 +175,25 @@ func (ipf *IPFireSource) NextEntry() (*SourceEntry, error) {
 	}
 }
 
+// FieldNames returns the names of the fields mapped in the fieldMap.
+func (ipf *IPFireSource) FieldNames() []string {
+	return mappedFieldNames(ipf.fieldMap)
+}
+
+// Close closes the underlying file of the source and stops reading.
+// Closing an already closed source does nothing.
+func (ipf *IPFireSource) Close() error {
+	if ipf.closer == nil {
+		return nil
+	}
+	if ipf.err == nil {
+		ipf.err = errSourceClosed
+	}
+	err := ipf.closer.Close()
+	ipf.closer = nil
+	return err
+}
+
 // Err returns the processing error encountered by the source.
 func (ipf *IPFireSource) Err() error {
 	switch {
@@ -0,0 +1,317 @@
+package mmdbmeld
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// Layout of the binary IPFire location database, version 1.
+// All numbers are big endian and all offsets are relative to the start of the file.
+const (
+	ipfireDBMagic        = "LOCDBXX"
+	ipfireDBVersion      = 1
+	ipfireDBHeaderLength = 8 + 4192
+
+	ipfireDBNodeLength    = 12 // zero, one, network
+	ipfireDBNetworkLength = 12 // country code, padding, asn, flags, padding
+	ipfireDBASLength      = 8  // number, name
+
+	// ipfireDBNoNetwork marks nodes of the network tree without a network.
+	ipfireDBNoNetwork = 0xffffffff
+)
+
+// Network flags of the binary IPFire location database.
+const (
+	ipfireDBFlagAnonymousProxy    = 1 << 0
+	ipfireDBFlagSatelliteProvider = 1 << 1
+	ipfireDBFlagAnycast           = 1 << 2
+	ipfireDBFlagDrop              = 1 << 3
+)
+
+// IPFireDBSource reads geoip data from the binary IPFire location database,
+// as distributed in "location.db". Signatures are not verified.
+type IPFireDBSource struct {
+	file     string
+	tree     []byte
+	networks []byte
+	asNames  map[uint32]string
+	fieldMap map[string]string
+	types    map[string]string
+
+	// Nodes of the network tree that are still to be visited.
+	stack []ipfireDBNode
+
+	valueProcessing
+	errorHandling
+	networkFilter
+	err error
+}
+
+// ipfireDBNode is a node of the network tree, including its position.
+type ipfireDBNode struct {
+	index   uint32
+	depth   int
+	address [16]byte
+}
+
+// LoadIPFireDBSource returns a new IPFireDBSource.
+// The database is loaded into memory.
+func LoadIPFireDBSource(input DatabaseInput, types map[string]string) (*IPFireDBSource, error) {
+	filter, err := newNetworkFilter(input)
+	if err != nil {
+		return nil, err
+	}
+	file, err := openInput(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close() //nolint:errcheck
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	// Check header.
+	if len(data) < ipfireDBHeaderLength || string(data[:len(ipfireDBMagic)]) != ipfireDBMagic {
+		return nil, errors.New("not an ipfire location database")
+	}
+	if version := data[len(ipfireDBMagic)]; version != ipfireDBVersion {
+		return nil, fmt.Errorf("unsupported ipfire location database version %d", version)
+	}
+	section := func(offsetPos int) ([]byte, error) {
+		offset := binary.BigEndian.Uint32(data[offsetPos:])
+		length := binary.BigEndian.Uint32(data[offsetPos+4:])
+		if uint64(offset)+uint64(length) > uint64(len(data)) {
+			return nil, fmt.Errorf("section at %d with length %d exceeds file", offset, length)
+		}
+		return data[offset : offset+length], nil
+	}
+	asData, err := section(28)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AS section: %w", err)
+	}
+	networks, err := section(36)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network section: %w", err)
+	}
+	tree, err := section(44)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network tree section: %w", err)
+	}
+	pool, err := section(60)
+	if err != nil {
+		return nil, fmt.Errorf("invalid string pool section: %w", err)
+	}
+
+	// Read AS names, if needed.
+	var asNames map[uint32]string
+	if _, ok := input.FieldMap["name"]; ok {
+		asNames = make(map[uint32]string, len(asData)/ipfireDBASLength)
+		for i := 0; i+ipfireDBASLength <= len(asData); i += ipfireDBASLength {
+			nameOffset := binary.BigEndian.Uint32(asData[i+4:])
+			if int(nameOffset) >= len(pool) {
+				return nil, fmt.Errorf("invalid name of AS #%d", i/ipfireDBASLength)
+			}
+			name, _, _ := bytes.Cut(pool[nameOffset:], []byte{0})
+			asNames[binary.BigEndian.Uint32(asData[i:])] = string(name)
+		}
+	}
+
+	ipf := &IPFireDBSource{
+		file:            inputName(input),
+		tree:            tree,
+		networks:        networks,
+		asNames:         asNames,
+		fieldMap:        input.FieldMap,
+		types:           types,
+		valueProcessing: newValueProcessing(input, types),
+		errorHandling:   newErrorHandling(input),
+		networkFilter:   filter,
+	}
+	if len(tree) >= ipfireDBNodeLength {
+		ipf.stack = []ipfireDBNode{{}}
+	}
+	return ipf, nil
+}
+
+// Name returns an identifying name for the source.
+func (ipf *IPFireDBSource) Name() string {
+	return ipf.file
+}
+
+// NextEntry returns the next entry of the source.
+// If nil, nil is returned, stop reading and check Err().
+func (ipf *IPFireDBSource) NextEntry() (*SourceEntry, error) {
+	return ipf.NextEntryContext(context.Background())
+}
+
+// NextEntryContext returns the next entry of the source.
+// If nil, nil is returned, stop reading and check Err().
+// If the context is canceled, reading stops and Err() returns the context error.
+func (ipf *IPFireDBSource) NextEntryContext(ctx context.Context) (*SourceEntry, error) {
+	for {
+		se, err := ipf.nextEntry(ctx)
+		if se != nil {
+			if err = ipf.processValues(se); err != nil {
+				err = fmt.Errorf("%s: %w", entryPosition(ipf, se), err)
+				se = nil
+			}
+		}
+		if err != nil {
+			if ipf.skip(err) {
+				continue
+			}
+			if ipf.fail() {
+				ipf.err = err
+				return nil, nil //nolint:nilerr
+			}
+		}
+		return se, err
+	}
+}
+
+func (ipf *IPFireDBSource) nextEntry(ctx context.Context) (*SourceEntry, error) {
+	// Check if there is an error, do not read if there is an error.
+	if ipf.err != nil {
+		return nil, nil //nolint:nilerr
+	}
+
+	// Walk the network tree until the next node with a network.
+	// Networks are returned before their subnets.
+	for {
+		// Check if the context was canceled.
+		if err := ctx.Err(); err != nil {
+			ipf.err = err
+			return nil, nil //nolint:nilerr
+		}
+
+		// Check if the tree is done.
+		if len(ipf.stack) == 0 {
+			ipf.err = io.EOF
+			return nil, nil //nolint:nilerr
+		}
+		node := ipf.stack[len(ipf.stack)-1]
+		ipf.stack = ipf.stack[:len(ipf.stack)-1]
+
+		// Read node.
+		nodeOffset := int(node.index) * ipfireDBNodeLength
+		if nodeOffset+ipfireDBNodeLength > len(ipf.tree) {
+			ipf.err = fmt.Errorf("invalid network tree node #%d", node.index)
+			return nil, nil //nolint:nilerr
+		}
+		zero := binary.BigEndian.Uint32(ipf.tree[nodeOffset:])
+		one := binary.BigEndian.Uint32(ipf.tree[nodeOffset+4:])
+		network := binary.BigEndian.Uint32(ipf.tree[nodeOffset+8:])
+
+		// Queue children, the zero branch first.
+		if node.depth < 128 {
+			if one != 0 {
+				child := ipfireDBNode{index: one, depth: node.depth + 1, address: node.address}
+				child.address[node.depth/8] |= 0x80 >> (node.depth % 8)
+				ipf.stack = append(ipf.stack, child)
+			}
+			if zero != 0 {
+				ipf.stack = append(ipf.stack, ipfireDBNode{index: zero, depth: node.depth + 1, address: node.address})
+			}
+		}
+
+		if network != ipfireDBNoNetwork {
+			return ipf.sourceEntry(node, network)
+		}
+	}
+}
+
+// sourceEntry returns the source entry of the given network of the node.
+// The keys of the network data are the same as in the text format.
+func (ipf *IPFireDBSource) sourceEntry(node ipfireDBNode, network uint32) (*SourceEntry, error) {
+	se := &SourceEntry{
+		Net: NormalizeNetwork(&net.IPNet{
+			IP:   net.IP(node.address[:]),
+			Mask: net.CIDRMask(node.depth, 128),
+		}),
+		Values: make(map[string]SourceValue),
+	}
+
+	networkOffset := int(network) * ipfireDBNetworkLength
+	if networkOffset+ipfireDBNetworkLength > len(ipf.networks) {
+		return nil, fmt.Errorf("%s: invalid network data #%d of %s", ipf.Name(), network, se.Net)
+	}
+	data := ipf.networks[networkOffset : networkOffset+ipfireDBNetworkLength]
+	country := string(data[0:2])
+	asn := binary.BigEndian.Uint32(data[4:])
+	flags := binary.BigEndian.Uint16(data[8:])
+
+	if country[0] != 0 && country[1] != 0 {
+		ipf.addValue(se, "country", country)
+	}
+	if asn != 0 {
+		ipf.addValue(se, "aut-num", strconv.FormatUint(uint64(asn), 10))
+		if name := ipf.asNames[asn]; name != "" {
+			ipf.addValue(se, "name", name)
+		}
+	}
+	if flags&ipfireDBFlagAnycast != 0 {
+		ipf.addValue(se, "is-anycast", "true")
+	}
+	if flags&ipfireDBFlagSatelliteProvider != 0 {
+		ipf.addValue(se, "is-satellite-provider", "true")
+	}
+	if flags&ipfireDBFlagAnonymousProxy != 0 {
+		ipf.addValue(se, "is-anonymous-proxy", "true")
+	}
+	if flags&ipfireDBFlagDrop != 0 {
+		ipf.addValue(se, "drop", "true")
+	}
+
+	return se, nil
+}
+
+// addValue adds the value of the given IPFire key, if it is mapped to a
+// field with a type.
+func (ipf *IPFireDBSource) addValue(se *SourceEntry, key, value string) {
+	fieldName, ok := ipf.fieldMap[key]
+	if !ok {
+		return
+	}
+	if fieldType, ok := fieldTypeFor(ipf.types, fieldName); ok {
+		se.Values[fieldName] = SourceValue{
+			Type:  fieldType,
+			Value: value,
+		}
+	}
+}
+
+// FieldNames returns the names of the fields mapped in the fieldMap.
+func (ipf *IPFireDBSource) FieldNames() []string {
+	return mappedFieldNames(ipf.fieldMap)
+}
+
+// Close releases the source.
+// The database is read into memory when the source is loaded, so only the
+// memory is released.
+func (ipf *IPFireDBSource) Close() error {
+	// Stop reading, as the tree is released.
+	if ipf.err == nil {
+		ipf.err = errSourceClosed
+	}
+	ipf.tree, ipf.networks, ipf.asNames, ipf.stack = nil, nil, nil, nil
+	return nil
+}
+
+// Err returns the processing error encountered by the source.
+func (ipf *IPFireDBSource) Err() error {
+	switch {
+	case ipf.err == nil:
+		return nil
+	case errors.Is(ipf.err, io.EOF):
+		return nil
+	default:
+		return ipf.err
+	}
+}
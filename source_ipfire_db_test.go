@@ -0,0 +1,173 @@
+package mmdbmeld
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testIPFireDBNetwork struct {
+	network string
+	country string
+	asn     uint32
+	flags   uint16
+}
+
+// writeTestIPFireDB writes a binary IPFire location database with the given
+// networks and AS names.
+func writeTestIPFireDB(t *testing.T, file string, networks []testIPFireDBNetwork, asNames map[uint32]string) {
+	t.Helper()
+
+	// Build network tree and data.
+	type node struct{ zero, one, network uint32 }
+	nodes := []node{{network: ipfireDBNoNetwork}}
+	var networkData []byte
+	for i, n := range networks {
+		_, ipNet, err := net.ParseCIDR(n.network)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ip := ipNet.IP.To16()
+		ones, bits := ipNet.Mask.Size()
+		if bits == 32 {
+			ones += 96
+		}
+		current := 0
+		for depth := 0; depth < ones; depth++ {
+			bit := ip[depth/8]&(0x80>>(depth%8)) != 0
+			next := nodes[current].zero
+			if bit {
+				next = nodes[current].one
+			}
+			if next == 0 {
+				nodes = append(nodes, node{network: ipfireDBNoNetwork})
+				next = uint32(len(nodes) - 1)
+				if bit {
+					nodes[current].one = next
+				} else {
+					nodes[current].zero = next
+				}
+			}
+			current = int(next)
+		}
+		nodes[current].network = uint32(i)
+
+		data := make([]byte, ipfireDBNetworkLength)
+		copy(data, n.country)
+		binary.BigEndian.PutUint32(data[4:], n.asn)
+		binary.BigEndian.PutUint16(data[8:], n.flags)
+		networkData = append(networkData, data...)
+	}
+	var tree []byte
+	for _, n := range nodes {
+		tree = binary.BigEndian.AppendUint32(tree, n.zero)
+		tree = binary.BigEndian.AppendUint32(tree, n.one)
+		tree = binary.BigEndian.AppendUint32(tree, n.network)
+	}
+
+	// Build AS section and string pool.
+	var asData, pool []byte
+	for asn, name := range asNames {
+		asData = binary.BigEndian.AppendUint32(asData, asn)
+		asData = binary.BigEndian.AppendUint32(asData, uint32(len(pool)))
+		pool = append(append(pool, name...), 0)
+	}
+
+	// Write header and sections.
+	data := make([]byte, ipfireDBHeaderLength)
+	copy(data, ipfireDBMagic)
+	data[len(ipfireDBMagic)] = ipfireDBVersion
+	for _, section := range []struct {
+		offsetPos int
+		data      []byte
+	}{
+		{28, asData},
+		{36, networkData},
+		{44, tree},
+		{60, pool},
+	} {
+		binary.BigEndian.PutUint32(data[section.offsetPos:], uint32(len(data)))
+		binary.BigEndian.PutUint32(data[section.offsetPos+4:], uint32(len(section.data)))
+		data = append(data, section.data...)
+	}
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIPFireDBSource(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "location.db")
+	writeTestIPFireDB(t, file, []testIPFireDBNetwork{
+		{network: "2001:db8::/32", country: "DE"},
+		{network: "192.0.2.0/24", country: "AT", asn: 64496, flags: ipfireDBFlagAnycast},
+		{network: "192.0.2.128/25", country: "CH", flags: ipfireDBFlagDrop},
+	}, map[uint32]string{64496: "Example Org"})
+
+	sources, err := LoadSources(DatabaseConfig{
+		Inputs: []DatabaseInput{{
+			File:   file,
+			Format: "ipfire",
+			FieldMap: map[string]string{
+				"aut-num":    "autonomous_system_number",
+				"name":       "autonomous_system_organization",
+				"country":    "country.iso_code",
+				"is-anycast": "is_anycast",
+			},
+		}},
+		Types: map[string]string{
+			"autonomous_system_number":       "uint32",
+			"autonomous_system_organization": "string",
+			"country.iso_code":               "string",
+			"is_anycast":                     "bool",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := sources[0]
+	if _, ok := source.(*IPFireDBSource); !ok {
+		t.Fatalf("unexpected source type %T", source)
+	}
+
+	// Networks are returned in address order, before their subnets.
+	var entries []string
+	for {
+		entry, err := source.NextEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil {
+			break
+		}
+		m, err := entry.ToMMDBMap(Optimizations{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, fmt.Sprintf("%s %v", entry.Net, m))
+	}
+	if source.Err() != nil {
+		t.Fatal(source.Err())
+	}
+	expected := "[" +
+		"192.0.2.0/24 map[autonomous_system_number:64496 autonomous_system_organization:Example Org country:map[iso_code:AT] is_anycast:true] " +
+		"192.0.2.128/25 map[country:map[iso_code:CH]] " +
+		"2001:db8::/32 map[country:map[iso_code:DE]]" +
+		"]"
+	if fmt.Sprintf("%v", entries) != expected {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+
+	// Other data is rejected.
+	invalid := filepath.Join(t.TempDir(), "invalid.db")
+	if err := os.WriteFile(invalid, []byte("SQLite format 3"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadIPFireDBSource(DatabaseInput{File: invalid}, nil); err == nil {
+		t.Fatal("expected error for invalid database")
+	}
+}
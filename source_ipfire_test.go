@@ -0,0 +1,79 @@
+package mmdbmeld
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIPFireSource(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "test.ipfire.txt")
+	err := os.WriteFile(file, []byte(`# Location Database Export
+#
+
+aut-num:             AS12345
+name:                Example Org
+
+net:                 192.0.2.0/24
+aut-num:             AS12345
+country:             AT
+is-anycast:          yes
+
+net:                 invalid
+country:             DE
+
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := LoadIPFireSource(DatabaseInput{
+		File: file,
+		FieldMap: map[string]string{
+			"aut-num":    "autonomous_system_number",
+			"name":       "autonomous_system_organization",
+			"country":    "country.iso_code",
+			"is-anycast": "is_anycast",
+		},
+	}, map[string]string{
+		"autonomous_system_number":       "uint32",
+		"autonomous_system_organization": "string",
+		"country.iso_code":               "string",
+		"is_anycast":                     "bool",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Check entry with filled in AS organization.
+	entry, err := source.NextEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Net.String() != "192.0.2.0/24" || entry.Line != 7 {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+	if entry.Values["autonomous_system_organization"].Value != "Example Org" ||
+		entry.Values["autonomous_system_number"].Value != "12345" ||
+		entry.Values["is_anycast"].Value != "true" {
+		t.Fatalf("unexpected values: %+v", entry.Values)
+	}
+
+	// Check error with line number.
+	_, err = source.NextEntry()
+	if err == nil || !strings.HasPrefix(err.Error(), file+" line 12:") {
+		t.Fatalf("expected error on line 12, got %v", err)
+	}
+
+	// Check end of source.
+	entry, err = source.NextEntry()
+	if err != nil || entry != nil {
+		t.Fatalf("expected end of source, got %+v, %v", entry, err)
+	}
+	if source.Err() != nil {
+		t.Fatal(source.Err())
+	}
+}
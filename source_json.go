@@ -0,0 +1,319 @@
+package mmdbmeld
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// JSONSource reads geoip data from a json array of objects.
+type JSONSource struct {
+	file    string
+	decoder *json.Decoder
+	closer  io.Closer
+	types   map[string]string
+	fields  fieldMapping
+
+	// Names of the fields of the first entry.
+	fieldNames []string
+
+	valueProcessing
+	errorHandling
+	networkFilter
+	err error
+}
+
+// LoadJSONSource returns a new JSONSource.
+func LoadJSONSource(input DatabaseInput, types map[string]string) (*JSONSource, error) {
+	filter, err := newNetworkFilter(input)
+	if err != nil {
+		return nil, err
+	}
+	file, err := openInput(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	decoder.UseNumber()
+
+	// Read start of array.
+	token, err := decoder.Token()
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to read start of json array: %w", err)
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		_ = file.Close()
+		return nil, fmt.Errorf("expected start of json array, got %v", token)
+	}
+
+	return &JSONSource{
+		file:            inputName(input),
+		decoder:         decoder,
+		closer:          file,
+		types:           types,
+		fields:          newFieldMapping(input),
+		valueProcessing: newValueProcessing(input, types),
+		errorHandling:   newErrorHandling(input),
+		networkFilter:   filter,
+	}, nil
+}
+
+// Name returns an identifying name for the source.
+func (js *JSONSource) Name() string {
+	return js.file
+}
+
+// NextEntry returns the next entry of the source.
+// If nil, nil is returned, stop reading and check Err().
+func (js *JSONSource) NextEntry() (*SourceEntry, error) {
+	return js.NextEntryContext(context.Background())
+}
+
+// NextEntryContext returns the next entry of the source.
+// If nil, nil is returned, stop reading and check Err().
+// If the context is canceled, reading stops and Err() returns the context error.
+func (js *JSONSource) NextEntryContext(ctx context.Context) (*SourceEntry, error) {
+	for {
+		se, err := js.nextEntry(ctx)
+		if se != nil {
+			if err = js.processValues(se); err != nil {
+				err = fmt.Errorf("%s: %w", entryPosition(js, se), err)
+				se = nil
+			}
+		}
+		if err != nil {
+			if js.skip(err) {
+				continue
+			}
+			if js.fail() {
+				js.err = err
+				_ = js.Close()
+				return nil, nil //nolint:nilerr
+			}
+		}
+		return se, err
+	}
+}
+
+func (js *JSONSource) nextEntry(ctx context.Context) (*SourceEntry, error) {
+	// Check if there is an error, do not read if there is an error.
+	if js.err != nil {
+		return nil, nil //nolint:nilerr
+	}
+
+	// Check if the context was canceled.
+	if err := ctx.Err(); err != nil {
+		js.err = err
+		_ = js.Close()
+		return nil, nil //nolint:nilerr
+	}
+
+	// Check if the array has ended.
+	if !js.decoder.More() {
+		if _, err := js.decoder.Token(); err != nil {
+			js.err = fmt.Errorf("failed to read end of json array: %w", err)
+		} else {
+			js.err = io.EOF
+		}
+		_ = js.Close()
+		return nil, nil //nolint:nilerr
+	}
+
+	// Read and parse object.
+	var obj map[string]any
+	if err := js.decoder.Decode(&obj); err != nil {
+		js.err = err
+		_ = js.Close()
+		return nil, nil //nolint:nilerr
+	}
+
+	if js.fieldNames == nil {
+		js.fieldNames = js.fields.fieldNames(obj, "", js.types)
+	}
+	return sourceEntryFromJSON(obj, js.types, js.fields, js.arraySeparator)
+}
+
+// FieldNames returns the names of the fields of the first entry.
+// It is empty until the first entry was read.
+func (js *JSONSource) FieldNames() []string {
+	return js.fieldNames
+}
+
+// Close closes the underlying file of the source and stops reading.
+// Closing an already closed source does nothing.
+func (js *JSONSource) Close() error {
+	if js.closer == nil {
+		return nil
+	}
+	if js.err == nil {
+		js.err = errSourceClosed
+	}
+	err := js.closer.Close()
+	js.closer = nil
+	return err
+}
+
+// Err returns the processing error encountered by the source.
+func (js *JSONSource) Err() error {
+	switch {
+	case js.err == nil:
+		return nil
+	case errors.Is(js.err, io.EOF):
+		return nil
+	default:
+		return js.err
+	}
+}
+
+// sourceEntryFromJSON parses a decoded json object into a source entry.
+// The special keys "network", "start" and "end" define the IP range,
+// all other keys are mapped to values, if a type is defined for them.
+// Keys are renamed by the field mapping first. Arrays are joined with the
+// given separator, if their type defines none.
+func sourceEntryFromJSON(obj map[string]any, types map[string]string, fields fieldMapping, arraySeparator string) (*SourceEntry, error) {
+	se := &SourceEntry{
+		Values: make(map[string]SourceValue),
+	}
+
+	for key, value := range obj {
+		switch fields.targetKey(key) {
+		case "network":
+			netData, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("network must be a string, got %T", value)
+			}
+			_, ipNet, err := net.ParseCIDR(netData)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse net %s: %w", netData, err)
+			}
+			se.Net = ipNet
+		case "start":
+			ip, err := parseJSONIP(value)
+			if err != nil {
+				return nil, err
+			}
+			se.From = ip
+		case "end":
+			ip, err := parseJSONIP(value)
+			if err != nil {
+				return nil, err
+			}
+			se.To = ip
+		default:
+			if err := addJSONValue(se, key, value, types, fields, arraySeparator); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Check if the entry has an IP range.
+	if se.Net == nil && (se.From == nil || se.To == nil) {
+		return nil, errors.New("entry is missing network or start and end")
+	}
+
+	return se, nil
+}
+
+func parseJSONIP(value any) (net.IP, error) {
+	ipData, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("IP must be a string, got %T", value)
+	}
+	ip := net.ParseIP(ipData)
+	if ip == nil {
+		return nil, fmt.Errorf("failed to parse IP %q", ipData)
+	}
+	// Force IPv4 representation for IPv4 for better further processing.
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	return ip, nil
+}
+
+// addJSONValue adds the given json value to the source entry.
+// Nested objects are flattened into dotted keys, which are then renamed by
+// the field mapping.
+func addJSONValue(se *SourceEntry, key string, value any, types map[string]string, fields fieldMapping, arraySeparator string) error {
+	sourceKey := key
+	key = fields.targetKey(sourceKey)
+
+	// Keep json values as they are.
+	if fieldType, ok := fieldTypeFor(types, key); ok && fieldType == "json" {
+		if value == nil {
+			return nil
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", key, err)
+		}
+		return fields.setValue(se, sourceKey, key, SourceValue{
+			Type:  fieldType,
+			Value: string(data),
+		})
+	}
+
+	// Flatten nested objects.
+	if subObj, ok := value.(map[string]any); ok {
+		for subKey, subValue := range subObj {
+			if err := addJSONValue(se, sourceKey+"."+subKey, subValue, types, fields, arraySeparator); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Ignore values without type.
+	fieldType, ok := fieldTypeFor(types, key)
+	if !ok {
+		return nil
+	}
+
+	// Ignore null values.
+	if value == nil {
+		return nil
+	}
+
+	// Convert value to its string representation.
+	var fieldValue string
+	if array, ok := value.([]any); ok {
+		fields := make([]string, 0, len(array))
+		for i, arrayValue := range array {
+			field, err := jsonScalarToString(arrayValue)
+			if err != nil {
+				return fmt.Errorf("failed to read %s array entry #%d: %w", key, i, err)
+			}
+			fields = append(fields, field)
+		}
+		fieldValue = joinArray(fieldType, arraySeparator, fields)
+	} else {
+		var err error
+		fieldValue, err = jsonScalarToString(value)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", key, err)
+		}
+	}
+
+	return fields.setValue(se, sourceKey, key, SourceValue{
+		Type:  fieldType,
+		Value: fieldValue,
+	})
+}
+
+func jsonScalarToString(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		return "", fmt.Errorf("unsupported json value type %T", value)
+	}
+}
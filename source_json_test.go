@@ -0,0 +1,167 @@
+package mmdbmeld
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJSONSource(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "test.json")
+	err := os.WriteFile(file, []byte(`[
+  {"network": "192.0.2.0/24", "country": {"iso_code": "AT"}, "autonomous_system_number": 12345, "ignored": "x"},
+  {"start": "198.51.100.0", "end": "198.51.100.127", "country": {"iso_code": "DE"}}
+]`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := LoadJSONSource(DatabaseInput{File: file}, map[string]string{
+		"country.iso_code":         "string",
+		"autonomous_system_number": "uint32",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []*SourceEntry
+	for {
+		entry, err := source.NextEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil {
+			break
+		}
+		entries = append(entries, entry)
+	}
+	if source.Err() != nil {
+		t.Fatal(source.Err())
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].Net.String() != "192.0.2.0/24" {
+		t.Fatalf("unexpected network: %s", entries[0].Net)
+	}
+	if len(entries[0].Values) != 2 || entries[0].Values["autonomous_system_number"].Value != "12345" {
+		t.Fatalf("unexpected values: %+v", entries[0].Values)
+	}
+	if entries[1].From.String() != "198.51.100.0" || entries[1].To.String() != "198.51.100.127" {
+		t.Fatalf("unexpected range: %s - %s", entries[1].From, entries[1].To)
+	}
+	if entries[1].Values["country.iso_code"].Value != "DE" {
+		t.Fatalf("unexpected values: %+v", entries[1].Values)
+	}
+}
+
+func TestJSONLinesSource(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "test.jsonl")
+	err := os.WriteFile(file, []byte(`# comment
+{"network": "192.0.2.0/24", "country": {"iso_code": "AT"}}
+
+{"network": "invalid", "country": {"iso_code": "DE"}}
+{"start": "2001:db8::", "end": "2001:db8::ff", "country": {"iso_code": "CH"}}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := LoadJSONLinesSource(DatabaseInput{File: file}, map[string]string{
+		"country.iso_code": "string",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		entries []*SourceEntry
+		errs    []error
+	)
+	for {
+		entry, err := source.NextEntry()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if entry == nil {
+			break
+		}
+		entries = append(entries, entry)
+	}
+	if source.Err() != nil {
+		t.Fatal(source.Err())
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	if len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), file+" line 4:") {
+		t.Fatalf("expected error on line 4, got %v", errs)
+	}
+	if entries[1].Values["country.iso_code"].Value != "CH" {
+		t.Fatalf("unexpected values: %+v", entries[1].Values)
+	}
+}
+
+func TestJSONLinesSourceErrorHandling(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "test.jsonl")
+	err := os.WriteFile(file, []byte(`{"network": "invalid"}
+{"network": "192.0.2.0/24"}
+{"start": "198.51.100.0"}
+{"network": "198.51.100.0/24"}
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Skip invalid entries.
+	var reported int
+	source, err := LoadJSONLinesSource(DatabaseInput{
+		File:    file,
+		OnError: OnErrorSkip,
+		ErrorCallback: func(error) {
+			reported++
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries int
+	for {
+		entry, err := source.NextEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil {
+			break
+		}
+		entries++
+	}
+	if entries != 2 || source.Skipped() != 2 || reported != 2 {
+		t.Fatalf("unexpected counts: entries=%d skipped=%d reported=%d", entries, source.Skipped(), reported)
+	}
+
+	// Fail at first invalid entry.
+	source, err = LoadJSONLinesSource(DatabaseInput{
+		File:    file,
+		OnError: OnErrorFail,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := source.NextEntry()
+	if entry != nil || err != nil {
+		t.Fatalf("expected end of source, got %+v, %v", entry, err)
+	}
+	if source.Err() == nil || !strings.HasPrefix(source.Err().Error(), file+" line 1:") {
+		t.Fatalf("expected error on line 1, got %v", source.Err())
+	}
+}
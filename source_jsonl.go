@@ -0,0 +1,172 @@
+package mmdbmeld
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// JSONLinesSource reads geoip data from newline-delimited json objects.
+type JSONLinesSource struct {
+	file   string
+	reader *bufio.Reader
+	closer io.Closer
+	types  map[string]string
+	fields fieldMapping
+	line   int
+
+	// Names of the fields of the first entry.
+	fieldNames []string
+
+	lineEstimate
+	valueProcessing
+	errorHandling
+	networkFilter
+	err error
+}
+
+// LoadJSONLinesSource returns a new JSONLinesSource.
+func LoadJSONLinesSource(input DatabaseInput, types map[string]string) (*JSONLinesSource, error) {
+	filter, err := newNetworkFilter(input)
+	if err != nil {
+		return nil, err
+	}
+	file, err := openInput(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	return &JSONLinesSource{
+		file:            inputName(input),
+		reader:          bufio.NewReader(file),
+		closer:          file,
+		types:           types,
+		fields:          newFieldMapping(input),
+		lineEstimate:    newLineEstimate(input, 0),
+		valueProcessing: newValueProcessing(input, types),
+		errorHandling:   newErrorHandling(input),
+		networkFilter:   filter,
+	}, nil
+}
+
+// Name returns an identifying name for the source.
+func (jls *JSONLinesSource) Name() string {
+	return jls.file
+}
+
+// NextEntry returns the next entry of the source.
+// If nil, nil is returned, stop reading and check Err().
+func (jls *JSONLinesSource) NextEntry() (*SourceEntry, error) {
+	return jls.NextEntryContext(context.Background())
+}
+
+// NextEntryContext returns the next entry of the source.
+// If nil, nil is returned, stop reading and check Err().
+// If the context is canceled, reading stops and Err() returns the context error.
+func (jls *JSONLinesSource) NextEntryContext(ctx context.Context) (*SourceEntry, error) {
+	for {
+		se, err := jls.nextEntry(ctx)
+		if se != nil {
+			if err = jls.processValues(se); err != nil {
+				err = fmt.Errorf("%s: %w", entryPosition(jls, se), err)
+				se = nil
+			}
+		}
+		if err != nil {
+			if jls.skip(err) {
+				continue
+			}
+			if jls.fail() {
+				jls.err = err
+				_ = jls.Close()
+				return nil, nil //nolint:nilerr
+			}
+		}
+		return se, err
+	}
+}
+
+func (jls *JSONLinesSource) nextEntry(ctx context.Context) (*SourceEntry, error) {
+	// Check if there is an error, do not read if there is an error.
+	if jls.err != nil {
+		return nil, nil //nolint:nilerr
+	}
+
+	// Check if the context was canceled.
+	if err := ctx.Err(); err != nil {
+		jls.err = err
+		_ = jls.Close()
+		return nil, nil //nolint:nilerr
+	}
+
+	for {
+		// Read next line.
+		line, err := jls.reader.ReadBytes('\n')
+		if err != nil && (!errors.Is(err, io.EOF) || len(line) == 0) {
+			jls.err = err
+			_ = jls.Close()
+			return nil, nil //nolint:nilerr
+		}
+		jls.line++
+
+		// Skip blank and comment lines.
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		// Parse line.
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.UseNumber()
+		var obj map[string]any
+		if err := decoder.Decode(&obj); err != nil {
+			return nil, errorAtLine(jls.Name(), jls.line, err)
+		}
+		if jls.fieldNames == nil {
+			jls.fieldNames = jls.fields.fieldNames(obj, "", jls.types)
+		}
+		se, err := sourceEntryFromJSON(obj, jls.types, jls.fields, jls.arraySeparator)
+		if err != nil {
+			return nil, errorAtLine(jls.Name(), jls.line, err)
+		}
+		se.Line = jls.line
+
+		return se, nil
+	}
+}
+
+// FieldNames returns the names of the fields of the first entry.
+// It is empty until the first entry was read.
+func (jls *JSONLinesSource) FieldNames() []string {
+	return jls.fieldNames
+}
+
+// Close closes the underlying file of the source and stops reading.
+// Closing an already closed source does nothing.
+func (jls *JSONLinesSource) Close() error {
+	if jls.closer == nil {
+		return nil
+	}
+	if jls.err == nil {
+		jls.err = errSourceClosed
+	}
+	err := jls.closer.Close()
+	jls.closer = nil
+	return err
+}
+
+// Err returns the processing error encountered by the source.
+func (jls *JSONLinesSource) Err() error {
+	switch {
+	case jls.err == nil:
+		return nil
+	case errors.Is(jls.err, io.EOF):
+		return nil
+	default:
+		return jls.err
+	}
+}
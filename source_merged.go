@@ -0,0 +1,312 @@
+package mmdbmeld
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"strings"
+)
+
+// mergedSource merges the entries of multiple sources, as they would be
+// merged when building a database from them.
+type mergedSource struct {
+	name     string
+	sources  []Source
+	strategy string
+
+	// Index of the source that is currently read.
+	current int
+	v4, v6  mergeNode
+	// Merged entries, available after all sources were read.
+	entries []*SourceEntry
+	read    bool
+
+	err error
+}
+
+// MergedSource returns a source that merges the entries of the given
+// sources with the given merge strategy, as they would be merged when
+// building a database from them: sources are read in order, so that values
+// of later sources replace values of earlier sources, except with the
+// "first-wins" strategy. An empty strategy selects the default strategy.
+//
+// The merged source returns the resulting networks with their merged values,
+// which never overlap. Networks excluded by the network filter of their input
+// are skipped. Overlapping networks are split into the parts that
+// differ. Merging is done on the raw values by their keys, so array merge
+// policies and conditional resets are not applied.
+//
+// All entries of all sources are read into memory on the first call to
+// NextEntry, as later sources may overlap the networks of any previous one.
+// Memory usage therefore grows with the total amount of networks, similar to
+// building a database. Errors of entries are returned while reading, the
+// merged entries follow once all sources were read.
+func MergedSource(sources []Source, strategy string) Source {
+	names := make([]string, 0, len(sources))
+	for _, source := range sources {
+		names = append(names, source.Name())
+	}
+	ms := &mergedSource{
+		name:     "merged " + strings.Join(names, "+"),
+		sources:  sources,
+		strategy: strategy,
+	}
+	if err := (MergeConfig{Strategy: strategy}).Validate(); err != nil {
+		ms.err = err
+		closeSources(sources)
+	}
+	return ms
+}
+
+// Name returns an identifying name for the source.
+func (ms *mergedSource) Name() string {
+	return ms.name
+}
+
+// NextEntry returns the next entry of the source.
+// If nil, nil is returned, stop reading and check Err().
+func (ms *mergedSource) NextEntry() (*SourceEntry, error) {
+	return ms.NextEntryContext(context.Background())
+}
+
+// NextEntryContext returns the next entry of the source.
+// If nil, nil is returned, stop reading and check Err().
+// If the context is canceled, reading stops and Err() returns the context error.
+func (ms *mergedSource) NextEntryContext(ctx context.Context) (*SourceEntry, error) {
+	// Check if there is an error, do not read if there is an error.
+	if ms.err != nil {
+		return nil, nil //nolint:nilerr
+	}
+
+	// Read all sources into the merge tries first.
+	for !ms.read {
+		if ms.current >= len(ms.sources) {
+			ms.v4.compact()
+			ms.v6.compact()
+			ms.entries = append(ms.v4.entries(nil, make(net.IP, net.IPv4len), 0), ms.v6.entries(nil, make(net.IP, net.IPv6len), 0)...)
+			ms.v4, ms.v6 = mergeNode{}, mergeNode{}
+			ms.read = true
+			break
+		}
+		source := ms.sources[ms.current]
+		entry, err := source.NextEntryContext(ctx)
+		switch {
+		case err != nil:
+			return nil, err
+		case entry == nil:
+			if err := source.Err(); err != nil {
+				ms.err = fmt.Errorf("%s: %w", source.Name(), err)
+				_ = ms.Close()
+				return nil, nil //nolint:nilerr
+			}
+			ms.current++
+		default:
+			if err := ms.insert(source, entry); err != nil {
+				return nil, fmt.Errorf("%s: %w", source.Name(), err)
+			}
+		}
+	}
+
+	// Check if the context was canceled.
+	if err := ctx.Err(); err != nil {
+		ms.err = err
+		_ = ms.Close()
+		return nil, nil //nolint:nilerr
+	}
+
+	// Return merged entries.
+	if len(ms.entries) == 0 {
+		_ = ms.Close()
+		return nil, nil
+	}
+	entry := ms.entries[0]
+	ms.entries[0] = nil
+	ms.entries = ms.entries[1:]
+	return entry, nil
+}
+
+// insert merges the values of the entry into all of its networks, which
+// pass the network filter of the source.
+func (ms *mergedSource) insert(source Source, entry *SourceEntry) error {
+	networks, err := entry.Networks()
+	if err != nil {
+		return err
+	}
+	filter, _ := source.(interface{ IncludesNetwork(*net.IPNet) bool })
+	for _, network := range networks {
+		network = NormalizeNetwork(network)
+		if filter != nil && !filter.IncludesNetwork(network) {
+			continue
+		}
+		prefixBits, _ := network.Mask.Size()
+		node, ip := &ms.v6, network.IP.To16()
+		if v4 := network.IP.To4(); v4 != nil && len(network.Mask) == net.IPv4len {
+			node, ip = &ms.v4, v4
+		}
+
+		// Walk down the trie to the network, splitting larger networks on the way.
+		for i := 0; i < prefixBits; i++ {
+			if node.values != nil {
+				node.children = [2]*mergeNode{{values: node.values}, {values: node.values}}
+				node.values = nil
+			}
+			bit := (ip[i/8] >> (7 - i%8)) & 1
+			if node.children[bit] == nil {
+				node.children[bit] = &mergeNode{}
+			}
+			node = node.children[bit]
+		}
+		node.merge(entry.Values, ms.strategy)
+	}
+	return nil
+}
+
+// Close closes all sources and stops reading.
+// Closing an already closed source does nothing.
+func (ms *mergedSource) Close() error {
+	if ms.err == nil {
+		ms.err = errSourceClosed
+	}
+	var errs []error
+	for _, source := range ms.sources {
+		if err := source.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	ms.v4, ms.v6, ms.entries = mergeNode{}, mergeNode{}, nil
+	return errors.Join(errs...)
+}
+
+// Err returns the processing error encountered by the source.
+func (ms *mergedSource) Err() error {
+	if errors.Is(ms.err, errSourceClosed) {
+		return nil
+	}
+	return ms.err
+}
+
+// mergeNode is a node of a binary trie of networks with merged values.
+// Only leaves hold values.
+type mergeNode struct {
+	children [2]*mergeNode
+	values   map[string]SourceValue
+}
+
+// merge merges the values into all networks within the node, including the
+// parts without values.
+func (node *mergeNode) merge(values map[string]SourceValue, strategy string) {
+	if node.children[0] == nil && node.children[1] == nil {
+		node.values = mergeValues(node.values, values, strategy)
+		return
+	}
+	for i, child := range node.children {
+		if child == nil {
+			node.children[i] = &mergeNode{values: values}
+			continue
+		}
+		child.merge(values, strategy)
+	}
+}
+
+// compact joins sibling networks with equal values, which were split by
+// overlapping networks that did not change them.
+func (node *mergeNode) compact() {
+	for _, child := range node.children {
+		if child != nil {
+			child.compact()
+		}
+	}
+	left, right := node.children[0], node.children[1]
+	if left != nil && right != nil && left.values != nil && right.values != nil && maps.Equal(left.values, right.values) {
+		node.values = left.values
+		node.children = [2]*mergeNode{}
+	}
+}
+
+// entries appends the networks with values within the node to the given
+// entries, in order. The ip holds the bits of the node's network up to the
+// given depth.
+func (node *mergeNode) entries(entries []*SourceEntry, ip net.IP, depth int) []*SourceEntry {
+	if node.values != nil {
+		network := &net.IPNet{
+			IP:   append(net.IP(nil), ip...),
+			Mask: net.CIDRMask(depth, len(ip)*8),
+		}
+		return append(entries, &SourceEntry{Net: network, Values: node.values})
+	}
+	for bit, child := range node.children {
+		if child == nil {
+			continue
+		}
+		if bit == 1 {
+			ip[depth/8] |= 1 << (7 - depth%8)
+		}
+		entries = child.entries(entries, ip, depth+1)
+		ip[depth/8] &^= 1 << (7 - depth%8)
+	}
+	return entries
+}
+
+// mergeValues returns the new values merged into the existing ones with the
+// given strategy. Keys are dotted paths, so "a" conflicts with "a.b". The
+// given maps are not modified.
+func mergeValues(existing, values map[string]SourceValue, strategy string) map[string]SourceValue {
+	if len(existing) == 0 || strategy == MergeStrategyOverwrite {
+		return values
+	}
+
+	merged := make(map[string]SourceValue, len(existing)+len(values))
+	for key, value := range existing {
+		merged[key] = value
+	}
+	switch strategy {
+	case MergeStrategyDeep:
+		// New values replace conflicting existing values.
+		for key, value := range values {
+			for existingKey := range merged {
+				if keysConflict(key, existingKey) {
+					delete(merged, existingKey)
+				}
+			}
+			merged[key] = value
+		}
+
+	case MergeStrategyFirstWins:
+		// Only fill in values that do not conflict with existing values.
+	values:
+		for key, value := range values {
+			for existingKey := range existing {
+				if keysConflict(key, existingKey) {
+					continue values
+				}
+			}
+			merged[key] = value
+		}
+
+	default:
+		// New top level keys replace everything within them.
+		for key := range values {
+			topLevel, _, _ := strings.Cut(key, ".")
+			for existingKey := range merged {
+				if existingTopLevel, _, _ := strings.Cut(existingKey, "."); existingTopLevel == topLevel {
+					delete(merged, existingKey)
+				}
+			}
+		}
+		for key, value := range values {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+// keysConflict returns whether the dotted keys are the same or one is a
+// parent of the other.
+func keysConflict(a, b string) bool {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	return a == b || strings.HasPrefix(b, a+".")
+}
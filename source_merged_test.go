@@ -0,0 +1,130 @@
+package mmdbmeld
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// readMergedSource returns all entries of the source as "network=values".
+func readMergedSource(t *testing.T, source Source) string {
+	t.Helper()
+
+	var entries []string
+	for {
+		entry, err := source.NextEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil {
+			break
+		}
+		entries = append(entries, entry.Net.String()+"="+valuesString(entry.Values))
+	}
+	if err := source.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(entries, " ")
+}
+
+// valuesString formats the values in the form "key:value,...", sorted by key.
+func valuesString(values map[string]SourceValue) string {
+	var formatted []string
+	for key, value := range values {
+		formatted = append(formatted, key+":"+value.Value)
+	}
+	slices.Sort(formatted)
+	return strings.Join(formatted, ",")
+}
+
+func TestMergedSource(t *testing.T) {
+	t.Parallel()
+
+	for strategy, expected := range map[string]string{
+		"":                     "192.0.2.0/25=source:a 192.0.2.128/25=source:b 198.51.100.0/24=source:b 2001:db8::/32=source:a",
+		MergeStrategyFirstWins: "192.0.2.0/24=source:a 198.51.100.0/24=source:b 2001:db8::/32=source:a",
+	} {
+		sources := []Source{
+			newTestSource("a", "192.0.2.0/24", "2001:db8::/32"),
+			newTestSource("b", "192.0.2.128/25", "198.51.100.0/24"),
+		}
+		if merged := readMergedSource(t, MergedSource(sources, strategy)); merged != expected {
+			t.Fatalf("unexpected entries with strategy %q: %s", strategy, merged)
+		}
+	}
+}
+
+func TestMergedSourceStrategies(t *testing.T) {
+	t.Parallel()
+
+	newSource := func(name, network string, values map[string]string) *testSource {
+		ts := newTestSource(name, network)
+		ts.entries[0].Values = make(map[string]SourceValue)
+		for key, value := range values {
+			ts.entries[0].Values[key] = SourceValue{Type: "string", Value: value}
+		}
+		return ts
+	}
+
+	for strategy, expected := range map[string]string{
+		MergeStrategyTopLevel:  "192.0.2.0/24=geo.city:Vienna,name.first:b",
+		MergeStrategyDeep:      "192.0.2.0/24=geo.city:Vienna,geo.country:AT,geo.region:9,name.first:b",
+		MergeStrategyOverwrite: "192.0.2.0/24=geo.city:Vienna,name.first:b",
+		MergeStrategyFirstWins: "192.0.2.0/24=geo.city:Vienna,geo.country:AT,geo.region:9,name:a",
+	} {
+		sources := []Source{
+			newSource("a", "192.0.2.0/24", map[string]string{"geo.country": "AT", "geo.region": "9", "name": "a"}),
+			newSource("b", "192.0.2.0/24", map[string]string{"geo.city": "Vienna", "name.first": "b"}),
+		}
+		if merged := readMergedSource(t, MergedSource(sources, strategy)); merged != expected {
+			t.Fatalf("unexpected entries with strategy %q: %s", strategy, merged)
+		}
+	}
+
+	// Ranges are merged as their networks.
+	ranged := newTestSource("r")
+	ranged.entries = append(ranged.entries, &SourceEntry{
+		From:   net.ParseIP("192.0.2.0"),
+		To:     net.ParseIP("192.0.2.255"),
+		Values: map[string]SourceValue{"source": {Type: "string", Value: "r"}},
+	})
+	merged := readMergedSource(t, MergedSource([]Source{newTestSource("a", "192.0.2.0/25"), ranged}, MergeStrategyFirstWins))
+	if merged != "192.0.2.0/25=source:a 192.0.2.128/25=source:r" {
+		t.Fatalf("unexpected entries of range: %s", merged)
+	}
+
+	// Unknown strategies fail.
+	source := MergedSource([]Source{newTestSource("a", "192.0.2.0/24")}, "mix")
+	if entry, _ := source.NextEntry(); entry != nil || source.Err() == nil {
+		t.Fatal("expected error for unknown strategy")
+	}
+}
+
+func TestMergedSourceFilter(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "test.csv")
+	if err := os.WriteFile(file, []byte("192.0.2.0,192.0.2.255,b\n198.51.100.0,198.51.100.255,b\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sources, err := LoadSources(DatabaseConfig{
+		Types: map[string]string{"source": "string"},
+		Inputs: []DatabaseInput{{
+			File:            file,
+			Fields:          []string{"from", "to", "source"},
+			ExcludeNetworks: []string{"192.0.2.0/24"},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Excluded networks do not replace values of other sources.
+	merged := readMergedSource(t, MergedSource(append([]Source{newTestSource("a", "192.0.2.0/24")}, sources...), ""))
+	if merged != "192.0.2.0/24=source:a 198.51.100.0/24=source:b" {
+		t.Fatalf("unexpected entries: %s", merged)
+	}
+}
@@ -0,0 +1,320 @@
+package mmdbmeld
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// MMDBSource reads geoip data from an existing mmdb file.
+type MMDBSource struct {
+	file     string
+	reader   *maxminddb.Reader
+	networks *maxminddb.Networks
+	types    map[string]string
+	fields   fieldMapping
+
+	// Names of the fields of the first entry.
+	fieldNames []string
+
+	valueProcessing
+	errorHandling
+	networkFilter
+	err error
+}
+
+// LoadMMDBSource returns a new MMDBSource.
+func LoadMMDBSource(input DatabaseInput, types map[string]string) (*MMDBSource, error) {
+	filter, err := newNetworkFilter(input)
+	if err != nil {
+		return nil, err
+	}
+	var reader *maxminddb.Reader
+	if isURL(input.File) || isStdin(input) || isArchive(input) || trimCompressionSuffix(input.File) != input.File {
+		// Load remote, piped, archived and compressed databases into memory.
+		var file io.ReadCloser
+		file, err = openInput(input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %w", err)
+		}
+		defer file.Close() //nolint:errcheck
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		reader, err = maxminddb.FromBytes(data)
+	} else {
+		reader, err = maxminddb.Open(input.File)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	return &MMDBSource{
+		file:            inputName(input),
+		reader:          reader,
+		networks:        reader.Networks(maxminddb.SkipAliasedNetworks),
+		types:           types,
+		fields:          newFieldMapping(input),
+		valueProcessing: newValueProcessing(input, types),
+		errorHandling:   newErrorHandling(input),
+		networkFilter:   filter,
+	}, nil
+}
+
+// Name returns an identifying name for the source.
+func (mmdb *MMDBSource) Name() string {
+	return mmdb.file
+}
+
+// NextEntry returns the next entry of the source.
+// If nil, nil is returned, stop reading and check Err().
+func (mmdb *MMDBSource) NextEntry() (*SourceEntry, error) {
+	return mmdb.NextEntryContext(context.Background())
+}
+
+// NextEntryContext returns the next entry of the source.
+// If nil, nil is returned, stop reading and check Err().
+// If the context is canceled, reading stops and Err() returns the context error.
+func (mmdb *MMDBSource) NextEntryContext(ctx context.Context) (*SourceEntry, error) {
+	for {
+		se, err := mmdb.nextEntry(ctx)
+		if se != nil {
+			if err = mmdb.processValues(se); err != nil {
+				err = fmt.Errorf("%s: %w", entryPosition(mmdb, se), err)
+				se = nil
+			}
+		}
+		if err != nil {
+			if mmdb.skip(err) {
+				continue
+			}
+			if mmdb.fail() {
+				mmdb.err = err
+				_ = mmdb.Close()
+				return nil, nil //nolint:nilerr
+			}
+		}
+		return se, err
+	}
+}
+
+func (mmdb *MMDBSource) nextEntry(ctx context.Context) (*SourceEntry, error) {
+	// Check if there is an error, do not read if there is an error.
+	if mmdb.err != nil {
+		return nil, nil //nolint:nilerr
+	}
+
+	// Check if the context was canceled.
+	if err := ctx.Err(); err != nil {
+		mmdb.err = err
+		_ = mmdb.Close()
+		return nil, nil //nolint:nilerr
+	}
+
+	// Read next network.
+	if !mmdb.networks.Next() {
+		if err := mmdb.networks.Err(); err != nil {
+			mmdb.err = err
+		} else {
+			mmdb.err = io.EOF
+		}
+		_ = mmdb.Close()
+		return nil, nil //nolint:nilerr
+	}
+	var record any
+	ipNet, err := mmdb.networks.Network(&record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode record: %w", err)
+	}
+
+	// Flatten record into values.
+	se := &SourceEntry{
+		Net:    ipNet,
+		Values: make(map[string]SourceValue),
+	}
+	if recordMap, ok := record.(map[string]any); ok && mmdb.fieldNames == nil {
+		mmdb.fieldNames = mmdb.fields.fieldNames(recordMap, "", mmdb.types)
+	}
+	if record != nil {
+		if err := mmdb.addValue(se, "", record); err != nil {
+			return nil, fmt.Errorf("failed to read record of %s: %w", ipNet, err)
+		}
+	}
+
+	return se, nil
+}
+
+// FieldNames returns the names of the fields of the first entry.
+// It is empty until the first entry was read.
+func (mmdb *MMDBSource) FieldNames() []string {
+	return mmdb.fieldNames
+}
+
+// Close closes the underlying database of the source and stops reading.
+// Closing an already closed source does nothing.
+func (mmdb *MMDBSource) Close() error {
+	if mmdb.reader == nil {
+		return nil
+	}
+	if mmdb.err == nil {
+		mmdb.err = errSourceClosed
+	}
+	err := mmdb.reader.Close()
+	mmdb.reader = nil
+	return err
+}
+
+// Err returns the processing error encountered by the source.
+func (mmdb *MMDBSource) Err() error {
+	switch {
+	case mmdb.err == nil:
+		return nil
+	case errors.Is(mmdb.err, io.EOF):
+		return nil
+	default:
+		return mmdb.err
+	}
+}
+
+// addValue adds the given decoded mmdb value to the source entry.
+// Nested maps are flattened into dotted keys, reversing the nesting of ToMMDBMap.
+// Values with a declared type use that type, all others use the type they
+// were stored with.
+func (mmdb *MMDBSource) addValue(se *SourceEntry, key string, value any) error {
+	// Flatten nested maps.
+	if subMap, ok := value.(map[string]any); ok {
+		// Sort keys for a stable processing order.
+		subKeys := make([]string, 0, len(subMap))
+		for subKey := range subMap {
+			subKeys = append(subKeys, subKey)
+		}
+		sort.Strings(subKeys)
+
+		for _, subKey := range subKeys {
+			fullKey := subKey
+			if key != "" {
+				fullKey = key + "." + subKey
+			}
+			if err := mmdb.addValue(se, fullKey, subMap[subKey]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if key == "" {
+		return fmt.Errorf("record is a %T, not a map", value)
+	}
+	// Flatten arrays of maps or arrays into indexed keys, eg.
+	// "subdivisions.0.iso_code", which ToMMDBMap turns back into arrays.
+	if array, ok := value.([]any); ok && slices.ContainsFunc(array, isMMDBContainer) {
+		for i, arrayValue := range array {
+			if err := mmdb.addValue(se, key+"."+strconv.Itoa(i), arrayValue); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	sourceKey := key
+	key = mmdb.fields.targetKey(sourceKey)
+
+	// Check if the value is ignored.
+	fieldType, ok := mmdb.types[key]
+	if key == "-" || ok && (fieldType == "" || fieldType == "-") {
+		return nil
+	}
+
+	// Convert value to its string representation.
+	var (
+		storedType string
+		fieldValue string
+	)
+	if array, isArray := value.([]any); isArray {
+		fields := make([]string, 0, len(array))
+		for i, arrayValue := range array {
+			entryType, field, err := mmdbScalarToString(arrayValue)
+			if err != nil {
+				return fmt.Errorf("failed to read %s array entry #%d: %w", key, i, err)
+			}
+			if storedType == "" {
+				storedType = entryType
+			}
+			fields = append(fields, field)
+		}
+		// Ignore empty arrays, as their type cannot be derived.
+		if len(fields) == 0 {
+			return nil
+		}
+		storedType = "array:" + storedType
+		if ok {
+			storedType = fieldType
+		}
+		// Entries containing the separator are joined with another one,
+		// which is then defined by the type, so that they are not split.
+		arrayType, joined, err := joinArrayExact(storedType, mmdb.arraySeparator, fields)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", key, err)
+		}
+		fieldType, fieldValue, ok = arrayType, joined, true
+	} else {
+		var err error
+		storedType, fieldValue, err = mmdbScalarToString(value)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", key, err)
+		}
+	}
+
+	// Use declared type, if available.
+	if !ok {
+		fieldType = storedType
+	}
+
+	return mmdb.fields.setValue(se, sourceKey, key, SourceValue{
+		Type:  fieldType,
+		Value: fieldValue,
+	})
+}
+
+// isMMDBContainer returns whether the decoded mmdb value is a map or an array.
+func isMMDBContainer(value any) bool {
+	switch value.(type) {
+	case map[string]any, []any:
+		return true
+	default:
+		return false
+	}
+}
+
+// mmdbScalarToString returns the type and string representation of a
+// decoded mmdb value. Unsigned integers are returned as uint64, as the
+// decoder does not expose the width they were stored with.
+func mmdbScalarToString(value any) (fieldType, fieldValue string, err error) {
+	switch v := value.(type) {
+	case string:
+		return "string", v, nil
+	case bool:
+		return "bool", strconv.FormatBool(v), nil
+	case []byte:
+		return "hexbytes", hex.EncodeToString(v), nil
+	case int:
+		return "int32", strconv.FormatInt(int64(v), 10), nil
+	case uint64:
+		return "uint64", strconv.FormatUint(v, 10), nil
+	case *big.Int:
+		return "uint128", v.String(), nil
+	case float32:
+		return "float32", strconv.FormatFloat(float64(v), 'f', -1, 32), nil
+	case float64:
+		return "float64", strconv.FormatFloat(v, 'f', -1, 64), nil
+	default:
+		return "", "", fmt.Errorf("unsupported mmdb value type %T", value)
+	}
+}
@@ -0,0 +1,156 @@
+package mmdbmeld
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+func TestMMDBSource(t *testing.T) {
+	t.Parallel()
+
+	// Write test database.
+	writer, err := mmdbwriter.New(mmdbwriter.Options{
+		IPVersion:               6,
+		IncludeReservedNetworks: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ipNet, _ := net.ParseCIDR("2001:db8::/32")
+	err = writer.Insert(ipNet, mmdbtype.Map{
+		"country": mmdbtype.Map{
+			"iso_code": mmdbtype.String("AT"),
+		},
+		"autonomous_system_number": mmdbtype.Uint32(12345),
+		"tags":                     mmdbtype.Slice{mmdbtype.String("a"), mmdbtype.String("b")},
+		"cities":                   mmdbtype.Slice{mmdbtype.String("New York"), mmdbtype.String("Boston")},
+		"subdivisions": mmdbtype.Slice{
+			mmdbtype.Map{"iso_code": mmdbtype.String("9"), "names": mmdbtype.Map{"en": mmdbtype.String("Vienna")}},
+			mmdbtype.Map{"iso_code": mmdbtype.String("W")},
+		},
+		"ignored": mmdbtype.Bool(true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "test.mmdb")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+
+	// Read test database.
+	source, err := LoadMMDBSource(DatabaseInput{File: file}, map[string]string{
+		"autonomous_system_number": "uint32",
+		"ignored":                  "-",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := source.NextEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil {
+		t.Fatal("expected an entry")
+	}
+	if entry.Net.String() != "2001:db8::/32" {
+		t.Fatalf("unexpected network: %s", entry.Net)
+	}
+	expected := map[string]SourceValue{
+		"country.iso_code":         {Type: "string", Value: "AT"},
+		"autonomous_system_number": {Type: "uint32", Value: "12345"},
+		"tags":                     {Type: "array:string", Value: "a b"},
+		"cities":                   {Type: "array:string:\x1f", Value: "New York\x1fBoston"},
+		"subdivisions.0.iso_code":  {Type: "string", Value: "9"},
+		"subdivisions.0.names.en":  {Type: "string", Value: "Vienna"},
+		"subdivisions.1.iso_code":  {Type: "string", Value: "W"},
+	}
+	if len(entry.Values) != len(expected) {
+		t.Fatalf("unexpected values: %+v", entry.Values)
+	}
+	for k, v := range expected {
+		if entry.Values[k] != v {
+			t.Fatalf("unexpected value for %s: %+v", k, entry.Values[k])
+		}
+	}
+
+	// Arrays of maps are written as arrays again.
+	record, err := entry.ToMMDBMap(Optimizations{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%v", record["subdivisions"]) != "[map[iso_code:9 names:map[en:Vienna]] map[iso_code:W]]" {
+		t.Fatalf("unexpected subdivisions: %v", record["subdivisions"])
+	}
+	// Entries with whitespace are not split.
+	if fmt.Sprintf("%q", record["cities"]) != `["New York" "Boston"]` {
+		t.Fatalf("unexpected cities: %q", record["cities"])
+	}
+
+	// Check end of source.
+	entry, err = source.NextEntry()
+	if err != nil || entry != nil {
+		t.Fatalf("expected end of source, got %+v, %v", entry, err)
+	}
+	if source.Err() != nil {
+		t.Fatal(source.Err())
+	}
+}
+
+func TestMMDBSourceRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	csvFile := filepath.Join(dir, "test.csv")
+	if err := os.WriteFile(csvFile, []byte("192.0.2.0,192.0.2.255,New York|Boston\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dbConfig := DatabaseConfig{
+		Name:     "Test",
+		Output:   filepath.Join(dir, "test.mmdb"),
+		MMDB:     MMDBConfig{IPVersion: 4},
+		Types:    map[string]string{"cities": "array:string"},
+		Optimize: Optimizations{ArraySeparator: "|"},
+		Inputs: []DatabaseInput{{
+			File:   csvFile,
+			Fields: []string{"from", "to", "cities"},
+		}},
+	}
+	sources, err := LoadSources(dbConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteMMDB(dbConfig, sources, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read the database back with the same separator.
+	dbConfig.Inputs = []DatabaseInput{{File: dbConfig.Output}}
+	sources, err = LoadSources(dbConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sources[0].Close() //nolint:errcheck
+	entry, err := sources[0].NextEntry()
+	if err != nil || entry == nil {
+		t.Fatalf("unexpected entry: %+v, %v", entry, err)
+	}
+	record, err := entry.ToMMDBMap(dbConfig.Optimize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cities, ok := record["cities"].(mmdbtype.Slice); !ok || fmt.Sprintf("%q", cities) != `["New York" "Boston"]` {
+		t.Fatalf("unexpected cities: %#v", record["cities"])
+	}
+}
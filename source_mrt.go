@@ -0,0 +1,377 @@
+package mmdbmeld
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// MRT record types and subtypes, as defined in RFC 6396 and RFC 8050.
+const (
+	mrtHeaderLength = 12
+	// mrtMaxRecordLength limits the length of records, so that corrupt
+	// headers do not allocate huge buffers.
+	mrtMaxRecordLength = 1 << 24
+
+	mrtTypeTableDumpV2 = 13
+
+	mrtSubtypePeerIndexTable        = 1
+	mrtSubtypeRIBIPv4Unicast        = 2
+	mrtSubtypeRIBIPv6Unicast        = 4
+	mrtSubtypeRIBIPv4UnicastAddPath = 8
+	mrtSubtypeRIBIPv6UnicastAddPath = 10
+)
+
+// BGP path attributes used to find the origin AS.
+const (
+	bgpAttrFlagExtendedLength = 0x10
+	bgpAttrASPath             = 2
+
+	bgpASPathSet      = 1
+	bgpASPathSequence = 2
+)
+
+// mrtOriginField is the field holding the origin AS, as used in the fieldMap.
+const mrtOriginField = "autonomous_system_number"
+
+// MRTSource reads the origin AS of prefixes from BGP RIB dumps in the MRT
+// format, as defined in RFC 6396. Only TABLE_DUMP_V2 records of unicast
+// RIBs are read, other records are skipped.
+type MRTSource struct {
+	file   string
+	reader *bufio.Reader
+	closer io.Closer
+	types  map[string]string
+	fields fieldMapping
+
+	// Number of the last read record.
+	record int
+	// Amount of skipped records with unsupported types.
+	unsupported int
+	// Amount of skipped prefixes without an unambiguous origin AS.
+	ambiguous int
+
+	valueProcessing
+	errorHandling
+	networkFilter
+	err error
+}
+
+// LoadMRTSource returns a new MRTSource.
+func LoadMRTSource(input DatabaseInput, types map[string]string) (*MRTSource, error) {
+	filter, err := newNetworkFilter(input)
+	if err != nil {
+		return nil, err
+	}
+	file, err := openInput(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	return &MRTSource{
+		file:            inputName(input),
+		reader:          bufio.NewReader(file),
+		closer:          file,
+		types:           types,
+		fields:          newFieldMapping(input),
+		valueProcessing: newValueProcessing(input, types),
+		errorHandling:   newErrorHandling(input),
+		networkFilter:   filter,
+	}, nil
+}
+
+// Name returns an identifying name for the source.
+func (mrt *MRTSource) Name() string {
+	return mrt.file
+}
+
+// NextEntry returns the next entry of the source.
+// If nil, nil is returned, stop reading and check Err().
+func (mrt *MRTSource) NextEntry() (*SourceEntry, error) {
+	return mrt.NextEntryContext(context.Background())
+}
+
+// NextEntryContext returns the next entry of the source.
+// If nil, nil is returned, stop reading and check Err().
+// If the context is canceled, reading stops and Err() returns the context error.
+func (mrt *MRTSource) NextEntryContext(ctx context.Context) (*SourceEntry, error) {
+	for {
+		se, err := mrt.nextEntry(ctx)
+		if se != nil {
+			if err = mrt.processValues(se); err != nil {
+				err = mrt.recordError(err)
+				se = nil
+			}
+		}
+		if err != nil {
+			if mrt.skip(err) {
+				continue
+			}
+			if mrt.fail() {
+				mrt.err = err
+				_ = mrt.Close()
+				return nil, nil //nolint:nilerr
+			}
+		}
+		return se, err
+	}
+}
+
+func (mrt *MRTSource) nextEntry(ctx context.Context) (*SourceEntry, error) {
+	for {
+		// Check if there is an error, do not read if there is an error.
+		if mrt.err != nil {
+			return nil, nil //nolint:nilerr
+		}
+
+		// Check if the context was canceled.
+		if err := ctx.Err(); err != nil {
+			mrt.err = err
+			_ = mrt.Close()
+			return nil, nil //nolint:nilerr
+		}
+
+		// Read record.
+		var header [mrtHeaderLength]byte
+		if _, err := io.ReadFull(mrt.reader, header[:]); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				err = fmt.Errorf("truncated header of record #%d", mrt.record+1)
+			}
+			mrt.err = err
+			_ = mrt.Close()
+			return nil, nil //nolint:nilerr
+		}
+		mrt.record++
+		recordType := binary.BigEndian.Uint16(header[4:])
+		subtype := binary.BigEndian.Uint16(header[6:])
+		length := binary.BigEndian.Uint32(header[8:])
+		if length > mrtMaxRecordLength {
+			mrt.err = fmt.Errorf("record #%d exceeds maximum length with %d bytes", mrt.record, length)
+			_ = mrt.Close()
+			return nil, nil //nolint:nilerr
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(mrt.reader, body); err != nil {
+			mrt.err = fmt.Errorf("truncated record #%d: %w", mrt.record, err)
+			_ = mrt.Close()
+			return nil, nil //nolint:nilerr
+		}
+
+		// Parse unicast RIB entries and skip everything else.
+		if recordType != mrtTypeTableDumpV2 {
+			mrt.unsupported++
+			continue
+		}
+		var (
+			ipLength int
+			addPath  bool
+		)
+		switch subtype {
+		case mrtSubtypePeerIndexTable:
+			// Peers are not needed to find the origin AS.
+			continue
+		case mrtSubtypeRIBIPv4Unicast:
+			ipLength = net.IPv4len
+		case mrtSubtypeRIBIPv6Unicast:
+			ipLength = net.IPv6len
+		case mrtSubtypeRIBIPv4UnicastAddPath:
+			ipLength, addPath = net.IPv4len, true
+		case mrtSubtypeRIBIPv6UnicastAddPath:
+			ipLength, addPath = net.IPv6len, true
+		default:
+			mrt.unsupported++
+			continue
+		}
+		se, err := mrt.parseRIB(body, ipLength, addPath)
+		if se == nil && err == nil {
+			// Skip prefixes without an unambiguous origin AS.
+			mrt.ambiguous++
+			continue
+		}
+		return se, err
+	}
+}
+
+// parseRIB returns the source entry of the RIB record. The origin AS is
+// taken from the first RIB entry with an unambiguous origin. If no RIB entry
+// has one, eg. as all paths end in an AS_SET of an aggregate, no entry and
+// no error is returned.
+func (mrt *MRTSource) parseRIB(body []byte, ipLength int, addPath bool) (*SourceEntry, error) {
+	// Read prefix, after the sequence number.
+	if len(body) < 5 {
+		return nil, mrt.recordError(errors.New("truncated prefix"))
+	}
+	prefixLength := int(body[4])
+	if prefixLength > ipLength*8 {
+		return nil, mrt.recordError(fmt.Errorf("invalid prefix length %d", prefixLength))
+	}
+	prefixBytes := (prefixLength + 7) / 8
+	if len(body) < 5+prefixBytes+2 {
+		return nil, mrt.recordError(errors.New("truncated prefix"))
+	}
+	ip := make(net.IP, ipLength)
+	copy(ip, body[5:5+prefixBytes])
+	se := &SourceEntry{
+		Net: NormalizeNetwork(&net.IPNet{
+			IP:   ip,
+			Mask: net.CIDRMask(prefixLength, ipLength*8),
+		}),
+		Values: make(map[string]SourceValue),
+	}
+
+	// Find origin AS in the RIB entries.
+	entryCount := int(binary.BigEndian.Uint16(body[5+prefixBytes:]))
+	data := body[5+prefixBytes+2:]
+	entryHeaderLength := 8 // peer index, originated time, attribute length
+	if addPath {
+		entryHeaderLength += 4 // path identifier
+	}
+	var (
+		origin    uint32
+		hasOrigin bool
+	)
+	for i := 0; i < entryCount && !hasOrigin; i++ {
+		if len(data) < entryHeaderLength {
+			return nil, mrt.recordError(fmt.Errorf("truncated RIB entry #%d of %s", i, se.Net))
+		}
+		attrLength := int(binary.BigEndian.Uint16(data[entryHeaderLength-2:]))
+		data = data[entryHeaderLength:]
+		if len(data) < attrLength {
+			return nil, mrt.recordError(fmt.Errorf("truncated attributes of RIB entry #%d of %s", i, se.Net))
+		}
+		var err error
+		origin, hasOrigin, err = bgpOriginAS(data[:attrLength])
+		if err != nil {
+			return nil, mrt.recordError(fmt.Errorf("invalid attributes of RIB entry #%d of %s: %w", i, se.Net, err))
+		}
+		data = data[attrLength:]
+	}
+	if !hasOrigin {
+		return nil, nil
+	}
+
+	key := mrt.fields.targetKey(mrtOriginField)
+	if fieldType, ok := fieldTypeFor(mrt.types, key); ok {
+		se.Values[key] = SourceValue{
+			Type:  fieldType,
+			Value: strconv.FormatUint(uint64(origin), 10),
+		}
+	}
+	return se, nil
+}
+
+// recordError returns the error with the position of the current record.
+func (mrt *MRTSource) recordError(err error) error {
+	return fmt.Errorf("%s record #%d: %w", mrt.Name(), mrt.record, err)
+}
+
+// bgpOriginAS returns the origin AS from the AS_PATH of the given BGP path
+// attributes, which is the last AS of the path. AS numbers are always
+// encoded with 4 bytes in TABLE_DUMP_V2 records. If the path ends with an
+// AS_SET of multiple AS, eg. because of aggregation, the origin is ambiguous
+// and false is returned.
+func bgpOriginAS(attrs []byte) (uint32, bool, error) {
+	for len(attrs) > 0 {
+		if len(attrs) < 3 {
+			return 0, false, errors.New("truncated attribute")
+		}
+		flags, code := attrs[0], attrs[1]
+		var length, headerLength int
+		if flags&bgpAttrFlagExtendedLength != 0 {
+			if len(attrs) < 4 {
+				return 0, false, errors.New("truncated attribute")
+			}
+			length, headerLength = int(binary.BigEndian.Uint16(attrs[2:])), 4
+		} else {
+			length, headerLength = int(attrs[2]), 3
+		}
+		if len(attrs) < headerLength+length {
+			return 0, false, fmt.Errorf("truncated attribute %d", code)
+		}
+		value := attrs[headerLength : headerLength+length]
+		attrs = attrs[headerLength+length:]
+		if code != bgpAttrASPath {
+			continue
+		}
+
+		// Use the last AS of the path.
+		var (
+			origin    uint32
+			hasOrigin bool
+		)
+		for len(value) > 0 {
+			if len(value) < 2 {
+				return 0, false, errors.New("truncated AS_PATH segment")
+			}
+			segmentType, count := value[0], int(value[1])
+			if len(value) < 2+count*4 {
+				return 0, false, errors.New("truncated AS_PATH segment")
+			}
+			switch {
+			case count == 0:
+			case segmentType == bgpASPathSequence || (segmentType == bgpASPathSet && count == 1):
+				origin = binary.BigEndian.Uint32(value[2+(count-1)*4:])
+				hasOrigin = true
+			case segmentType == bgpASPathSet:
+				hasOrigin = false
+			default:
+				return 0, false, fmt.Errorf("invalid AS_PATH segment type %d", segmentType)
+			}
+			value = value[2+count*4:]
+		}
+		return origin, hasOrigin, nil
+	}
+	return 0, false, nil
+}
+
+// Unsupported returns the amount of records that were skipped, as their type
+// is not supported.
+func (mrt *MRTSource) Unsupported() int {
+	return mrt.unsupported
+}
+
+// Ambiguous returns the amount of prefixes that were skipped, as none of
+// their paths has an unambiguous origin AS.
+func (mrt *MRTSource) Ambiguous() int {
+	return mrt.ambiguous
+}
+
+// FieldNames returns the name of the field of the origin AS, unless it is
+// dropped.
+func (mrt *MRTSource) FieldNames() []string {
+	if key := mrt.fields.targetKey(mrtOriginField); key != "-" {
+		return []string{key}
+	}
+	return nil
+}
+
+// Close closes the underlying file of the source and stops reading.
+// Closing an already closed source does nothing.
+func (mrt *MRTSource) Close() error {
+	if mrt.closer == nil {
+		return nil
+	}
+	if mrt.err == nil {
+		mrt.err = errSourceClosed
+	}
+	err := mrt.closer.Close()
+	mrt.closer = nil
+	return err
+}
+
+// Err returns the processing error encountered by the source.
+func (mrt *MRTSource) Err() error {
+	switch {
+	case mrt.err == nil:
+		return nil
+	case errors.Is(mrt.err, io.EOF):
+		return nil
+	default:
+		return mrt.err
+	}
+}
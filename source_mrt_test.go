@@ -0,0 +1,156 @@
+package mmdbmeld
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testMRTRecord returns a MRT record with the given type, subtype and body.
+func testMRTRecord(recordType, subtype uint16, body []byte) []byte {
+	record := make([]byte, mrtHeaderLength, mrtHeaderLength+len(body))
+	binary.BigEndian.PutUint32(record[0:], 1700000000)
+	binary.BigEndian.PutUint16(record[4:], recordType)
+	binary.BigEndian.PutUint16(record[6:], subtype)
+	binary.BigEndian.PutUint32(record[8:], uint32(len(body)))
+	return append(record, body...)
+}
+
+// testMRTRIB returns the body of a RIB record for the prefix, with a RIB
+// entry for every given AS_PATH. Paths are given as segments, where the
+// first number is the segment type.
+func testMRTRIB(t *testing.T, prefix string, addPath bool, paths ...[][]uint32) []byte {
+	t.Helper()
+
+	_, ipNet, err := net.ParseCIDR(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ones, _ := ipNet.Mask.Size()
+	ip := ipNet.IP
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	body := []byte{0, 0, 0, 1, byte(ones)}
+	body = append(body, ip[:(ones+7)/8]...)
+	body = binary.BigEndian.AppendUint16(body, uint16(len(paths)))
+	for i, path := range paths {
+		var asPath []byte
+		for _, segment := range path {
+			asPath = append(asPath, byte(segment[0]), byte(len(segment)-1))
+			for _, as := range segment[1:] {
+				asPath = binary.BigEndian.AppendUint32(asPath, as)
+			}
+		}
+		// ORIGIN attribute, followed by the AS_PATH.
+		attrs := []byte{0x40, 1, 1, 0, 0x40, bgpAttrASPath, byte(len(asPath))}
+		attrs = append(attrs, asPath...)
+
+		body = binary.BigEndian.AppendUint16(body, uint16(i))  // Peer index.
+		body = binary.BigEndian.AppendUint32(body, 1700000000) // Originated time.
+		if addPath {
+			body = binary.BigEndian.AppendUint32(body, uint32(i)) // Path identifier.
+		}
+		body = binary.BigEndian.AppendUint16(body, uint16(len(attrs)))
+		body = append(body, attrs...)
+	}
+	return body
+}
+
+func TestMRTSource(t *testing.T) {
+	t.Parallel()
+
+	var data []byte
+	data = append(data, testMRTRecord(mrtTypeTableDumpV2, mrtSubtypePeerIndexTable, []byte{0, 0, 0, 0, 0, 0, 0, 0})...)
+	data = append(data, testMRTRecord(mrtTypeTableDumpV2, mrtSubtypeRIBIPv4Unicast, testMRTRIB(t, "192.0.2.0/24", false,
+		[][]uint32{{bgpASPathSequence, 64500, 64496}},
+		[][]uint32{{bgpASPathSequence, 64501, 64497}},
+	))...)
+	data = append(data, testMRTRecord(16, 4, []byte{1, 2, 3})...) // BGP4MP message.
+	data = append(data, testMRTRecord(mrtTypeTableDumpV2, mrtSubtypeRIBIPv6UnicastAddPath, testMRTRIB(t, "2001:db8::/32", true,
+		[][]uint32{{bgpASPathSequence, 64500}, {bgpASPathSet, 64498}},
+	))...)
+	data = append(data, testMRTRecord(mrtTypeTableDumpV2, mrtSubtypeRIBIPv4Unicast, testMRTRIB(t, "198.51.100.0/24", false,
+		[][]uint32{{bgpASPathSequence, 64500}, {bgpASPathSet, 64498, 64499}}, // Aggregated, ambiguous origin.
+	))...)
+	data = append(data, testMRTRecord(mrtTypeTableDumpV2, 3, nil)...) // Multicast RIB.
+
+	// Write gzipped, as dumps are usually compressed.
+	file := filepath.Join(t.TempDir(), "rib.20240101.0000.gz")
+	buf := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(buf)
+	_, _ = gzipWriter.Write(data)
+	_ = gzipWriter.Close()
+	if err := os.WriteFile(file, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := LoadSources(DatabaseConfig{
+		Types: map[string]string{"autonomous_system_number": "uint32"},
+		Inputs: []DatabaseInput{{
+			File:   file,
+			Format: "mrt",
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, ok := sources[0].(*MRTSource)
+	if !ok {
+		t.Fatalf("expected mrt source, got %T", sources[0])
+	}
+
+	var entries, errs []string
+	for {
+		entry, err := source.NextEntry()
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if entry == nil {
+			break
+		}
+		entries = append(entries, fmt.Sprintf("%s:%s", entry.Net, entry.Values["autonomous_system_number"].Value))
+	}
+	if source.Err() != nil {
+		t.Fatal(source.Err())
+	}
+	if fmt.Sprintf("%v", entries) != "[192.0.2.0/24:64496 2001:db8::/32:64498]" {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if source.Unsupported() != 2 {
+		t.Fatalf("unexpected amount of unsupported records: %d", source.Unsupported())
+	}
+	if source.Ambiguous() != 1 {
+		t.Fatalf("unexpected amount of ambiguous prefixes: %d", source.Ambiguous())
+	}
+}
+
+func TestMRTSourceTruncated(t *testing.T) {
+	t.Parallel()
+
+	record := testMRTRecord(mrtTypeTableDumpV2, mrtSubtypeRIBIPv4Unicast, testMRTRIB(t, "192.0.2.0/24", false,
+		[][]uint32{{bgpASPathSequence, 64496}},
+	))
+	file := filepath.Join(t.TempDir(), "test.mrt")
+	if err := os.WriteFile(file, record[:len(record)-2], 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := LoadMRTSource(DatabaseInput{File: file}, map[string]string{"autonomous_system_number": "uint32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, _ := source.NextEntry(); entry != nil || source.Err() == nil {
+		t.Fatalf("expected error for truncated record, got %+v", entry)
+	}
+}
@@ -0,0 +1,303 @@
+package mmdbmeld
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// rirColumns are the columns of RIR statistics records that are read as
+// values, with their names as used in the fieldMap.
+var rirColumns = []struct {
+	index int
+	name  string
+}{
+	{0, "registry"},
+	{1, "country"},
+	{5, "date"},
+	{6, "status"},
+	{7, "opaque_id"},
+}
+
+// RIRSource reads the delegation statistics published by the regional
+// internet registries, eg. the delegated-ripencc-extended-latest file.
+// Only the ipv4 and ipv6 records are read, the version header, the summary
+// lines and asn records are skipped.
+type RIRSource struct {
+	file   string
+	reader *csv.Reader
+	closer io.Closer
+	types  map[string]string
+	fields fieldMapping
+
+	// Amount of skipped version headers and summary lines.
+	summaries int
+	// Amount of skipped asn and other records with unsupported types.
+	unsupported int
+	// Amount of skipped records of resources that are not delegated.
+	undelegated int
+
+	lineEstimate
+	valueProcessing
+	errorHandling
+	networkFilter
+	err error
+}
+
+// LoadRIRSource returns a new RIRSource.
+func LoadRIRSource(input DatabaseInput, types map[string]string) (*RIRSource, error) {
+	filter, err := newNetworkFilter(input)
+	if err != nil {
+		return nil, err
+	}
+	fields := newFieldMapping(input)
+	if _, err := fields.targetKeys(rirColumnNames()); err != nil {
+		return nil, err
+	}
+	file, err := openInput(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	reader := csv.NewReader(bufio.NewReader(file))
+	reader.Comma = '|'
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	return &RIRSource{
+		file:            inputName(input),
+		reader:          reader,
+		closer:          file,
+		types:           types,
+		fields:          fields,
+		lineEstimate:    newLineEstimate(input, 0),
+		valueProcessing: newValueProcessing(input, types),
+		errorHandling:   newErrorHandling(input),
+		networkFilter:   filter,
+	}, nil
+}
+
+// rirColumnNames returns the names of the value columns.
+func rirColumnNames() []string {
+	names := make([]string, 0, len(rirColumns))
+	for _, column := range rirColumns {
+		names = append(names, column.name)
+	}
+	return names
+}
+
+// Name returns an identifying name for the source.
+func (rir *RIRSource) Name() string {
+	return rir.file
+}
+
+// NextEntry returns the next entry of the source.
+// If nil, nil is returned, stop reading and check Err().
+func (rir *RIRSource) NextEntry() (*SourceEntry, error) {
+	return rir.NextEntryContext(context.Background())
+}
+
+// NextEntryContext returns the next entry of the source.
+// If nil, nil is returned, stop reading and check Err().
+// If the context is canceled, reading stops and Err() returns the context error.
+func (rir *RIRSource) NextEntryContext(ctx context.Context) (*SourceEntry, error) {
+	for {
+		se, err := rir.nextEntry(ctx)
+		if se != nil {
+			if err = rir.processValues(se); err != nil {
+				err = fmt.Errorf("%s: %w", entryPosition(rir, se), err)
+				se = nil
+			}
+		}
+		if err != nil {
+			if rir.skip(err) {
+				continue
+			}
+			if rir.fail() {
+				rir.err = err
+				_ = rir.Close()
+				return nil, nil //nolint:nilerr
+			}
+		}
+		return se, err
+	}
+}
+
+func (rir *RIRSource) nextEntry(ctx context.Context) (*SourceEntry, error) {
+	for {
+		// Check if there is an error, do not read if there is an error.
+		if rir.err != nil {
+			return nil, nil //nolint:nilerr
+		}
+
+		// Check if the context was canceled.
+		if err := ctx.Err(); err != nil {
+			rir.err = err
+			_ = rir.Close()
+			return nil, nil //nolint:nilerr
+		}
+
+		// Read and parse line.
+		row, err := rir.reader.Read()
+		if err != nil {
+			rir.err = err
+			_ = rir.Close()
+			return nil, nil //nolint:nilerr
+		}
+		line, _ := rir.reader.FieldPos(0)
+
+		// Skip the version header, which starts with the version number, and
+		// the summary lines.
+		switch {
+		case len(row) > 0 && strings.TrimLeft(row[0], "0123456789.") == "" && row[0] != "":
+			rir.summaries++
+			continue
+		case len(row) >= 6 && row[5] == "summary":
+			rir.summaries++
+			continue
+		case len(row) < 7:
+			return nil, errorAtLine(rir.Name(), line, fmt.Errorf("expected at least 7 columns, got %d", len(row)))
+		}
+
+		// Skip records of resources that are not delegated.
+		switch row[6] {
+		case "available", "reserved":
+			rir.undelegated++
+			continue
+		}
+
+		se := &SourceEntry{
+			Values: make(map[string]SourceValue),
+			Line:   line,
+		}
+		switch row[2] {
+		case "ipv4":
+			se.From, se.To, err = rirIPv4Range(row[3], row[4])
+		case "ipv6":
+			se.Net, err = rirIPv6Network(row[3], row[4])
+		default:
+			// Skip asn and other records.
+			rir.unsupported++
+			continue
+		}
+		if err != nil {
+			return nil, errorAtLine(rir.Name(), line, err)
+		}
+
+		// Parse values, omitting empty columns.
+		for _, column := range rirColumns {
+			if column.index >= len(row) || row[column.index] == "" {
+				continue
+			}
+			key := rir.fields.targetKey(column.name)
+			if fieldType, ok := fieldTypeFor(rir.types, key); ok {
+				se.Values[key] = SourceValue{
+					Type:  fieldType,
+					Value: row[column.index],
+				}
+			}
+		}
+		return se, nil
+	}
+}
+
+// rirIPv4Range returns the range of the given amount of IPv4 addresses,
+// starting at the start address.
+func rirIPv4Range(start, count string) (from, to net.IP, err error) {
+	from = net.ParseIP(start).To4()
+	if from == nil {
+		return nil, nil, fmt.Errorf("failed to parse IPv4 %q", start)
+	}
+	hosts, err := strconv.ParseUint(count, 10, 32)
+	if err != nil || hosts == 0 {
+		return nil, nil, fmt.Errorf("invalid amount of IPv4 addresses %q", count)
+	}
+	end := uint64(binary.BigEndian.Uint32(from)) + hosts - 1
+	if end > math.MaxUint32 {
+		return nil, nil, fmt.Errorf("range of %s addresses starting at %s exceeds the IPv4 address space", count, start)
+	}
+	to = binary.BigEndian.AppendUint32(nil, uint32(end))
+	return from, to, nil
+}
+
+// rirIPv6Network returns the IPv6 network of the start address with the
+// given prefix length.
+func rirIPv6Network(start, prefixLength string) (*net.IPNet, error) {
+	ip := net.ParseIP(start)
+	if ip == nil || ip.To4() != nil {
+		return nil, fmt.Errorf("failed to parse IPv6 %q", start)
+	}
+	bits, err := strconv.Atoi(prefixLength)
+	if err != nil || bits < 0 || bits > 128 {
+		return nil, fmt.Errorf("invalid IPv6 prefix length %q", prefixLength)
+	}
+	network := &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, 128)}
+	if !ip.Equal(ip.Mask(network.Mask)) {
+		return nil, fmt.Errorf("IPv6 %s has host bits set for prefix length %d", start, bits)
+	}
+	return network, nil
+}
+
+// Summaries returns the amount of version headers and summary lines that
+// were skipped.
+func (rir *RIRSource) Summaries() int {
+	return rir.summaries
+}
+
+// Unsupported returns the amount of records that were skipped, as their type
+// is not supported, eg. asn records.
+func (rir *RIRSource) Unsupported() int {
+	return rir.unsupported
+}
+
+// Undelegated returns the amount of records that were skipped, as their
+// status is available or reserved.
+func (rir *RIRSource) Undelegated() int {
+	return rir.undelegated
+}
+
+// FieldNames returns the names of the fields of the value columns, unless
+// they are dropped.
+func (rir *RIRSource) FieldNames() []string {
+	var names []string
+	for _, column := range rirColumnNames() {
+		if key := rir.fields.targetKey(column); key != "-" {
+			names = append(names, key)
+		}
+	}
+	return names
+}
+
+// Close closes the underlying file of the source and stops reading.
+// Closing an already closed source does nothing.
+func (rir *RIRSource) Close() error {
+	if rir.closer == nil {
+		return nil
+	}
+	if rir.err == nil {
+		rir.err = errSourceClosed
+	}
+	err := rir.closer.Close()
+	rir.closer = nil
+	return err
+}
+
+// Err returns the processing error encountered by the source.
+func (rir *RIRSource) Err() error {
+	switch {
+	case rir.err == nil:
+		return nil
+	case errors.Is(rir.err, io.EOF):
+		return nil
+	default:
+		return rir.err
+	}
+}
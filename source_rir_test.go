@@ -0,0 +1,106 @@
+package mmdbmeld
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRIRSource(t *testing.T) {
+	t.Parallel()
+
+	data := `# Comment
+2.3|ripencc|1700000000|6|19830705|20240101|+0100
+ripencc|*|asn|*|1|summary
+ripencc|*|ipv4|*|3|summary
+ripencc|*|ipv6|*|2|summary
+ripencc|AT|asn|64496|1|20100101|allocated|abc
+ripencc|AT|ipv4|192.0.2.0|256|20100101|allocated|abc
+ripencc|DE|ipv4|198.51.100.0|384|20120101|assigned|def
+ripencc|ZZ|ipv4|203.0.113.0|256||available|
+ripencc|ZZ|ipv6|2001:db8:1::|48||reserved|
+ripencc|CH|ipv6|2001:db8::|32|20140101|allocated|ghi
+ripencc|FR|ipv6|2001:db9::1|32|20140101|allocated|jkl
+`
+	file := filepath.Join(t.TempDir(), "delegated-ripencc-extended-latest")
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := LoadSources(DatabaseConfig{
+		Types: map[string]string{"country.iso_code": "string", "registry": "string"},
+		Inputs: []DatabaseInput{{
+			File:     file,
+			FieldMap: map[string]string{"country": "country.iso_code"},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, ok := sources[0].(*RIRSource)
+	if !ok {
+		t.Fatalf("expected rir source, got %T", sources[0])
+	}
+
+	var entries, errs []string
+	for {
+		entry, err := source.NextEntry()
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if entry == nil {
+			break
+		}
+		networks, err := entry.Networks()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, fmt.Sprintf("%v:%s:%s", networks, entry.Values["country.iso_code"].Value, entry.Values["registry"].Value))
+	}
+	if source.Err() != nil {
+		t.Fatal(source.Err())
+	}
+	expected := "[[192.0.2.0/24]:AT:ripencc [198.51.100.0/24 198.51.101.0/25]:DE:ripencc [2001:db8::/32]:CH:ripencc]"
+	if fmt.Sprintf("%v", entries) != expected {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0], "line 12: IPv6 2001:db9::1 has host bits set") {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if source.Summaries() != 4 {
+		t.Fatalf("unexpected amount of summaries: %d", source.Summaries())
+	}
+	if source.Unsupported() != 1 {
+		t.Fatalf("unexpected amount of unsupported records: %d", source.Unsupported())
+	}
+	if source.Undelegated() != 2 {
+		t.Fatalf("unexpected amount of undelegated records: %d", source.Undelegated())
+	}
+}
+
+func TestRIRSourceInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, line := range []string{
+		"arin|US|ipv4|192.0.2.0|0|20100101|allocated",
+		"arin|US|ipv4|255.255.255.0|512|20100101|allocated",
+		"arin|US|ipv4|2001:db8::|256|20100101|allocated",
+		"arin|US|ipv6|2001:db8::|129|20100101|allocated",
+		"arin|US|ipv4|192.0.2.0|256",
+	} {
+		file := filepath.Join(t.TempDir(), "delegated-arin-extended-latest")
+		if err := os.WriteFile(file, []byte(line+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		source, err := LoadRIRSource(DatabaseInput{File: file}, map[string]string{"country": "string"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry, err := source.NextEntry(); entry != nil || err == nil {
+			t.Fatalf("expected error for %q, got %+v", line, entry)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package mmdbmeld
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	_ "modernc.org/sqlite" // Register sqlite driver.
+)
+
+// SQLiteSource reads geoip data from the results of a query on a sqlite database.
+type SQLiteSource struct {
+	file    string
+	rows    *sql.Rows
+	closer  io.Closer
+	columns []string
+	types   map[string]string
+
+	valueProcessing
+	errorHandling
+	networkFilter
+	err error
+}
+
+// LoadSQLiteSource returns a new SQLiteSource.
+// The columns of the query results are used as the fields.
+func LoadSQLiteSource(input DatabaseInput, types map[string]string) (*SQLiteSource, error) {
+	filter, err := newNetworkFilter(input)
+	if err != nil {
+		return nil, err
+	}
+	if isURL(input.File) || isArchive(input) {
+		return nil, errors.New("sqlite databases must be local files")
+	}
+	if input.Query == "" {
+		return nil, errors.New("no query defined")
+	}
+
+	db, err := sql.Open("sqlite", sqliteDSN(input.File))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	rows, err := db.QueryContext(inputContext(input), input.Query)
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to query database: %w", err)
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		_ = rows.Close()
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to get columns: %w", err)
+	}
+	columns, err = newFieldMapping(input).targetKeys(columns)
+	if err != nil {
+		_ = rows.Close()
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &SQLiteSource{
+		file:            inputName(input),
+		rows:            rows,
+		closer:          &sqliteCloser{rows: rows, db: db},
+		columns:         columns,
+		types:           types,
+		valueProcessing: newValueProcessing(input, types),
+		errorHandling:   newErrorHandling(input),
+		networkFilter:   filter,
+	}, nil
+}
+
+// sqliteDSN returns the URI opening the database file read-only. The path is
+// escaped, so that characters like "?" or "#" are part of the file name.
+func sqliteDSN(file string) string {
+	dsn := &url.URL{
+		Scheme:   "file",
+		Path:     file,
+		RawQuery: "mode=ro",
+	}
+	return dsn.String()
+}
+
+// sqliteCloser closes both the query results and the database.
+type sqliteCloser struct {
+	rows *sql.Rows
+	db   *sql.DB
+}
+
+func (sc *sqliteCloser) Close() error {
+	rowsErr := sc.rows.Close()
+	dbErr := sc.db.Close()
+	if rowsErr != nil {
+		return rowsErr
+	}
+	return dbErr
+}
+
+// FieldNames returns the names of the columns of the query results.
+func (sqlite *SQLiteSource) FieldNames() []string {
+	return sqlite.columns
+}
+
+// Name returns an identifying name for the source.
+func (sqlite *SQLiteSource) Name() string {
+	return sqlite.file
+}
+
+// NextEntry returns the next entry of the source.
+// If nil, nil is returned, stop reading and check Err().
+func (sqlite *SQLiteSource) NextEntry() (*SourceEntry, error) {
+	return sqlite.NextEntryContext(context.Background())
+}
+
+// NextEntryContext returns the next entry of the source.
+// If nil, nil is returned, stop reading and check Err().
+// If the context is canceled, reading stops and Err() returns the context error.
+func (sqlite *SQLiteSource) NextEntryContext(ctx context.Context) (*SourceEntry, error) {
+	for {
+		se, err := sqlite.nextEntry(ctx)
+		if se != nil {
+			if err = sqlite.processValues(se); err != nil {
+				err = fmt.Errorf("%s: %w", entryPosition(sqlite, se), err)
+				se = nil
+			}
+		}
+		if err != nil {
+			if sqlite.skip(err) {
+				continue
+			}
+			if sqlite.fail() {
+				sqlite.err = err
+				_ = sqlite.Close()
+				return nil, nil //nolint:nilerr
+			}
+		}
+		return se, err
+	}
+}
+
+func (sqlite *SQLiteSource) nextEntry(ctx context.Context) (*SourceEntry, error) {
+	// Check if there is an error, do not read if there is an error.
+	if sqlite.err != nil {
+		return nil, nil //nolint:nilerr
+	}
+
+	// Check if the context was canceled.
+	if err := ctx.Err(); err != nil {
+		sqlite.err = err
+		_ = sqlite.Close()
+		return nil, nil //nolint:nilerr
+	}
+
+	// Read next row.
+	if !sqlite.rows.Next() {
+		if err := sqlite.rows.Err(); err != nil {
+			sqlite.err = err
+		} else {
+			sqlite.err = io.EOF
+		}
+		_ = sqlite.Close()
+		return nil, nil //nolint:nilerr
+	}
+	values := make([]any, len(sqlite.columns))
+	pointers := make([]any, len(sqlite.columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	if err := sqlite.rows.Scan(pointers...); err != nil {
+		return nil, fmt.Errorf("failed to read row: %w", err)
+	}
+
+	// Parse row.
+	se := &SourceEntry{
+		Values: make(map[string]SourceValue),
+	}
+	for i, column := range sqlite.columns {
+		// Omit NULL values.
+		if values[i] == nil {
+			continue
+		}
+
+		switch column {
+		case "network":
+			netData := sqliteValueToString(values[i], "")
+			_, ipNet, err := net.ParseCIDR(netData)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse net %s: %w", netData, err)
+			}
+			se.Net = ipNet
+		case "start", "end":
+			ip, err := parseJSONIP(sqliteValueToString(values[i], ""))
+			if err != nil {
+				return nil, err
+			}
+			if column == "start" {
+				se.From = ip
+			} else {
+				se.To = ip
+			}
+		default:
+			if fieldType, ok := fieldTypeFor(sqlite.types, column); ok {
+				se.Values[column] = SourceValue{
+					Type:  fieldType,
+					Value: sqliteValueToString(values[i], fieldType),
+				}
+			}
+		}
+	}
+
+	// Check if the entry has an IP range.
+	if se.Net == nil && (se.From == nil || se.To == nil) {
+		return nil, errors.New("row is missing network or start and end")
+	}
+
+	return se, nil
+}
+
+// sqliteValueToString returns the string representation of a sqlite value.
+// Blobs are hex encoded for the hexbytes type.
+func sqliteValueToString(value any, fieldType string) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		if fieldType == "hexbytes" {
+			return hex.EncodeToString(v)
+		}
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Close closes the underlying file of the source and stops reading.
+// Closing an already closed source does nothing.
+func (sqlite *SQLiteSource) Close() error {
+	if sqlite.closer == nil {
+		return nil
+	}
+	if sqlite.err == nil {
+		sqlite.err = errSourceClosed
+	}
+	err := sqlite.closer.Close()
+	sqlite.closer = nil
+	return err
+}
+
+// Err returns the processing error encountered by the source.
+func (sqlite *SQLiteSource) Err() error {
+	switch {
+	case sqlite.err == nil:
+		return nil
+	case errors.Is(sqlite.err, io.EOF):
+		return nil
+	default:
+		return sqlite.err
+	}
+}
@@ -0,0 +1,92 @@
+package mmdbmeld
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteSource(t *testing.T) {
+	t.Parallel()
+
+	// Create database.
+	file := filepath.Join(t.TempDir(), "test.sqlite")
+	db, err := sql.Open("sqlite", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, statement := range []string{
+		"CREATE TABLE geoip (network TEXT, start TEXT, end TEXT, country TEXT, asn INTEGER, score REAL)",
+		"INSERT INTO geoip VALUES ('192.0.2.0/24', NULL, NULL, 'AT', 64496, 1.5)",
+		"INSERT INTO geoip VALUES (NULL, '198.51.100.0', '198.51.100.255', NULL, NULL, NULL)",
+		"INSERT INTO geoip VALUES (NULL, NULL, NULL, 'DE', NULL, NULL)",
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Characters of URIs are part of the file name.
+	weirdFile := filepath.Join(filepath.Dir(file), "test?mode=rwc#%20.sqlite")
+	if err := os.Rename(file, weirdFile); err != nil {
+		t.Fatal(err)
+	}
+	file = weirdFile
+
+	source, err := LoadSQLiteSource(DatabaseInput{
+		File:     file,
+		Query:    "SELECT network, start, end, country AS \"country.iso_code\", asn, score FROM geoip ORDER BY rowid",
+		Defaults: map[string]string{"country.iso_code": "ZZ"},
+	}, map[string]string{
+		"country.iso_code": "string",
+		"asn":              "uint32",
+		"score":            "float32",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := source.NextEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Net.String() != "192.0.2.0/24" ||
+		entry.Values["country.iso_code"].Value != "AT" ||
+		entry.Values["asn"].Value != "64496" ||
+		entry.Values["score"].Value != "1.5" {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+
+	entry, err = source.NextEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.From.String() != "198.51.100.0" || entry.To.String() != "198.51.100.255" ||
+		len(entry.Values) != 1 || entry.Values["country.iso_code"].Value != "ZZ" {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+
+	// Rows without network must fail.
+	if _, err := source.NextEntry(); err == nil {
+		t.Fatal("expected error for row without network")
+	}
+	if entry, err := source.NextEntry(); entry != nil || err != nil || source.Err() != nil {
+		t.Fatalf("expected end of source, got %+v, %v, %v", entry, err, source.Err())
+	}
+
+	// Queries stop when the context is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = LoadSourcesContext(ctx, DatabaseConfig{
+		Inputs: []DatabaseInput{{File: file, Query: "SELECT network FROM geoip"}},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled error, got %v", err)
+	}
+}
@@ -1,8 +1,23 @@
 package mmdbmeld
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"unicode/utf16"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
 )
 
 func TestMMDBTypes(t *testing.T) {
@@ -45,3 +60,2225 @@ func TestMMDBTypes(t *testing.T) {
 		t.Fatalf("mmdb map string not as expected: %s", s)
 	}
 }
+
+func TestGzipInput(t *testing.T) {
+	t.Parallel()
+
+	// Write gzipped csv file.
+	file := filepath.Join(t.TempDir(), "test.csv.gz")
+	buf := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(buf)
+	_, _ = gzipWriter.Write([]byte("192.0.2.0,192.0.2.255,AT\n"))
+	_ = gzipWriter.Close()
+	if err := os.WriteFile(file, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := LoadSources(DatabaseConfig{
+		Types: map[string]string{
+			"country.iso_code": "string",
+		},
+		Inputs: []DatabaseInput{{
+			File:   file,
+			Fields: []string{"from", "to", "country.iso_code"},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sources[0].(*CSVSource); !ok {
+		t.Fatalf("expected csv source, got %T", sources[0])
+	}
+	entry, err := sources[0].NextEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil || entry.Values["country.iso_code"].Value != "AT" {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+}
+
+func TestInt64Type(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"-2147483648":         "-2147483648",
+		"2147483647":          "2147483647",
+		"9223372036854775807": "9223372036854775807",
+	}
+	for value, expected := range tests {
+		v, err := SourceValue{Type: "int64", Value: value}.ToMMDBType(Optimizations{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprintf("%v", v) != expected {
+			t.Fatalf("unexpected value for %s: %v", value, v)
+		}
+	}
+
+	// Check invalid values.
+	for _, value := range []string{"-2147483649", "9223372036854775808"} {
+		if _, err := (SourceValue{Type: "int64", Value: value}).ToMMDBType(Optimizations{}); err == nil {
+			t.Fatalf("expected error for %s", value)
+		}
+	}
+
+	// Check arrays.
+	v, err := SourceValue{Type: "array:int64", Value: "-1 4294967296"}.ToMMDBType(Optimizations{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%v", v) != "[-1 4294967296]" {
+		t.Fatalf("unexpected array: %v", v)
+	}
+}
+
+func TestBytesTypes(t *testing.T) {
+	t.Parallel()
+
+	tests := []SourceValue{
+		{Type: "hexbytes", Value: "fbff00"},
+		{Type: "base64bytes", Value: "+/8A"},
+		{Type: "base64url", Value: "-_8A"},
+		{Type: "base64url", Value: "-_8A="},
+	}
+	for _, sv := range tests {
+		v, err := sv.ToMMDBType(Optimizations{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprintf("%x", v) != "fbff00" {
+			t.Fatalf("unexpected value for %+v: %x", sv, v)
+		}
+	}
+
+	if _, err := (SourceValue{Type: "base64bytes", Value: "-_8A"}).ToMMDBType(Optimizations{}); err == nil {
+		t.Fatal("expected error for invalid base64")
+	}
+}
+
+func TestIPTypes(t *testing.T) {
+	t.Parallel()
+
+	tests := map[SourceValue]string{
+		{Type: "ip", Value: "192.0.2.1"}:                       "192.0.2.1",
+		{Type: "ip", Value: "0:0:0:0:0:0:0:1"}:                 "::1",
+		{Type: "ip", Value: "2001:DB8::0001"}:                  "2001:db8::1",
+		{Type: "ip", Value: "::ffff:192.0.2.1"}:                "192.0.2.1",
+		{Type: "array:ip", Value: "::1 0:0:0:0:0:0:0:1"}:       "[::1 ::1]",
+		{Type: "ipbytes", Value: "192.0.2.1"}:                  "c0000201",
+		{Type: "ipbytes", Value: "2001:db8::1"}:                "20010db8000000000000000000000001",
+		{Type: "network", Value: "192.0.2.1/24"}:               "192.0.2.0/24",
+		{Type: "network", Value: "2001:0DB8::/32"}:             "2001:db8::/32",
+		{Type: "network", Value: "::ffff:192.0.2.0/120"}:       "192.0.2.0/24",
+		{Type: "array:network:,", Value: "192.0.2.0/24, ::/0"}: "[192.0.2.0/24 ::/0]",
+	}
+	for sv, expected := range tests {
+		v, err := sv.ToMMDBType(Optimizations{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		format := "%v"
+		if sv.Type == "ipbytes" {
+			format = "%x"
+		}
+		if fmt.Sprintf(format, v) != expected {
+			t.Fatalf("unexpected value for %+v: %v", sv, v)
+		}
+	}
+
+	// Check invalid values.
+	for _, sv := range []SourceValue{
+		{Type: "ip", Value: "192.0.2.256"},
+		{Type: "ip", Value: "192.0.2.0/24"},
+		{Type: "ipbytes", Value: "example.com"},
+		{Type: "network", Value: "192.0.2.0"},
+		{Type: "array:ip", Value: "::1 ::g"},
+	} {
+		if _, err := sv.ToMMDBType(Optimizations{}); err == nil {
+			t.Fatalf("expected error for %+v", sv)
+		}
+	}
+}
+
+func TestDatetimeType(t *testing.T) {
+	t.Parallel()
+
+	tests := []SourceValue{
+		{Type: "datetime", Value: "2023-11-14T22:13:20Z"},
+		{Type: "datetime", Value: "2023-11-15T00:13:20+02:00"},
+		{Type: "datetime:2006-01-02 15:04:05", Value: "2023-11-14 22:13:20"},
+	}
+	for _, sv := range tests {
+		v, err := sv.ToMMDBType(Optimizations{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprintf("%v", v) != "1700000000" {
+			t.Fatalf("unexpected value for %+v: %v", sv, v)
+		}
+	}
+
+	for _, sv := range []SourceValue{
+		{Type: "datetime", Value: "2023-11-14"},
+		{Type: "datetime", Value: "1969-12-31T23:59:59Z"},
+	} {
+		if _, err := sv.ToMMDBType(Optimizations{}); err == nil {
+			t.Fatalf("expected error for %+v", sv)
+		}
+	}
+}
+
+func TestApplyMappings(t *testing.T) {
+	t.Parallel()
+
+	mappings := map[string]Mapping{
+		"continent": {
+			Type: "uint32",
+			Values: map[string]string{
+				"EU": "6255148",
+				"NA": "6255149",
+			},
+			Default: "0",
+		},
+		"strict": {
+			Values: map[string]string{
+				"a": "b",
+			},
+			Default: "c",
+			Strict:  true,
+		},
+	}
+
+	entry := SourceEntry{
+		Values: map[string]SourceValue{
+			"continent.geoname_id": {Type: "map:continent", Value: "EU"},
+			"continents":           {Type: "array:map:continent", Value: "NA XX"},
+			"other":                {Type: "map:strict", Value: "a"},
+		},
+	}
+	if err := entry.ApplyMappings(mappings, Optimizations{}); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Values["continent.geoname_id"] != (SourceValue{Type: "uint32", Value: "6255148"}) {
+		t.Fatalf("unexpected value: %+v", entry.Values["continent.geoname_id"])
+	}
+	if entry.Values["continents"] != (SourceValue{Type: "array:uint32", Value: "6255149 0"}) {
+		t.Fatalf("unexpected value: %+v", entry.Values["continents"])
+	}
+	if entry.Values["other"] != (SourceValue{Type: "string", Value: "b"}) {
+		t.Fatalf("unexpected value: %+v", entry.Values["other"])
+	}
+
+	// Check strict mode.
+	entry = SourceEntry{
+		Values: map[string]SourceValue{
+			"other": {Type: "map:strict", Value: "x"},
+		},
+	}
+	if err := entry.ApplyMappings(mappings, Optimizations{}); err == nil {
+		t.Fatal("expected error for unmapped value in strict mode")
+	}
+}
+
+func TestFieldFloatDecimals(t *testing.T) {
+	t.Parallel()
+
+	entry := &SourceEntry{
+		Values: map[string]SourceValue{
+			"location.latitude": {
+				Type:  "float64",
+				Value: "48.123456",
+			},
+			"location.longitude": {
+				Type:  "float64",
+				Value: "16.222222",
+			},
+			"location.accuracy_radius": {
+				Type:  "float64",
+				Value: "12.75",
+			},
+			"location.unrounded": {
+				Type:  "float64",
+				Value: "1.23456",
+			},
+		},
+	}
+	mmdbMapString := "map[location:map[accuracy_radius:13 latitude:48.1235 longitude:16.22 unrounded:1.23456]]"
+
+	mmdbMap, err := entry.ToMMDBMap(Optimizations{
+		FloatDecimals: 2,
+		FieldFloatDecimals: map[string]int{
+			"location.latitude":        4,
+			"location.accuracy_radius": -1,
+			"location.unrounded":       0,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := fmt.Sprintf("%v", mmdbMap)
+	if s != mmdbMapString {
+		t.Fatalf("mmdb map string not as expected: %s", s)
+	}
+}
+
+func TestRoundingWarning(t *testing.T) {
+	t.Parallel()
+
+	entry := &SourceEntry{
+		Values: map[string]SourceValue{
+			"location.latitude":  {Type: "float64", Value: "48.123456"},
+			"location.longitude": {Type: "float64", Value: "16.220001"},
+			"location.radius":    {Type: "float64", Value: "5"},
+		},
+	}
+	var warnings []string
+	_, err := entry.ToMMDBMap(Optimizations{
+		FloatDecimals: 2,
+		RoundingWarning: func(field string, original, rounded float64) {
+			warnings = append(warnings, fmt.Sprintf("%s %v %v", field, original, rounded))
+		},
+		RoundingEpsilon: 0.0001,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%v", warnings) != "[location.latitude 48.123456 48.12]" {
+		t.Fatalf("unexpected warnings: %v", warnings)
+	}
+
+	// Rounding without warning callback works as before.
+	if _, err := entry.ToMMDBMap(Optimizations{FloatDecimals: 2}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIntTypes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		fieldType string
+		min, max  string
+		// Values just outside of the range.
+		belowMin, aboveMax string
+		// Stored types of min and max.
+		expected string
+	}{
+		{"int8", "-128", "127", "-129", "128", "mmdbtype.Int32 mmdbtype.Int32"},
+		{"int16", "-32768", "32767", "-32769", "32768", "mmdbtype.Int32 mmdbtype.Int32"},
+		{"int32", "-2147483648", "2147483647", "-2147483649", "2147483648", "mmdbtype.Int32 mmdbtype.Int32"},
+		{"int64", "-2147483648", "9223372036854775807", "-2147483649", "9223372036854775808", "mmdbtype.Int32 mmdbtype.Uint64"},
+		{"uint8", "0", "255", "-1", "256", "mmdbtype.Uint16 mmdbtype.Uint16"},
+		{"uint16", "0", "65535", "-1", "65536", "mmdbtype.Uint16 mmdbtype.Uint16"},
+		{"uint32", "0", "4294967295", "-1", "4294967296", "mmdbtype.Uint32 mmdbtype.Uint32"},
+		{"uint64", "0", "18446744073709551615", "-1", "18446744073709551616", "mmdbtype.Uint64 mmdbtype.Uint64"},
+	}
+	for _, test := range tests {
+		var types []string
+		for _, value := range []string{test.min, test.max} {
+			v, err := SourceValue{Type: test.fieldType, Value: value}.ToMMDBType(Optimizations{})
+			if err != nil {
+				t.Fatalf("%s %s: %s", test.fieldType, value, err)
+			}
+			if fmt.Sprintf("%v", v) != value {
+				t.Fatalf("%s: unexpected value for %s: %v", test.fieldType, value, v)
+			}
+			types = append(types, fmt.Sprintf("%T", v))
+		}
+		if strings.Join(types, " ") != test.expected {
+			t.Fatalf("%s: unexpected types %v", test.fieldType, types)
+		}
+
+		for _, value := range []string{test.belowMin, test.aboveMax, "", "1.5"} {
+			if _, err := (SourceValue{Type: test.fieldType, Value: value}).ToMMDBType(Optimizations{}); err == nil {
+				t.Fatalf("%s: expected error for %q", test.fieldType, value)
+			}
+		}
+
+		// Arrays use the same storage types and range checks.
+		arrayType := "array:" + test.fieldType
+		v, err := SourceValue{Type: arrayType, Value: test.min + " " + test.max}.ToMMDBType(Optimizations{})
+		if err != nil {
+			t.Fatalf("%s: %s", arrayType, err)
+		}
+		if types := fmt.Sprintf("%T %T", v.(mmdbtype.Slice)[0], v.(mmdbtype.Slice)[1]); types != test.expected { //nolint:forcetypeassert
+			t.Fatalf("%s: unexpected types %s", arrayType, types)
+		}
+		if _, err := (SourceValue{Type: arrayType, Value: test.min + " " + test.aboveMax}).ToMMDBType(Optimizations{}); err == nil {
+			t.Fatalf("%s: expected error for %q", arrayType, test.aboveMax)
+		}
+	}
+}
+
+func TestScaledIntType(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		fieldType, value, expected string
+	}{
+		{"scaledint:int32:10000", "48.2082", "mmdbtype.Int32 482082"},
+		{"scaledint:int32:10000", "-16.37245", "mmdbtype.Int32 -163725"},
+		{"scaledint:uint16:100", "1.005", "mmdbtype.Uint16 100"},
+		{"scaledint:uint32:0.5", "7", "mmdbtype.Uint32 4"},
+		{"array:scaledint:int32:10", "1.24 -3.15", "mmdbtype.Slice [12 -32]"},
+	}
+	for _, test := range tests {
+		v, err := SourceValue{Type: test.fieldType, Value: test.value}.ToMMDBType(Optimizations{})
+		if err != nil {
+			t.Fatalf("%s %s: %s", test.fieldType, test.value, err)
+		}
+		if result := fmt.Sprintf("%T %v", v, v); result != test.expected {
+			t.Fatalf("%s: unexpected value for %s: %s", test.fieldType, test.value, result)
+		}
+	}
+
+	// Invalid types, values and values out of range fail.
+	for fieldType, value := range map[string]string{
+		"scaledint:float32:100": "1",
+		"scaledint:int32":       "1",
+		"scaledint:int32:-10":   "1",
+		"scaledint:int32:100":   "x",
+		"scaledint:int16:10000": "48.2082",
+		"scaledint:uint32:10":   "-1",
+	} {
+		if _, err := (SourceValue{Type: fieldType, Value: value}).ToMMDBType(Optimizations{}); err == nil {
+			t.Fatalf("%s: expected error for %q", fieldType, value)
+		}
+	}
+
+	// Overflows are handled by the overflow mode.
+	v, err := SourceValue{Type: "scaledint:int16:10000", Value: "48.2082"}.ToMMDBType(Optimizations{OverflowMode: OverflowModeClamp})
+	if err != nil || fmt.Sprintf("%v", v) != "32767" {
+		t.Fatalf("unexpected clamped value: %v, %v", v, err)
+	}
+}
+
+func TestDurationType(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		fieldType, value, expected string
+	}{
+		{"duration", "1h30m", "mmdbtype.Uint32 5400"},
+		{"duration", "1500ms", "mmdbtype.Uint32 2"},
+		{"duration", "0s", "mmdbtype.Uint32 0"},
+		{"duration", "1193046h", "mmdbtype.Uint32 4294965600"},
+		{"array:duration", "1m 2h", "mmdbtype.Slice [60 7200]"},
+		{"array:datetime", "2024-01-01T00:00:00Z 2024-01-02T00:00:00Z", "mmdbtype.Slice [1704067200 1704153600]"},
+	}
+	for _, test := range tests {
+		v, err := SourceValue{Type: test.fieldType, Value: test.value}.ToMMDBType(Optimizations{})
+		if err != nil {
+			t.Fatalf("%s %s: %s", test.fieldType, test.value, err)
+		}
+		if result := fmt.Sprintf("%T %v", v, v); result != test.expected {
+			t.Fatalf("%s: unexpected value for %s: %s", test.fieldType, test.value, result)
+		}
+	}
+
+	// Invalid durations and durations out of range fail.
+	for _, value := range []string{"", "1", "1d", "-1s", "1193047h"} {
+		if _, err := (SourceValue{Type: "duration", Value: value}).ToMMDBType(Optimizations{}); err == nil {
+			t.Fatalf("expected error for %q", value)
+		}
+	}
+
+	// Overflows are handled by the overflow mode.
+	v, err := SourceValue{Type: "duration", Value: "-1s"}.ToMMDBType(Optimizations{OverflowMode: OverflowModeClamp})
+	if err != nil || fmt.Sprintf("%v", v) != "0" {
+		t.Fatalf("unexpected clamped value: %v, %v", v, err)
+	}
+}
+
+func TestNormalizeStrings(t *testing.T) {
+	t.Parallel()
+
+	values := map[string]SourceValue{
+		"country.iso_code": {Type: "string", Value: " at "},
+		"city.names.en":    {Type: "string", Value: " Vienna"},
+		"tags":             {Type: "array:string:,", Value: "a,B"},
+	}
+	optim := Optimizations{NormalizeStrings: []string{NormalizeTrim, NormalizeUpper}}
+	m, err := BuildNestedMap(values, optim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := fmt.Sprintf("%v", m); result != "map[city:map[names:map[en:VIENNA]] country:map[iso_code:AT] tags:[A B]]" {
+		t.Fatalf("unexpected map: %s", result)
+	}
+
+	// Only the listed fields are normalized, before they are interned.
+	optim.NormalizeFields = []string{"country.iso_code"}
+	optim.Intern = []string{"country.iso_code"}
+	optim.interner = newValueInterner()
+	for _, value := range []string{"AT", " at"} {
+		values["country.iso_code"] = SourceValue{Type: "string", Value: value}
+		m, err = BuildNestedMap(values, optim)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if result := fmt.Sprintf("%v", m); result != "map[city:map[names:map[en: Vienna]] country:map[iso_code:AT] tags:[a B]]" {
+		t.Fatalf("unexpected map: %s", result)
+	}
+	if lookups, hits := optim.interner.stats(); lookups != 2 || hits != 1 {
+		t.Fatalf("unexpected intern stats: %d lookups, %d hits", lookups, hits)
+	}
+
+	// Unknown normalizations are invalid.
+	if err := (Optimizations{NormalizeStrings: []string{"title"}}).Validate(); err == nil {
+		t.Fatal("expected error for unknown normalization")
+	}
+}
+
+func TestConversionError(t *testing.T) {
+	t.Parallel()
+
+	entry := SourceEntry{
+		Values: map[string]SourceValue{
+			"autonomous_system_number": {Type: "uint32", Value: "x"},
+			"country.iso_code":         {Type: "string", Value: "AT"},
+			"location.latitude":        {Type: "float32", Value: "north"},
+		},
+		Line: 7,
+	}
+
+	// The first failing key is returned.
+	_, err := entry.ToMMDBMap(Optimizations{})
+	var convErr *ConversionError
+	if !errors.As(err, &convErr) {
+		t.Fatalf("expected conversion error, got %v", err)
+	}
+	if convErr.Key != "autonomous_system_number" || convErr.Type != "uint32" || convErr.Value != "x" || convErr.Line != 7 {
+		t.Fatalf("unexpected conversion error: %+v", convErr)
+	}
+	if !errors.Is(err, strconv.ErrSyntax) {
+		t.Fatalf("expected cause to be unwrapped, got %v", err)
+	}
+	if err.Error() != `failed to transform autonomous_system_number with value x (of type uint32): strconv.ParseUint: parsing "x": invalid syntax` {
+		t.Fatalf("unexpected error message: %s", err)
+	}
+
+	// All failing keys are collected.
+	_, errs := entry.ToMMDBMapCollect(Optimizations{})
+	var types []string
+	for _, err := range errs {
+		if errors.As(err, &convErr) {
+			types = append(types, convErr.Type)
+		}
+	}
+	if fmt.Sprintf("%v", types) != "[uint32 float32]" {
+		t.Fatalf("unexpected conversion errors: %v", errs)
+	}
+
+	// Values without key do not mention it.
+	_, err = SourceValue{Type: "array:uint16", Value: "1 70000"}.ToMMDBType(Optimizations{})
+	if err == nil || !strings.HasPrefix(err.Error(), "failed to transform value 1 70000 (of type array:uint16): array entry #1") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestRatioTypes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		fieldType, value, expected string
+	}{
+		{"percent", "42.5", "mmdbtype.Float32 0.425"},
+		{"percent", "0", "mmdbtype.Float32 0"},
+		{"percent", "100", "mmdbtype.Float32 1"},
+		{"permille", "5", "mmdbtype.Float32 0.005"},
+		{"array:percent", "10 20", "mmdbtype.Slice [0.1 0.2]"},
+	}
+	for _, test := range tests {
+		v, err := SourceValue{Type: test.fieldType, Value: test.value}.ToMMDBType(Optimizations{})
+		if err != nil {
+			t.Fatalf("%s %s: %s", test.fieldType, test.value, err)
+		}
+		if result := fmt.Sprintf("%T %v", v, v); result != test.expected {
+			t.Fatalf("%s: unexpected value for %s: %s", test.fieldType, test.value, result)
+		}
+	}
+
+	// Ratios are rounded like floats.
+	v, err := SourceValue{Type: "percent", Value: "33.333"}.ToMMDBType(Optimizations{FloatDecimals: 2})
+	if err != nil || fmt.Sprintf("%v", v) != "0.33" {
+		t.Fatalf("unexpected rounded value: %v, %v", v, err)
+	}
+
+	// Invalid values and values out of range fail.
+	for _, value := range []string{"", "x", "NaN", "-1", "100.1"} {
+		if _, err := (SourceValue{Type: "percent", Value: value}).ToMMDBType(Optimizations{}); err == nil {
+			t.Fatalf("expected error for %q", value)
+		}
+	}
+	if _, err := (SourceValue{Type: "permille", Value: "1001"}).ToMMDBType(Optimizations{}); err == nil {
+		t.Fatal("expected error for permille out of range")
+	}
+
+	// Values out of range are handled by the overflow mode.
+	v, err = SourceValue{Type: "percent", Value: "120"}.ToMMDBType(Optimizations{OverflowMode: OverflowModeClamp})
+	if err != nil || fmt.Sprintf("%v", v) != "1" {
+		t.Fatalf("unexpected clamped value: %v, %v", v, err)
+	}
+	v, err = SourceValue{Type: "percent", Value: "-5"}.ToMMDBType(Optimizations{OverflowMode: OverflowModeSkip})
+	if err != nil || v != nil {
+		t.Fatalf("unexpected skipped value: %v, %v", v, err)
+	}
+}
+
+func TestCoordinateTypes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		fieldType, value, expected string
+	}{
+		{"latitude", "48.2082", "mmdbtype.Float64 48.2082"},
+		{"latitude", "90", "mmdbtype.Float64 90"},
+		{"latitude", "-90", "mmdbtype.Float64 -90"},
+		{"longitude", "180", "mmdbtype.Float64 180"},
+		{"longitude", "-180", "mmdbtype.Float64 -180"},
+		{"longitude", "-120.5", "mmdbtype.Float64 -120.5"},
+	}
+	for _, test := range tests {
+		v, err := SourceValue{Type: test.fieldType, Value: test.value}.ToMMDBType(Optimizations{})
+		if err != nil {
+			t.Fatalf("%s %s: %s", test.fieldType, test.value, err)
+		}
+		if result := fmt.Sprintf("%T %v", v, v); result != test.expected {
+			t.Fatalf("%s: unexpected value for %s: %s", test.fieldType, test.value, result)
+		}
+	}
+
+	// Invalid values and values out of range fail.
+	for _, test := range []struct{ fieldType, value string }{
+		{"latitude", ""},
+		{"latitude", "NaN"},
+		{"latitude", "90.0001"},
+		{"latitude", "-90.0001"},
+		{"longitude", "180.0001"},
+		{"longitude", "-180.0001"},
+	} {
+		if _, err := (SourceValue{Type: test.fieldType, Value: test.value}).ToMMDBType(Optimizations{}); err == nil {
+			t.Fatalf("expected error for %s %q", test.fieldType, test.value)
+		}
+	}
+
+	// Values out of range are handled by the overflow mode.
+	v, err := SourceValue{Type: "longitude", Value: "-200"}.ToMMDBType(Optimizations{OverflowMode: OverflowModeClamp})
+	if err != nil || fmt.Sprintf("%v", v) != "-180" {
+		t.Fatalf("unexpected clamped value: %v, %v", v, err)
+	}
+	v, err = SourceValue{Type: "latitude", Value: "95"}.ToMMDBType(Optimizations{OverflowMode: OverflowModeSkip})
+	if err != nil || v != nil {
+		t.Fatalf("unexpected skipped value: %v, %v", v, err)
+	}
+}
+
+func TestLenientNumbers(t *testing.T) {
+	t.Parallel()
+
+	lenient := Optimizations{LenientNumbers: true}
+	tests := []struct {
+		fieldType, value, expected string
+	}{
+		{"uint32", "+1_000", "mmdbtype.Uint32 1000"},
+		{"int32", "1_000_000", "mmdbtype.Int32 1000000"},
+		{"int32", "-1_000", "mmdbtype.Int32 -1000"},
+		{"float64", "+1_000.5", "mmdbtype.Float64 1000.5"},
+		{"scaledint:int32:10", "+1_000.5", "mmdbtype.Int32 10005"},
+	}
+	for _, test := range tests {
+		v, err := SourceValue{Type: test.fieldType, Value: test.value}.ToMMDBType(lenient)
+		if err != nil {
+			t.Fatalf("%s %s: %s", test.fieldType, test.value, err)
+		}
+		if result := fmt.Sprintf("%T %v", v, v); result != test.expected {
+			t.Fatalf("%s: unexpected value for %s: %s", test.fieldType, test.value, result)
+		}
+	}
+
+	v, err := SourceValue{Type: "uint128", Value: "+1_000"}.ToMMDBType(lenient)
+	if u, ok := v.(*mmdbtype.Uint128); err != nil || !ok || (*big.Int)(u).String() != "1000" {
+		t.Fatalf("unexpected uint128 value: %v, %v", v, err)
+	}
+
+	// Integers are parsed strictly by default.
+	for fieldType, value := range map[string]string{
+		"uint32":  "+1_000",
+		"int32":   "1_000_000",
+		"uint128": "1_000",
+	} {
+		if _, err := (SourceValue{Type: fieldType, Value: value}).ToMMDBType(Optimizations{}); err == nil {
+			t.Fatalf("%s: expected strict error for %q", fieldType, value)
+		}
+	}
+
+	// Misplaced signs and separators fail even when lenient.
+	for _, value := range []string{"++1", "+-1", "_1", "1_", "1__0", "1_.5"} {
+		if _, err := (SourceValue{Type: "float64", Value: value}).ToMMDBType(lenient); err == nil {
+			t.Fatalf("expected error for %q", value)
+		}
+	}
+}
+
+func TestShrinkInts(t *testing.T) {
+	t.Parallel()
+
+	optim := Optimizations{ShrinkInts: true}
+	tests := map[SourceValue]string{
+		{Type: "uint32", Value: "65535"}:                    "mmdbtype.Uint16",
+		{Type: "uint32", Value: "65536"}:                    "mmdbtype.Uint32",
+		{Type: "uint64", Value: "12345"}:                    "mmdbtype.Uint16",
+		{Type: "uint64", Value: "4294967296"}:               "mmdbtype.Uint64",
+		{Type: "array:uint64", Value: "1 4294967295"}:       "mmdbtype.Slice[mmdbtype.Uint16 mmdbtype.Uint32]",
+		{Type: "array:uint32", Value: "70000 1 4294967295"}: "mmdbtype.Slice[mmdbtype.Uint32 mmdbtype.Uint16 mmdbtype.Uint32]",
+	}
+	for sv, expected := range tests {
+		v, err := sv.ToMMDBType(optim)
+		if err != nil {
+			t.Fatal(err)
+		}
+		typeString := fmt.Sprintf("%T", v)
+		if slice, ok := v.(mmdbtype.Slice); ok {
+			types := make([]string, 0, len(slice))
+			for _, entry := range slice {
+				types = append(types, fmt.Sprintf("%T", entry))
+			}
+			typeString += fmt.Sprintf("%v", types)
+		}
+		if typeString != expected {
+			t.Fatalf("unexpected type for %+v: %s", sv, typeString)
+		}
+	}
+
+	// Values exceeding the declared type must still fail.
+	if _, err := (SourceValue{Type: "uint32", Value: "4294967296"}).ToMMDBType(optim); err == nil {
+		t.Fatal("expected error for value exceeding uint32")
+	}
+}
+
+func TestOverflowMode(t *testing.T) {
+	t.Parallel()
+
+	values := []SourceValue{
+		{Type: "int32", Value: "2147483648"},
+		{Type: "int32", Value: "-2147483649"},
+		{Type: "int64", Value: "-2147483649"},
+		{Type: "int64", Value: "9223372036854775808"},
+		{Type: "uint16", Value: "65536"},
+		{Type: "uint16", Value: "-1"},
+		{Type: "uint32", Value: "4294967296"},
+		{Type: "uint64", Value: "18446744073709551616"},
+	}
+
+	// Clamp values to the limits of their type.
+	clamped := make([]string, 0, len(values))
+	optim := Optimizations{OverflowMode: OverflowModeClamp}
+	for _, sv := range values {
+		v, err := sv.ToMMDBType(optim)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clamped = append(clamped, fmt.Sprintf("%v", v))
+	}
+	expected := "[2147483647 -2147483648 -2147483648 9223372036854775807 65535 0 4294967295 18446744073709551615]"
+	if fmt.Sprintf("%v", clamped) != expected {
+		t.Fatalf("unexpected clamped values: %v", clamped)
+	}
+
+	// Skip values, but keep other values.
+	optim = Optimizations{OverflowMode: OverflowModeSkip}
+	for _, sv := range values {
+		v, err := sv.ToMMDBType(optim)
+		if err != nil || v != nil {
+			t.Fatalf("expected %+v to be skipped, got %v, %v", sv, v, err)
+		}
+	}
+	v, err := SourceValue{Type: "array:uint16", Value: "1 65536 2"}.ToMMDBType(optim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%v", v) != "[1 2]" {
+		t.Fatalf("unexpected array: %v", v)
+	}
+	m, err := (&SourceEntry{Values: map[string]SourceValue{
+		"asn":  {Type: "uint16", Value: "65536"},
+		"name": {Type: "string", Value: "test"},
+	}}).ToMMDBMap(optim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%v", m) != "map[name:test]" {
+		t.Fatalf("unexpected map: %v", m)
+	}
+
+	// Invalid values still fail.
+	if _, err := (SourceValue{Type: "uint16", Value: "abc"}).ToMMDBType(optim); err == nil {
+		t.Fatal("expected error for invalid value")
+	}
+
+	// The default mode fails on overflows.
+	for _, sv := range values {
+		if _, err := sv.ToMMDBType(Optimizations{}); err == nil {
+			t.Fatalf("expected error for %+v", sv)
+		}
+	}
+	if err := (Optimizations{OverflowMode: "wrap"}).Validate(); err == nil {
+		t.Fatal("expected error for unknown overflow mode")
+	}
+}
+
+func TestSourceEntryNetworks(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		from     string
+		to       string
+		expected string
+	}{
+		{"192.0.2.0", "192.0.2.255", "[192.0.2.0/24]"},
+		{"192.0.2.1", "192.0.2.6", "[192.0.2.1/32 192.0.2.2/31 192.0.2.4/31 192.0.2.6/32]"},
+		{"2001:db8::", "2001:db8::1:ffff", "[2001:db8::/111]"},
+	}
+	for _, test := range tests {
+		se := SourceEntry{
+			From: net.ParseIP(test.from).To4(),
+			To:   net.ParseIP(test.to).To4(),
+		}
+		if se.From == nil {
+			se.From = net.ParseIP(test.from)
+			se.To = net.ParseIP(test.to)
+		}
+		networks, err := se.Networks()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := fmt.Sprintf("%v", networks); s != test.expected {
+			t.Fatalf("unexpected networks for %s - %s: %s", test.from, test.to, s)
+		}
+	}
+
+	// Check network.
+	_, ipNet, _ := net.ParseCIDR("198.51.100.0/24")
+	networks, err := SourceEntry{Net: ipNet}.Networks()
+	if err != nil || len(networks) != 1 || networks[0] != ipNet {
+		t.Fatalf("unexpected networks: %v, %v", networks, err)
+	}
+
+	// Check invalid ranges.
+	for _, se := range []SourceEntry{
+		{From: net.ParseIP("192.0.2.255").To4(), To: net.ParseIP("192.0.2.0").To4()},
+		{From: net.ParseIP("192.0.2.0").To4(), To: net.ParseIP("2001:db8::")},
+		{From: net.ParseIP("192.0.2.0").To4()},
+	} {
+		if _, err := se.Networks(); err == nil {
+			t.Fatalf("expected error for range %s - %s", se.From, se.To)
+		}
+	}
+}
+
+func TestNextEntryContext(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "test.csv")
+	if err := os.WriteFile(file, []byte("192.0.2.0,192.0.2.255,AT\n198.51.100.0,198.51.100.255,DE\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	source, err := LoadCSVSource(DatabaseInput{
+		File:   file,
+		Fields: []string{"from", "to", "country.iso_code"},
+	}, map[string]string{"country.iso_code": "string"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	entry, err := source.NextEntryContext(ctx)
+	if err != nil || entry == nil {
+		t.Fatalf("expected entry, got %+v, %v", entry, err)
+	}
+
+	// Cancel and check that reading stops.
+	cancel()
+	entry, err = source.NextEntryContext(ctx)
+	if err != nil || entry != nil {
+		t.Fatalf("expected end of source, got %+v, %v", entry, err)
+	}
+	if !errors.Is(source.Err(), context.Canceled) {
+		t.Fatalf("expected context error, got %v", source.Err())
+	}
+}
+
+func TestSourceClose(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "test.csv")
+	if err := os.WriteFile(file, []byte("192.0.2.0,192.0.2.255,AT\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	source, err := LoadCSVSource(DatabaseInput{
+		File:   file,
+		Fields: []string{"from", "to", "country.iso_code"},
+	}, map[string]string{"country.iso_code": "string"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Closing multiple times is fine, and reading stops after closing.
+	if err := source.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if entry, _ := source.NextEntry(); entry != nil {
+		t.Fatalf("unexpected entry after close: %+v", entry)
+	}
+	if err := source.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadSourcesCloseOnError(t *testing.T) {
+	t.Parallel()
+
+	// Sources loaded before an input fails are closed.
+	loaded := newTestSource("good", "192.0.2.0/24")
+	loaders := map[string]SourceLoader{
+		"test": func(DatabaseInput, map[string]string) (Source, error) {
+			return loaded, nil
+		},
+	}
+	_, err := loadSources(context.Background(), DatabaseConfig{
+		Types: map[string]string{"source": "string"},
+		Inputs: []DatabaseInput{
+			{File: "good", Format: "test"},
+			{File: filepath.Join(t.TempDir(), "missing.csv")},
+		},
+	}, loaders)
+	if err == nil {
+		t.Fatal("expected error for missing input")
+	}
+	if !loaded.closed {
+		t.Fatal("expected loaded source to be closed")
+	}
+}
+
+func TestToMMDBMapCollect(t *testing.T) {
+	t.Parallel()
+
+	entry := &SourceEntry{
+		Values: map[string]SourceValue{
+			"country.iso_code":         {Type: "string", Value: "AT"},
+			"autonomous_system_number": {Type: "uint32", Value: "AS12345"},
+			"location.latitude":        {Type: "float32", Value: "north"},
+			"location.longitude":       {Type: "float32", Value: "16.22"},
+		},
+	}
+
+	if _, err := entry.ToMMDBMap(Optimizations{}); err == nil {
+		t.Fatal("expected error")
+	}
+
+	mmdbMap, errs := entry.ToMMDBMapCollect(Optimizations{})
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", errs)
+	}
+	if s := fmt.Sprintf("%v", mmdbMap); s != "map[country:map[iso_code:AT] location:map[longitude:16.22]]" {
+		t.Fatalf("unexpected map: %s", s)
+	}
+}
+
+func TestCSVHeader(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "test.csv")
+	if err := os.WriteFile(file, []byte("from, to ,country.iso_code\n192.0.2.0,192.0.2.255,AT\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	types := map[string]string{"country.iso_code": "string", "country_code": "string"}
+
+	tests := []struct {
+		fields   []string
+		expected string
+	}{
+		{nil, "country.iso_code"},
+		{[]string{"from", "to", "country_code"}, "country_code"},
+	}
+	for _, test := range tests {
+		source, err := LoadCSVSource(DatabaseInput{
+			File:      file,
+			Fields:    test.fields,
+			HasHeader: true,
+		}, types)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry, err := source.NextEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil || entry.Values[test.expected].Value != "AT" || entry.To.String() != "192.0.2.255" {
+			t.Fatalf("unexpected entry: %+v", entry)
+		}
+	}
+
+	// Fields are required without header.
+	if _, err := LoadCSVSource(DatabaseInput{File: file}, types); err == nil {
+		t.Fatal("expected error without fields and header")
+	}
+}
+
+func TestCSVColumnTypes(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "test.csv")
+	if err := os.WriteFile(file, []byte("from,to,Country Code,ASN\n192.0.2.0,192.0.2.255,AT,64496\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Positional types mix with named types, and take precedence.
+	types := map[string]string{"$4": "uint32", "Country Code": "string", "ASN": "bool"}
+	source, err := LoadCSVSource(DatabaseInput{File: file, HasHeader: true}, types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := source.NextEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil || fmt.Sprintf("%v", entry.Values) != "map[ASN:{uint32 64496} Country Code:{string AT}]" {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+
+	// Invalid columns fail when loading.
+	for _, key := range []string{"$0", "$5", "$1", "$99999999999999999999"} {
+		if _, err := LoadCSVSource(DatabaseInput{File: file, HasHeader: true}, map[string]string{key: "string"}); err == nil {
+			t.Fatalf("expected error for column %s", key)
+		}
+	}
+}
+
+func TestCSVArrayFields(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "test.csv")
+	data := "from,to,lang1,lang2,lang3\n192.0.2.0,192.0.2.255,de, ,en\n192.0.3.0,192.0.3.255,,,\n192.0.4.0,192.0.4.255,fr,it a,\n"
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	input := DatabaseInput{
+		File:      file,
+		HasHeader: true,
+		FieldMap: map[string]string{
+			"lang1": "languages[]",
+			"lang2": "languages[]",
+			"lang3": "languages[]",
+		},
+	}
+	source, err := LoadCSVSource(input, map[string]string{"languages": "array:string"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Columns are collected in field order, empty cells are skipped.
+	var records []string
+	for {
+		entry, err := source.NextEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil {
+			break
+		}
+		m, err := entry.ToMMDBMap(Optimizations{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, fmt.Sprintf("%v", m))
+	}
+	if fmt.Sprintf("%v", records) != "[map[languages:[de en]] map[] map[languages:[fr it a]]]" {
+		t.Fatalf("unexpected records: %v", records)
+	}
+
+	// Collected fields need an array type and must not conflict.
+	for _, test := range []struct {
+		fieldType string
+		fieldMap  map[string]string
+	}{
+		{"string", input.FieldMap},
+		{"array:scaledint:int32:10", input.FieldMap},
+		{"array:datetime", input.FieldMap},
+		{"array:string", map[string]string{"lang1": "languages", "lang2": "languages[]"}},
+	} {
+		input.FieldMap = test.fieldMap
+		if _, err := LoadCSVSource(input, map[string]string{"languages": test.fieldType}); err == nil {
+			t.Fatalf("expected error for %s with %v", test.fieldType, test.fieldMap)
+		}
+	}
+}
+
+func TestCSVSkipRows(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "test.csv")
+	data := "Vendor export, generated 2024-01-01\n\"quoted, \"\"metadata\"\"\"\nfrom,to,country.iso_code\n192.0.2.0,192.0.2.255,AT\n"
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	types := map[string]string{"country.iso_code": "string"}
+
+	source, err := LoadCSVSource(DatabaseInput{
+		File:      file,
+		SkipRows:  2,
+		HasHeader: true,
+	}, types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := source.NextEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil || entry.Values["country.iso_code"].Value != "AT" || entry.Line != 4 {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+
+	// Skipping all lines fails.
+	for _, skipRows := range []int{4, 5, -1} {
+		if _, err := LoadCSVSource(DatabaseInput{
+			File:      file,
+			SkipRows:  skipRows,
+			HasHeader: true,
+		}, types); err == nil {
+			t.Fatalf("expected error for skipping %d rows", skipRows)
+		}
+	}
+}
+
+func TestEstimateCount(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	csvFile := filepath.Join(dir, "test.csv")
+	data := "exported 2024-01-01\nfrom,to,country.iso_code\n192.0.2.0,192.0.2.255,AT\n198.51.100.0,198.51.100.255,DE\n"
+	if err := os.WriteFile(csvFile, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	jsonlFile := filepath.Join(dir, "test.jsonl.gz")
+	buf := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(buf)
+	_, _ = gzipWriter.Write([]byte(`{"network":"192.0.2.0/24"}` + "\n" + `{"network":"198.51.100.0/24"}` + "\n" + `{"network":"203.0.113.0/24"}`))
+	_ = gzipWriter.Close()
+	if err := os.WriteFile(jsonlFile, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	types := map[string]string{"country.iso_code": "string"}
+
+	csvSource, err := LoadCSVSource(DatabaseInput{File: csvFile, SkipRows: 1, HasHeader: true}, types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonlSource, err := LoadJSONLinesSource(DatabaseInput{File: jsonlFile}, types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for source, expected := range map[interface{ EstimateCount() (int, bool) }]int{
+		csvSource:   2,
+		jsonlSource: 3,
+	} {
+		count, ok := source.EstimateCount()
+		if !ok || count != expected {
+			t.Fatalf("unexpected estimate of %T: %d, %v", source, count, ok)
+		}
+	}
+
+	// Estimating does not affect reading.
+	entry, err := csvSource.NextEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil || entry.Values["country.iso_code"].Value != "AT" {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+
+	// Remote inputs are not estimated.
+	if _, ok := newLineEstimate(DatabaseInput{File: "https://example.com/test.csv"}, 0).EstimateCount(); ok {
+		t.Fatal("expected no estimate for remote input")
+	}
+}
+
+func TestCSVByteOrderMark(t *testing.T) {
+	t.Parallel()
+
+	text := "from,to,country.iso_code,name\r\n192.0.2.0,192.0.2.255,AT,Café 😀\r\n"
+	encodeUTF16 := func(bom []byte, order binary.AppendByteOrder) []byte {
+		data := bom
+		for _, unit := range utf16.Encode([]rune(text)) {
+			data = order.AppendUint16(data, unit)
+		}
+		return data
+	}
+	tests := map[string][]byte{
+		"utf-8":    append([]byte{0xEF, 0xBB, 0xBF}, text...),
+		"utf-16le": encodeUTF16([]byte{0xFF, 0xFE}, binary.LittleEndian),
+		"utf-16be": encodeUTF16([]byte{0xFE, 0xFF}, binary.BigEndian),
+		"none":     []byte(text),
+	}
+	types := map[string]string{"country.iso_code": "string", "name": "string"}
+	for name, data := range tests {
+		file := filepath.Join(t.TempDir(), "test.csv")
+		if err := os.WriteFile(file, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		source, err := LoadCSVSource(DatabaseInput{File: file, HasHeader: true}, types)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if fields := source.FieldNames(); fields[0] != "from" {
+			t.Fatalf("%s: unexpected fields %q", name, fields)
+		}
+		entry, err := source.NextEntry()
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if entry == nil || entry.From.String() != "192.0.2.0" || entry.Values["name"].Value != "Café 😀" {
+			t.Fatalf("%s: unexpected entry: %+v", name, entry)
+		}
+	}
+
+	// Truncated UTF-16 data fails.
+	file := filepath.Join(t.TempDir(), "test.csv")
+	data := encodeUTF16([]byte{0xFF, 0xFE}, binary.LittleEndian)
+	if err := os.WriteFile(file, data[:len(data)-1], 0o600); err != nil {
+		t.Fatal(err)
+	}
+	source, err := LoadCSVSource(DatabaseInput{File: file, HasHeader: true}, types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		entry, _ := source.NextEntry()
+		if entry == nil {
+			break
+		}
+	}
+	if source.Err() == nil {
+		t.Fatal("expected error for truncated utf-16 data")
+	}
+}
+
+func TestCSVDelimiter(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "test.csv")
+	if err := os.WriteFile(file, []byte("# comment\n192.0.2.0;192.0.2.255;AT\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	types := map[string]string{"country.iso_code": "string"}
+	input := DatabaseInput{
+		File:      file,
+		Fields:    []string{"from", "to", "country.iso_code"},
+		Delimiter: ";",
+		Comment:   "#",
+	}
+
+	source, err := LoadCSVSource(input, types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := source.NextEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil || entry.Values["country.iso_code"].Value != "AT" {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+
+	// Only single characters are allowed.
+	for _, delimiter := range []string{";;", "\xff"} {
+		input.Delimiter = delimiter
+		if _, err := LoadCSVSource(input, types); err == nil {
+			t.Fatalf("expected error for delimiter %q", delimiter)
+		}
+	}
+}
+
+func TestTSVSource(t *testing.T) {
+	t.Parallel()
+
+	// Commas are part of fields, and quoted fields may contain tabs.
+	file := filepath.Join(t.TempDir(), "test.tsv")
+	data := "192.0.2.0\t192.0.2.255\tVienna, Austria\n198.51.100.0\t198.51.100.255\t\"New\tYork\"\n"
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sources, err := LoadSources(DatabaseConfig{
+		Types: map[string]string{"city": "string"},
+		Inputs: []DatabaseInput{{
+			File:   file,
+			Fields: []string{"from", "to", "city"},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := sources[0]
+	var cities []string
+	for {
+		entry, err := source.NextEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil {
+			break
+		}
+		cities = append(cities, entry.Values["city"].Value)
+	}
+	if source.Err() != nil {
+		t.Fatal(source.Err())
+	}
+	if fmt.Sprintf("%q", cities) != `["Vienna, Austria" "New\tYork"]` {
+		t.Fatalf("unexpected cities: %q", cities)
+	}
+}
+
+func TestCSVLazyQuotes(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "test.csv")
+	if err := os.WriteFile(file, []byte("192.0.2.0, 192.0.2.255, 5\" display\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	types := map[string]string{"name": "string"}
+	input := DatabaseInput{
+		File:   file,
+		Fields: []string{"from", "to", "name"},
+	}
+
+	// Strict parsing fails by default.
+	source, err := LoadCSVSource(input, types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, _ := source.NextEntry(); entry != nil || source.Err() == nil {
+		t.Fatalf("expected error for bare quote, got %+v", entry)
+	}
+
+	input.LazyQuotes = true
+	input.TrimLeadingSpace = true
+	source, err = LoadCSVSource(input, types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := source.NextEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil || entry.Values["name"].Value != "5\" display" || entry.To.String() != "192.0.2.255" {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+}
+
+func TestCSVRaggedRows(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "test.csv")
+	data := "from,to,country,city\n192.0.2.0,192.0.2.255,AT,Vienna\n198.51.100.0,198.51.100.255,DE\n203.0.113.0,203.0.113.255,CH,Zurich,extra\n"
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	types := map[string]string{"country": "string", "city": "string"}
+	input := DatabaseInput{
+		File:      file,
+		HasHeader: true,
+		Defaults:  map[string]string{"city": "Unknown"},
+	}
+
+	// Ragged rows fail by default.
+	source, err := LoadCSVSource(input, types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, err := source.NextEntry(); err != nil || entry == nil {
+		t.Fatalf("unexpected entry: %+v, %v", entry, err)
+	}
+	if entry, _ := source.NextEntry(); entry != nil || source.Err() == nil {
+		t.Fatalf("expected error for ragged row, got %+v", entry)
+	}
+
+	// Ragged rows fail the build by default.
+	dbConfig := DatabaseConfig{
+		Name:   "Test",
+		Output: filepath.Join(t.TempDir(), "test.mmdb"),
+		MMDB:   MMDBConfig{IPVersion: 4},
+		Types:  types,
+		Inputs: []DatabaseInput{input},
+	}
+	sources, err := LoadSources(dbConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteMMDB(dbConfig, sources, nil); err == nil || !strings.Contains(err.Error(), "wrong number of fields") {
+		t.Fatalf("expected build to fail, got %v", err)
+	}
+
+	// Ragged rows are skipped like other invalid rows, if enabled.
+	input.OnError = OnErrorSkip
+	source, err = LoadCSVSource(input, types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var countries []string
+	for {
+		entry, err := source.NextEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil {
+			break
+		}
+		countries = append(countries, entry.Values["country"].Value)
+	}
+	if source.Err() != nil {
+		t.Fatal(source.Err())
+	}
+	if fmt.Sprintf("%v", countries) != "[AT]" || source.Skipped() != 2 {
+		t.Fatalf("unexpected rows: %v (%d skipped)", countries, source.Skipped())
+	}
+	input.OnError = ""
+
+	input.AllowRaggedRows = true
+	source, err = LoadCSVSource(input, types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows []string
+	for {
+		entry, err := source.NextEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil {
+			break
+		}
+		rows = append(rows, entry.Values["country"].Value+":"+entry.Values["city"].Value)
+	}
+	if source.Err() != nil {
+		t.Fatal(source.Err())
+	}
+	if fmt.Sprintf("%v", rows) != "[AT:Vienna DE:Unknown CH:Zurich]" || source.RaggedRows() != 2 {
+		t.Fatalf("unexpected rows: %v (%d ragged)", rows, source.RaggedRows())
+	}
+}
+
+func TestArraySeparator(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		sv       SourceValue
+		optim    Optimizations
+		expected string
+	}{
+		{SourceValue{Type: "array:string", Value: " a  b "}, Optimizations{}, "[a b]"},
+		{SourceValue{Type: "array:string:,", Value: "a b, ,c ,"}, Optimizations{}, "[a b c]"},
+		{SourceValue{Type: "array:string::", Value: "a:b"}, Optimizations{}, "[a b]"},
+		{SourceValue{Type: "array:uint32", Value: "1| 2"}, Optimizations{ArraySeparator: "|"}, "[1 2]"},
+		{SourceValue{Type: "array:uint32: ", Value: "1 2"}, Optimizations{ArraySeparator: "|"}, "[1 2]"},
+	}
+	for _, test := range tests {
+		v, err := test.sv.ToMMDBType(test.optim)
+		if err != nil {
+			t.Fatalf("failed to convert %+v: %s", test.sv, err)
+		}
+		if fmt.Sprintf("%v", v) != test.expected {
+			t.Fatalf("unexpected value for %+v: %v", test.sv, v)
+		}
+	}
+
+	// Check mapped arrays.
+	mappings := map[string]Mapping{
+		"names": {
+			Values: map[string]string{"a": "Name A", "b": "Name B"},
+		},
+	}
+	entry := SourceEntry{
+		Values: map[string]SourceValue{
+			"names": {Type: "array:map:names:,", Value: "a, b"},
+		},
+	}
+	if err := entry.ApplyMappings(mappings, Optimizations{}); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Values["names"] != (SourceValue{Type: "array:string:,", Value: "Name A,Name B"}) {
+		t.Fatalf("unexpected value: %+v", entry.Values["names"])
+	}
+}
+
+func TestInputDefaults(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "test.csv")
+	if err := os.WriteFile(file, []byte("192.0.2.0,192.0.2.255,\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dbConfig := DatabaseConfig{
+		Types: map[string]string{
+			"country.iso_code":   "string",
+			"is_anonymous_proxy": "bool",
+		},
+		Inputs: []DatabaseInput{{
+			File:   file,
+			Fields: []string{"from", "to", "country.iso_code"},
+			Defaults: map[string]string{
+				"country.iso_code":   "ZZ",
+				"is_anonymous_proxy": "false",
+			},
+		}},
+	}
+
+	sources, err := LoadSources(dbConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := sources[0].NextEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Values["country.iso_code"] != (SourceValue{Type: "string", Value: "ZZ"}) {
+		t.Fatalf("unexpected value: %+v", entry.Values["country.iso_code"])
+	}
+	if entry.Values["is_anonymous_proxy"] != (SourceValue{Type: "bool", Value: "false"}) {
+		t.Fatalf("unexpected value: %+v", entry.Values["is_anonymous_proxy"])
+	}
+
+	// Defaults must have a type.
+	dbConfig.Inputs[0].Defaults["unknown"] = "x"
+	if _, err := LoadSources(dbConfig); err == nil {
+		t.Fatal("expected error for default without type")
+	}
+}
+
+func TestOmitZeroValues(t *testing.T) {
+	t.Parallel()
+
+	entry := SourceEntry{
+		Values: map[string]SourceValue{
+			"country.iso_code":   {Type: "string", Value: "AT"},
+			"city.name":          {Type: "string", Value: ""},
+			"location.latitude":  {Type: "float64", Value: "0"},
+			"location.longitude": {Type: "float64", Value: "0"},
+			"is_anycast":         {Type: "bool", Value: "false"},
+			"asn":                {Type: "uint32", Value: "0"},
+		},
+	}
+	optim := Optimizations{
+		OmitZeroValues: true,
+		KeepZeroValues: []string{"asn"},
+	}
+
+	m, err := entry.ToMMDBMap(optim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%v", m) != "map[asn:0 country:map[iso_code:AT]]" {
+		t.Fatalf("unexpected map: %v", m)
+	}
+}
+
+func TestCSVInferTypes(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "test.csv")
+	data := "from,to,country,asn,offset,anycast,score,declared,empty\n" +
+		"192.0.2.0,192.0.2.255,AT,1,-1,true,1.5,1,\n" +
+		"198.51.100.0,198.51.100.255,DE,4294967296,2,FALSE,2,2,\n"
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	source, err := LoadCSVSource(DatabaseInput{
+		File:       file,
+		HasHeader:  true,
+		InferTypes: true,
+	}, map[string]string{"declared": "string"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"country": "string",
+		"asn":     "uint64",
+		"offset":  "int32",
+		"anycast": "bool",
+		"score":   "float64",
+	}
+	if fmt.Sprintf("%v", source.InferredTypes()) != fmt.Sprintf("%v", expected) {
+		t.Fatalf("unexpected inferred types: %v", source.InferredTypes())
+	}
+
+	// Sampled rows must still be returned.
+	var entries int
+	for {
+		entry, err := source.NextEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil {
+			break
+		}
+		entries++
+		if entry.Values["asn"].Type != "uint64" || entry.Values["declared"].Type != "string" {
+			t.Fatalf("unexpected entry values: %+v", entry.Values)
+		}
+	}
+	if entries != 2 || source.Err() != nil {
+		t.Fatalf("unexpected entries: %d, err: %v", entries, source.Err())
+	}
+}
+
+func TestUint128Type(t *testing.T) {
+	t.Parallel()
+
+	maxUint128 := "340282366920938463463374607431768211455"
+	tests := []struct {
+		sv       SourceValue
+		expected string
+	}{
+		{SourceValue{Type: "uint128", Value: "42"}, "42"},
+		{SourceValue{Type: "uint128", Value: maxUint128}, maxUint128},
+		{SourceValue{Type: "uint128", Value: "0xffffffffffffffffffffffffffffffff"}, maxUint128},
+		{SourceValue{Type: "uint128", Value: "0X2A"}, "42"},
+		{SourceValue{Type: "array:uint128", Value: "1 0x10"}, "[1 16]"},
+	}
+	for _, test := range tests {
+		v, err := test.sv.ToMMDBType(Optimizations{})
+		if err != nil {
+			t.Fatalf("failed to convert %+v: %s", test.sv, err)
+		}
+		if array, ok := v.(mmdbtype.Slice); ok {
+			values := make([]string, 0, len(array))
+			for _, entry := range array {
+				values = append(values, (*big.Int)(entry.(*mmdbtype.Uint128)).String()) //nolint:forcetypeassert
+			}
+			if fmt.Sprintf("%v", values) != test.expected {
+				t.Fatalf("unexpected value for %+v: %v", test.sv, values)
+			}
+			continue
+		}
+		if (*big.Int)(v.(*mmdbtype.Uint128)).String() != test.expected { //nolint:forcetypeassert
+			t.Fatalf("unexpected value for %+v: %v", test.sv, v)
+		}
+	}
+
+	for _, value := range []string{
+		"340282366920938463463374607431768211456",
+		"0x100000000000000000000000000000000",
+		"-1",
+		"0xg",
+		"",
+	} {
+		if _, err := (SourceValue{Type: "uint128", Value: value}).ToMMDBType(Optimizations{}); err == nil {
+			t.Fatalf("expected error for %q", value)
+		}
+	}
+}
+
+func TestJSONType(t *testing.T) {
+	t.Parallel()
+
+	sv := SourceValue{
+		Type:  "json",
+		Value: `{"is_anycast": true, "name": "x", "asn": 4200000000, "offset": -5, "score": 1.234, "tags": ["a", 1], "none": null}`,
+	}
+	v, err := sv.ToMMDBType(Optimizations{FloatDecimals: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := mmdbtype.Map{
+		"is_anycast": mmdbtype.Bool(true),
+		"name":       mmdbtype.String("x"),
+		"asn":        mmdbtype.Uint64(4200000000),
+		"offset":     mmdbtype.Int32(-5),
+		"score":      mmdbtype.Float64(1.23),
+		"tags":       mmdbtype.Slice{mmdbtype.String("a"), mmdbtype.Int32(1)},
+	}
+	if !v.Equal(expected) {
+		t.Fatalf("unexpected value: %v", v)
+	}
+
+	for _, value := range []string{`"string"`, `1`, `{"a": 1} {}`, `{`} {
+		if _, err := (SourceValue{Type: "json", Value: value}).ToMMDBType(Optimizations{}); err == nil {
+			t.Fatalf("expected error for %s", value)
+		}
+	}
+}
+
+func TestInputTrimSpace(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "test.csv")
+	if err := os.WriteFile(file, []byte(" 192.0.2.0 , 192.0.2.255 , 64496 , Example , 1 2 , \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	types := map[string]string{
+		"autonomous_system_number":       "uint32",
+		"autonomous_system_organization": "string",
+		"list":                           "array:uint32",
+		"country.iso_code":               "string",
+	}
+	source, err := LoadCSVSource(DatabaseInput{
+		File:          file,
+		Fields:        []string{"from", "to", "autonomous_system_number", "autonomous_system_organization", "list", "country.iso_code"},
+		TrimSpace:     true,
+		PreserveSpace: []string{"autonomous_system_organization"},
+		Defaults:      map[string]string{"country.iso_code": "ZZ"},
+	}, types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := source.NextEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := entry.ToMMDBMap(Optimizations{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "map[autonomous_system_number:64496 autonomous_system_organization: Example  country:map[iso_code:ZZ] list:[1 2]]"
+	if fmt.Sprintf("%v", m) != expected {
+		t.Fatalf("unexpected map: %v", m)
+	}
+}
+
+func TestBoolType(t *testing.T) {
+	t.Parallel()
+
+	for _, value := range []string{
+		"1", "t", "T", "true", "TRUE", "True", "tRuE",
+		"yes", "Yes", "YES", "y", "Y", "on", "On", "ON",
+	} {
+		v, err := (SourceValue{Type: "bool", Value: value}).ToMMDBType(Optimizations{})
+		if err != nil || v != mmdbtype.Bool(true) {
+			t.Fatalf("expected true for %q, got %v (%v)", value, v, err)
+		}
+	}
+	for _, value := range []string{
+		"0", "f", "F", "false", "FALSE", "False", "fAlSe",
+		"no", "No", "NO", "n", "N", "off", "Off", "OFF",
+	} {
+		v, err := (SourceValue{Type: "bool", Value: value}).ToMMDBType(Optimizations{})
+		if err != nil || v != mmdbtype.Bool(false) {
+			t.Fatalf("expected false for %q, got %v (%v)", value, v, err)
+		}
+	}
+	for _, value := range []string{"", "2", "maybe", "yess"} {
+		if _, err := (SourceValue{Type: "bool", Value: value}).ToMMDBType(Optimizations{}); err == nil {
+			t.Fatalf("expected error for %q", value)
+		}
+	}
+}
+
+func TestInputFieldMap(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	csvFile := filepath.Join(dir, "test.csv")
+	if err := os.WriteFile(csvFile, []byte("ip_from,ip_to,cc,sub,town\n192.0.2.0,192.0.2.255,AT,AT-9,Vienna\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	jsonFile := filepath.Join(dir, "test.json")
+	if err := os.WriteFile(jsonFile, []byte(`[{"cidr":"192.0.2.0/24","cc":"AT","sub":"AT-9","loc":{"town":"Vienna"}}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	types := map[string]string{
+		"country.iso_code":     "string",
+		"subdivision.iso_code": "string",
+		"city.names.en":        "string",
+		"town":                 "string",
+		"loc.town":             "string",
+	}
+	fieldMap := map[string]string{
+		"ip_from": "from",
+		"ip_to":   "to",
+		"cidr":    "network",
+		"cc":      "country.iso_code",
+		"sub":     "subdivision.iso_code",
+	}
+
+	tests := []struct {
+		input    DatabaseInput
+		expected string
+	}{
+		{
+			DatabaseInput{File: csvFile, HasHeader: true, FieldMap: fieldMap},
+			"map[country:map[iso_code:AT] subdivision:map[iso_code:AT-9] town:Vienna]",
+		},
+		{
+			DatabaseInput{File: csvFile, HasHeader: true, FieldMap: fieldMap, DropUnmapped: true},
+			"map[country:map[iso_code:AT] subdivision:map[iso_code:AT-9]]",
+		},
+		{
+			DatabaseInput{File: jsonFile, FieldMap: fieldMap},
+			"map[country:map[iso_code:AT] loc:map[town:Vienna] subdivision:map[iso_code:AT-9]]",
+		},
+		{
+			DatabaseInput{File: jsonFile, FieldMap: map[string]string{"cidr": "network", "loc.town": "city.names.en"}, DropUnmapped: true},
+			"map[city:map[names:map[en:Vienna]]]",
+		},
+	}
+	for _, test := range tests {
+		sources, err := LoadSources(DatabaseConfig{Inputs: []DatabaseInput{test.input}, Types: types})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry, err := sources[0].NextEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := entry.ToMMDBMap(Optimizations{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprintf("%v", m) != test.expected {
+			t.Fatalf("unexpected map for %s: %v", test.input.File, m)
+		}
+	}
+}
+
+func TestInputName(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "test.csv")
+	if err := os.WriteFile(file, []byte("192.0.2.0,192.0.2.255,AT\nx,192.0.3.255,DE\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sources, err := LoadSources(DatabaseConfig{
+		Types: map[string]string{"country.iso_code": "string"},
+		Inputs: []DatabaseInput{{
+			File:   file,
+			Fields: []string{"from", "to", "country.iso_code"},
+		}, {
+			Name:   "vendor",
+			File:   file,
+			Fields: []string{"from", "to", "country.iso_code"},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sources[0].Name() != file || sources[1].Name() != "vendor" {
+		t.Fatalf("unexpected names: %s, %s", sources[0].Name(), sources[1].Name())
+	}
+
+	// The name is used in errors.
+	_, _ = sources[1].NextEntry()
+	if _, err := sources[1].NextEntry(); err == nil || !strings.HasPrefix(err.Error(), "vendor line 2: ") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestInputTypes(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"a.csv": "192.0.2.0,192.0.2.255,AT,64496\n",
+		"b.csv": "198.51.100.0,198.51.100.255,DE,1.5\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fields := []string{"from", "to", "country.iso_code", "value"}
+	types := map[string]string{
+		"country.iso_code": "string",
+		"value":            "uint32",
+	}
+	sources, err := LoadSources(DatabaseConfig{
+		Inputs: []DatabaseInput{
+			{File: filepath.Join(dir, "a.csv"), Fields: fields},
+			{File: filepath.Join(dir, "b.csv"), Fields: fields, Types: map[string]string{"value": "float64"}},
+		},
+		Types: types,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var values []string
+	for _, source := range sources {
+		entry, err := source.NextEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, fmt.Sprintf("%+v", entry.Values["value"]))
+	}
+	if fmt.Sprintf("%v", values) != "[{Type:uint32 Value:64496} {Type:float64 Value:1.5}]" {
+		t.Fatalf("unexpected values: %v", values)
+	}
+
+	// The shared types are not modified.
+	if types["value"] != "uint32" || len(types) != 2 {
+		t.Fatalf("shared types were modified: %v", types)
+	}
+}
+
+func TestInputNullValues(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "test.csv")
+	if err := os.WriteFile(file, []byte("192.0.2.0,192.0.2.255,n/a, - ,AT\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	types := map[string]string{
+		"autonomous_system_number": "uint32",
+		"location.latitude":        "float64",
+		"country.iso_code":         "string",
+	}
+	source, err := LoadCSVSource(DatabaseInput{
+		File:       file,
+		Fields:     []string{"from", "to", "autonomous_system_number", "location.latitude", "country.iso_code"},
+		TrimSpace:  true,
+		NullValues: []string{"-", "N/A"},
+		Defaults:   map[string]string{"autonomous_system_number": "0"},
+	}, types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := source.NextEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := entry.ToMMDBMap(Optimizations{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "map[autonomous_system_number:0 country:map[iso_code:AT]]"
+	if fmt.Sprintf("%v", m) != expected {
+		t.Fatalf("unexpected map: %v", m)
+	}
+}
+
+func TestInputDuplicateKeys(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	csvFile := filepath.Join(dir, "test.csv")
+	if err := os.WriteFile(csvFile, []byte("from,to,cc,country\n192.0.2.0,192.0.2.255,AT,DE\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	jsonFile := filepath.Join(dir, "test.json")
+	if err := os.WriteFile(jsonFile, []byte(`[{"network":"192.0.2.0/24","cc":"AT","country":"DE"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	types := map[string]string{"country": "string"}
+	fieldMap := map[string]string{"cc": "country"}
+
+	// Duplicate keys fail loading static fields.
+	_, err := LoadCSVSource(DatabaseInput{File: csvFile, HasHeader: true, FieldMap: fieldMap}, types)
+	if err == nil || err.Error() != "fields cc and country both map to country" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Duplicate keys fail entries with dynamic fields.
+	source, err := LoadJSONSource(DatabaseInput{File: jsonFile, FieldMap: fieldMap}, types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.NextEntry(); err == nil || !strings.Contains(err.Error(), "both map to country") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The last value wins if duplicate keys are allowed.
+	csvSource, err := LoadCSVSource(DatabaseInput{File: csvFile, HasHeader: true, FieldMap: fieldMap, AllowDuplicateKeys: true}, types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := csvSource.NextEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Values["country"].Value != "DE" {
+		t.Fatalf("unexpected values: %+v", entry.Values)
+	}
+}
+
+func TestInputTransforms(t *testing.T) {
+	t.Parallel()
+
+	if err := RegisterTransform("reverse", func(value string) (string, error) {
+		runes := []rune(value)
+		slices.Reverse(runes)
+		return string(runes), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterTransform("upper", nil); err == nil {
+		t.Fatal("expected error when replacing a built-in transform")
+	}
+
+	file := filepath.Join(t.TempDir(), "test.csv")
+	if err := os.WriteFile(file, []byte("192.0.2.0,192.0.2.255,at,AS64496,482,olleh\n192.0.3.0,192.0.3.255,de,AS64497,x,olleh\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dbConfig := DatabaseConfig{
+		Types: map[string]string{
+			"country.iso_code":         "string",
+			"autonomous_system_number": "uint32",
+			"location.latitude":        "float64",
+			"greeting":                 "string",
+		},
+		Inputs: []DatabaseInput{{
+			File:   file,
+			Fields: []string{"from", "to", "country.iso_code", "autonomous_system_number", "location.latitude", "greeting"},
+			Transforms: map[string]string{
+				"country.iso_code":         "upper",
+				"autonomous_system_number": "trimPrefix:AS",
+				"location.latitude":        "div10",
+				"greeting":                 "reverse | upper",
+			},
+		}},
+	}
+	sources, err := LoadSources(dbConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := sources[0].NextEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := entry.ToMMDBMap(Optimizations{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "map[autonomous_system_number:64496 country:map[iso_code:AT] greeting:HELLO location:map[latitude:48.2]]"
+	if fmt.Sprintf("%v", m) != expected {
+		t.Fatalf("unexpected map: %v", m)
+	}
+
+	// Failing transforms invalidate the entry.
+	if _, err := sources[0].NextEntry(); err == nil || !strings.Contains(err.Error(), "line 2: failed to transform location.latitude") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Unknown transforms fail loading the input.
+	dbConfig.Inputs[0].Transforms = map[string]string{"greeting": "shout"}
+	if _, err := LoadSources(dbConfig); err == nil {
+		t.Fatal("expected error for unknown transform")
+	}
+}
+
+func TestInputValidate(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "test.csv")
+	data := "192.0.2.0,192.0.2.255,AT,48.2\n192.0.3.0,192.0.3.255,XYZ,48.2\n192.0.4.0,192.0.4.255,DE,95.1\n192.0.5.0,192.0.5.255,CH,47.4\n"
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dbConfig := DatabaseConfig{
+		Types: map[string]string{
+			"country.iso_code":  "string",
+			"location.latitude": "float64",
+		},
+		Inputs: []DatabaseInput{{
+			File:   file,
+			Fields: []string{"from", "to", "country.iso_code", "location.latitude"},
+			Validate: map[string]string{
+				"country.iso_code":  "^[A-Z]{2}$",
+				"location.latitude": "-90:90",
+			},
+		}},
+	}
+	sources, err := LoadSources(dbConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := sources[0]
+	for _, expected := range []string{
+		"",
+		`line 2: invalid country.iso_code "XYZ": does not match ^[A-Z]{2}$`,
+		`line 3: invalid location.latitude "95.1": is not within range -90:90`,
+		"",
+	} {
+		entry, err := source.NextEntry()
+		switch {
+		case expected == "" && (err != nil || entry == nil):
+			t.Fatalf("unexpected entry: %+v, %v", entry, err)
+		case expected != "" && (err == nil || !strings.Contains(err.Error(), expected)):
+			t.Fatalf("unexpected error: %v, expected %q", err, expected)
+		}
+	}
+
+	// Invalid entries are skipped per the error mode.
+	dbConfig.Inputs[0].OnError = OnErrorSkip
+	sources, err = LoadSources(dbConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var countries []string
+	for {
+		entry, err := sources[0].NextEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil {
+			break
+		}
+		countries = append(countries, entry.Values["country.iso_code"].Value)
+	}
+	if fmt.Sprintf("%v", countries) != "[AT CH]" {
+		t.Fatalf("unexpected entries: %v", countries)
+	}
+
+	// Rules check the converted values.
+	if err := os.WriteFile(file, []byte("192.0.2.0,192.0.2.255,at,4_8.2\n192.0.3.0,192.0.3.255,de,89.96\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dbConfig.Optimize = Optimizations{
+		NormalizeStrings: []string{NormalizeUpper},
+		LenientNumbers:   true,
+		FloatDecimals:    1,
+	}
+	dbConfig.Inputs[0].OnError = ""
+	dbConfig.Inputs[0].Validate["location.latitude"] = "-90:89.97"
+	sources, err = LoadSources(dbConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, err := sources[0].NextEntry(); err != nil || entry == nil {
+		t.Fatalf("unexpected entry: %+v, %v", entry, err)
+	}
+	if _, err := sources[0].NextEntry(); err == nil || !strings.Contains(err.Error(), `line 2: invalid location.latitude "89.96": is not within range -90:89.97`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dbConfig.Optimize = Optimizations{}
+
+	// Invalid rules fail loading the input.
+	for field, rule := range map[string]string{
+		"country.iso_code":  "[A-Z",
+		"location.latitude": "90:-90",
+		"city.names.en":     ".+",
+	} {
+		dbConfig.Inputs[0].Validate = map[string]string{field: rule}
+		if _, err := LoadSources(dbConfig); err == nil {
+			t.Fatalf("expected error for rule %q of %s", rule, field)
+		}
+	}
+}
+
+func TestIncludeIf(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "test.csv")
+	data := "192.0.2.0,192.0.2.255,true,vpn\n192.0.3.0,192.0.3.255,false,vpn\n192.0.4.0,192.0.4.255,,tor\n"
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dbConfig := DatabaseConfig{
+		Types: map[string]string{
+			"is_proxy":   "bool",
+			"proxy_type": "string",
+		},
+		Inputs: []DatabaseInput{{
+			File:   file,
+			Fields: []string{"from", "to", "is_proxy", "proxy_type"},
+			IncludeIf: map[string]string{
+				"proxy_type": "is_proxy == true",
+				"is_proxy":   "is_proxy!=",
+			},
+		}},
+	}
+	sources, err := LoadSources(dbConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []string
+	for {
+		entry, err := sources[0].NextEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil {
+			break
+		}
+		entries = append(entries, fmt.Sprintf("%s:%s", entry.Values["is_proxy"].Value, entry.Values["proxy_type"].Value))
+	}
+	if fmt.Sprintf("%v", entries) != "[true:vpn false: :]" {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+
+	// Invalid conditions fail loading the input.
+	for _, condition := range []string{"is_proxy", "==true", "is_proxy>1"} {
+		dbConfig.Inputs[0].IncludeIf = map[string]string{"proxy_type": condition}
+		if _, err := LoadSources(dbConfig); err == nil {
+			t.Fatalf("expected error for condition %q", condition)
+		}
+	}
+}
+
+func TestBuildNestedMap(t *testing.T) {
+	t.Parallel()
+
+	m, err := BuildNestedMap(map[string]SourceValue{
+		"country.iso_code":      {Type: "string", Value: "AT"},
+		"country.names.en":      {Type: "string", Value: "Austria"},
+		"is_anonymous_proxy":    {Type: "bool", Value: "false"},
+		"location.accuracy_km":  {Type: "uint16", Value: "100"},
+		"registered_country.id": {Type: "uint32", Value: "2782113"},
+	}, Optimizations{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "map[country:map[iso_code:AT names:map[en:Austria]] is_anonymous_proxy:false location:map[accuracy_km:100] registered_country:map[id:2782113]]"
+	if fmt.Sprintf("%v", m) != expected {
+		t.Fatalf("unexpected map: %v", m)
+	}
+
+	// Values cannot be nested into non-map values.
+	if _, err := BuildNestedMap(map[string]SourceValue{
+		"country":          {Type: "string", Value: "AT"},
+		"country.iso_code": {Type: "string", Value: "AT"},
+	}, Optimizations{}); err == nil {
+		t.Fatal("expected error for conflicting keys")
+	}
+}
+
+func TestIndexedKeys(t *testing.T) {
+	t.Parallel()
+
+	m, err := BuildNestedMap(map[string]SourceValue{
+		"subdivisions.0.iso_code": {Type: "string", Value: "9"},
+		"subdivisions.0.names.en": {Type: "string", Value: "Vienna"},
+		"subdivisions.1.iso_code": {Type: "string", Value: "W"},
+		"tags.0":                  {Type: "string", Value: "a"},
+		"tags.1":                  {Type: "string", Value: "b"},
+		"matrix.0.0":              {Type: "uint16", Value: "1"},
+		"names.01":                {Type: "string", Value: "not an index"},
+	}, Optimizations{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "map[matrix:[[1]] names:map[01:not an index] subdivisions:[map[iso_code:9 names:map[en:Vienna]] map[iso_code:W]] tags:[a b]]"
+	if fmt.Sprintf("%v", m) != expected {
+		t.Fatalf("unexpected map: %v", m)
+	}
+	if _, ok := m["subdivisions"].(mmdbtype.Slice); !ok {
+		t.Fatalf("expected subdivisions to be a slice, got %T", m["subdivisions"])
+	}
+
+	// Indexes must be dense and must not be mixed with keys.
+	for _, values := range []map[string]SourceValue{
+		{"subdivisions.1.iso_code": {Type: "string", Value: "W"}},
+		{"subdivisions.0.iso_code": {Type: "string", Value: "9"}, "subdivisions.2.iso_code": {Type: "string", Value: "W"}},
+		{"subdivisions.0.iso_code": {Type: "string", Value: "9"}, "subdivisions.x": {Type: "string", Value: "W"}},
+	} {
+		if _, err := BuildNestedMap(values, Optimizations{}); err == nil {
+			t.Fatalf("expected error for %v", values)
+		}
+	}
+}
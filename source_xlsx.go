@@ -0,0 +1,506 @@
+package mmdbmeld
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// XLSXSource reads geoip data from a worksheet of an Excel workbook.
+// Rows are read like the rows of a csv file, with the columns mapped to the
+// fields by position. Rows without any value are skipped.
+type XLSXSource struct {
+	file          string
+	decoder       *xml.Decoder
+	closer        io.Closer
+	sharedStrings []string
+	fields        []string
+	types         map[string]string
+
+	// Rows up to this row number are skipped.
+	skipRows int
+	// Number of the last row read, for rows without a reference.
+	lastRow int
+
+	valueProcessing
+	errorHandling
+	networkFilter
+	err error
+}
+
+// xlsxText is a text of a shared string or an inline string cell, which
+// consists of either a single text or runs of rich text.
+type xlsxText struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	if len(t.Runs) == 0 {
+		return t.Text
+	}
+	var b strings.Builder
+	b.WriteString(t.Text)
+	for _, run := range t.Runs {
+		b.WriteString(run.Text)
+	}
+	return b.String()
+}
+
+// xlsxCell is a cell of a worksheet row.
+type xlsxCell struct {
+	Ref    string   `xml:"r,attr"`
+	Type   string   `xml:"t,attr"`
+	Value  string   `xml:"v"`
+	Inline xlsxText `xml:"is"`
+}
+
+// LoadXLSXSource returns a new XLSXSource reading the sheet of the input, or
+// the first sheet if none is configured. The sheet is streamed, but the
+// shared strings of the workbook are held in memory.
+func LoadXLSXSource(input DatabaseInput, types map[string]string) (*XLSXSource, error) {
+	filter, err := newNetworkFilter(input)
+	if err != nil {
+		return nil, err
+	}
+	if input.SkipRows < 0 {
+		return nil, errors.New("skipRows must not be negative")
+	}
+
+	workbook, file, err := openWorkbook(input)
+	if err != nil {
+		return nil, err
+	}
+	sheetPath, err := xlsxSheetPath(workbook, input.Sheet)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	sharedStrings, err := xlsxSharedStrings(workbook)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	sheet, err := workbook.Open(sheetPath)
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to open sheet: %w", err)
+	}
+
+	xlsx := &XLSXSource{
+		file:          inputName(input),
+		decoder:       xml.NewDecoder(bufio.NewReader(sheet)),
+		sharedStrings: sharedStrings,
+		skipRows:      input.SkipRows,
+		closer: &archiveEntry{
+			Reader:  sheet,
+			entry:   sheet,
+			archive: file,
+		},
+	}
+
+	// Read header, if the sheet has one.
+	// The header only defines the fields if they are not configured.
+	fields := input.Fields
+	if input.HasHeader {
+		header, _, err := xlsx.readRow()
+		if err != nil {
+			_ = xlsx.closer.Close()
+			return nil, fmt.Errorf("failed to read header: %w", err)
+		}
+		if len(fields) == 0 {
+			fields = make([]string, 0, len(header))
+			for _, column := range header {
+				fields = append(fields, strings.TrimSpace(column))
+			}
+		}
+	}
+	if len(fields) == 0 {
+		_ = xlsx.closer.Close()
+		return nil, errors.New("no fields defined and sheet has no header")
+	}
+	fields, err = newFieldMapping(input).targetKeys(fields)
+	if err != nil {
+		_ = xlsx.closer.Close()
+		return nil, err
+	}
+	types, err = resolveColumnTypes(types, fields)
+	if err != nil {
+		_ = xlsx.closer.Close()
+		return nil, err
+	}
+	if err := checkArrayFields(fields, types); err != nil {
+		_ = xlsx.closer.Close()
+		return nil, err
+	}
+
+	xlsx.fields = fields
+	xlsx.types = types
+	xlsx.valueProcessing = newValueProcessing(input, types)
+	xlsx.errorHandling = newErrorHandling(input)
+	xlsx.networkFilter = filter
+	return xlsx, nil
+}
+
+// openWorkbook opens the input file as a zip archive. Files that cannot be
+// read at random, like remote or compressed files and stdin, are read into
+// memory.
+func openWorkbook(input DatabaseInput) (*zip.Reader, io.Closer, error) {
+	file, err := openInput(input)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	var (
+		readerAt io.ReaderAt
+		size     int64
+	)
+	if osFile, ok := file.(*os.File); ok {
+		if stat, err := osFile.Stat(); err == nil && stat.Mode().IsRegular() {
+			readerAt, size = osFile, stat.Size()
+		}
+	}
+	if readerAt == nil {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			_ = file.Close()
+			return nil, nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		readerAt, size = bytes.NewReader(data), int64(len(data))
+	}
+
+	workbook, err := zip.NewReader(readerAt, size)
+	if err != nil {
+		_ = file.Close()
+		return nil, nil, fmt.Errorf("failed to open workbook: %w", err)
+	}
+	return workbook, file, nil
+}
+
+// xlsxSheetPath returns the path of the given sheet in the workbook.
+// The sheet is selected by its name or by its 1-based index.
+func xlsxSheetPath(workbook *zip.Reader, sheet string) (string, error) {
+	var book struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			ID   string `xml:"id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := readXMLEntry(workbook, "xl/workbook.xml", &book); err != nil {
+		return "", err
+	}
+	if len(book.Sheets) == 0 {
+		return "", errors.New("workbook has no sheets")
+	}
+
+	selected := -1
+	names := make([]string, 0, len(book.Sheets))
+	for i, s := range book.Sheets {
+		if s.Name == sheet {
+			selected = i
+			break
+		}
+		names = append(names, s.Name)
+	}
+	switch index, err := strconv.Atoi(sheet); {
+	case selected >= 0:
+	case sheet == "":
+		selected = 0
+	case err == nil && index >= 1 && index <= len(book.Sheets):
+		selected = index - 1
+	default:
+		return "", fmt.Errorf("sheet %q not found, the workbook has: %s", sheet, strings.Join(names, ", "))
+	}
+
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := readXMLEntry(workbook, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return "", err
+	}
+	for _, rel := range rels.Relationships {
+		if rel.ID != book.Sheets[selected].ID {
+			continue
+		}
+		if target, ok := strings.CutPrefix(rel.Target, "/"); ok {
+			return target, nil
+		}
+		return path.Join("xl", rel.Target), nil
+	}
+	return "", fmt.Errorf("sheet %q has no worksheet", book.Sheets[selected].Name)
+}
+
+// xlsxSharedStrings returns the shared strings of the workbook, which are
+// referenced by index from the cells.
+func xlsxSharedStrings(workbook *zip.Reader) ([]string, error) {
+	var sst struct {
+		Items []xlsxText `xml:"si"`
+	}
+	err := readXMLEntry(workbook, "xl/sharedStrings.xml", &sst)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		// Workbooks without text have no shared strings.
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	sharedStrings := make([]string, 0, len(sst.Items))
+	for _, item := range sst.Items {
+		sharedStrings = append(sharedStrings, item.String())
+	}
+	return sharedStrings, nil
+}
+
+// readXMLEntry decodes the xml file of the workbook into v.
+func readXMLEntry(workbook *zip.Reader, name string, v any) error {
+	entry, err := workbook.Open(name)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer entry.Close() //nolint:errcheck
+
+	if err := xml.NewDecoder(entry).Decode(v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	return nil
+}
+
+// readRow returns the values of the next row with any value, by column,
+// and its row number.
+func (xlsx *XLSXSource) readRow() (row []string, line int, err error) {
+	for {
+		token, err := xlsx.decoder.Token()
+		if err != nil {
+			return nil, 0, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+
+		row, line, err = xlsx.parseRow(start)
+		if err != nil {
+			return nil, 0, errorAtLine(xlsx.Name(), line, err)
+		}
+		if line <= xlsx.skipRows {
+			continue
+		}
+		for _, value := range row {
+			if value != "" {
+				return row, line, nil
+			}
+		}
+	}
+}
+
+// parseRow parses the cells of the row element.
+func (xlsx *XLSXSource) parseRow(start xml.StartElement) (row []string, line int, err error) {
+	line = xlsx.lastRow + 1
+	for _, attr := range start.Attr {
+		if attr.Name.Local != "r" {
+			continue
+		}
+		if line, err = strconv.Atoi(attr.Value); err != nil {
+			return nil, xlsx.lastRow + 1, fmt.Errorf("invalid row number %q", attr.Value)
+		}
+	}
+	xlsx.lastRow = line
+
+	column := -1
+	for {
+		token, err := xlsx.decoder.Token()
+		if err != nil {
+			return nil, line, err
+		}
+		switch t := token.(type) {
+		case xml.EndElement:
+			if t.Name.Local == "row" {
+				return row, line, nil
+			}
+		case xml.StartElement:
+			if t.Name.Local != "c" {
+				continue
+			}
+			var cell xlsxCell
+			if err := xlsx.decoder.DecodeElement(&cell, &t); err != nil {
+				return nil, line, err
+			}
+
+			// Place the value in its column, cells may be left out.
+			column++
+			if cell.Ref != "" {
+				if column, err = xlsxColumn(cell.Ref); err != nil {
+					return nil, line, err
+				}
+			}
+			value, err := xlsx.cellValue(cell)
+			if err != nil {
+				return nil, line, fmt.Errorf("cell %s: %w", cell.Ref, err)
+			}
+			for len(row) <= column {
+				row = append(row, "")
+			}
+			row[column] = value
+		}
+	}
+}
+
+// xlsxColumn returns the 0-based column of a cell reference, like "C5".
+func xlsxColumn(ref string) (int, error) {
+	letters := strings.TrimRight(ref, "0123456789")
+	if letters == "" || len(letters) > 3 {
+		return 0, fmt.Errorf("invalid cell reference %q", ref)
+	}
+	column := 0
+	for _, r := range letters {
+		if r < 'A' || r > 'Z' {
+			return 0, fmt.Errorf("invalid cell reference %q", ref)
+		}
+		column = column*26 + int(r-'A') + 1
+	}
+	return column - 1, nil
+}
+
+// cellValue returns the raw value of the cell.
+func (xlsx *XLSXSource) cellValue(cell xlsxCell) (string, error) {
+	switch cell.Type {
+	case "s":
+		index, err := strconv.Atoi(cell.Value)
+		if err != nil || index < 0 || index >= len(xlsx.sharedStrings) {
+			return "", fmt.Errorf("invalid shared string %q", cell.Value)
+		}
+		return xlsx.sharedStrings[index], nil
+	case "inlineStr":
+		return cell.Inline.String(), nil
+	case "b":
+		return strconv.FormatBool(cell.Value == "1"), nil
+	case "", "n":
+		return formatXLSXNumber(cell.Value), nil
+	default:
+		// Formula strings, errors and ISO dates are kept as they are.
+		return cell.Value, nil
+	}
+}
+
+// formatXLSXNumber formats a number stored in a workbook as a plain decimal.
+// Integers are kept as they are. Other numbers are rounded to the 15
+// significant digits that Excel shows, which removes the float drift of
+// stored values like 0.30000000000000004, and are written without exponent.
+func formatXLSXNumber(value string) string {
+	if strings.Trim(strings.TrimPrefix(value, "-"), "0123456789") == "" {
+		return value
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return value
+	}
+	f, _ = strconv.ParseFloat(strconv.FormatFloat(f, 'g', 15, 64), 64)
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// FieldNames returns the names of the fields of the source.
+func (xlsx *XLSXSource) FieldNames() []string {
+	return xlsx.fields
+}
+
+// Name returns an identifying name for the source.
+func (xlsx *XLSXSource) Name() string {
+	return xlsx.file
+}
+
+// NextEntry returns the next entry of the source.
+// If nil, nil is returned, stop reading and check Err().
+func (xlsx *XLSXSource) NextEntry() (*SourceEntry, error) {
+	return xlsx.NextEntryContext(context.Background())
+}
+
+// NextEntryContext returns the next entry of the source.
+// If nil, nil is returned, stop reading and check Err().
+// If the context is canceled, reading stops and Err() returns the context error.
+func (xlsx *XLSXSource) NextEntryContext(ctx context.Context) (*SourceEntry, error) {
+	for {
+		se, err := xlsx.nextEntry(ctx)
+		if se != nil {
+			if err = xlsx.processValues(se); err != nil {
+				err = fmt.Errorf("%s: %w", entryPosition(xlsx, se), err)
+				se = nil
+			}
+		}
+		if err != nil {
+			if xlsx.skip(err) {
+				continue
+			}
+			if xlsx.fail() {
+				xlsx.err = err
+				_ = xlsx.Close()
+				return nil, nil //nolint:nilerr
+			}
+		}
+		return se, err
+	}
+}
+
+func (xlsx *XLSXSource) nextEntry(ctx context.Context) (*SourceEntry, error) {
+	// Check if there is an error, do not read if there is an error.
+	if xlsx.err != nil {
+		return nil, nil //nolint:nilerr
+	}
+
+	// Check if the context was canceled.
+	if err := ctx.Err(); err != nil {
+		xlsx.err = err
+		_ = xlsx.Close()
+		return nil, nil //nolint:nilerr
+	}
+
+	// Read and parse row.
+	row, line, err := xlsx.readRow()
+	if err != nil {
+		xlsx.err = err
+		_ = xlsx.Close()
+		return nil, nil //nolint:nilerr
+	}
+	return rowEntry(xlsx.Name(), xlsx.fields, xlsx.types, xlsx.trimSpace, row, line)
+}
+
+// Close closes the underlying file of the source and stops reading.
+// Closing an already closed source does nothing.
+func (xlsx *XLSXSource) Close() error {
+	if xlsx.closer == nil {
+		return nil
+	}
+	if xlsx.err == nil {
+		xlsx.err = errSourceClosed
+	}
+	err := xlsx.closer.Close()
+	xlsx.closer = nil
+	return err
+}
+
+// Err returns the processing error encountered by the source.
+func (xlsx *XLSXSource) Err() error {
+	switch {
+	case xlsx.err == nil:
+		return nil
+	case errors.Is(xlsx.err, io.EOF):
+		return nil
+	default:
+		return xlsx.err
+	}
+}
@@ -0,0 +1,148 @@
+package mmdbmeld
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestWorkbook writes a workbook with the given sheets, by name, and
+// the shared strings.
+func writeTestWorkbook(t *testing.T, sheets [][2]string, sharedStrings []string) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "test.xlsx")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	var book, rels, sst strings.Builder
+	book.WriteString(`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+	rels.WriteString(`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	files := make(map[string]string)
+	for i, sheet := range sheets {
+		fmt.Fprintf(&book, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, sheet[0], i+1, i+1)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+		files[fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1)] = `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` + sheet[1] + `</sheetData></worksheet>`
+	}
+	book.WriteString(`</sheets></workbook>`)
+	rels.WriteString(`</Relationships>`)
+	sst.WriteString(`<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	for _, s := range sharedStrings {
+		fmt.Fprintf(&sst, `<si><t>%s</t></si>`, s)
+	}
+	sst.WriteString(`</sst>`)
+	files["xl/workbook.xml"] = book.String()
+	files["xl/_rels/workbook.xml.rels"] = rels.String()
+	files["xl/sharedStrings.xml"] = sst.String()
+
+	archive := zip.NewWriter(f)
+	for name, data := range files {
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestXLSXSource(t *testing.T) {
+	t.Parallel()
+
+	data := `<row r="1"><c r="A1" t="s"><v>0</v></c></row>` +
+		`<row r="2"><c r="A2" t="s"><v>1</v></c><c r="B2" t="s"><v>2</v></c><c r="C2" t="s"><v>3</v></c><c r="D2" t="s"><v>4</v></c><c r="E2" t="s"><v>5</v></c></row>` +
+		`<row r="3"><c r="A3" t="inlineStr"><is><t>192.0.2.0</t></is></c><c r="B3" t="inlineStr"><is><t>192.0.2.255</t></is></c><c r="C3" t="inlineStr"><is><r><t>A</t></r><r><t>T</t></r></is></c><c r="D3"><v>4294967295</v></c><c r="E3"><v>48.208199999999998</v></c></row>` +
+		`<row r="5"><c r="C5"/></row>` +
+		`<row r="6"><c r="A6" t="inlineStr"><is><t>192.0.3.0</t></is></c><c r="B6" t="inlineStr"><is><t>192.0.3.255</t></is></c><c r="D6"><v>1.5E+2</v></c><c r="E6"><v>1.0000000000000001E-7</v></c></row>`
+	file := writeTestWorkbook(t, [][2]string{{"Notes", ""}, {"Data", data}}, []string{"Exported data", "from", "to", "country", "id", "latitude"})
+
+	dbConfig := DatabaseConfig{
+		Types: map[string]string{
+			"country.iso_code":  "string",
+			"id":                "uint32",
+			"location.latitude": "float64",
+		},
+		Inputs: []DatabaseInput{{
+			File:      file,
+			Sheet:     "Data",
+			SkipRows:  1,
+			HasHeader: true,
+			FieldMap: map[string]string{
+				"country":  "country.iso_code",
+				"latitude": "location.latitude",
+			},
+		}},
+	}
+	sources, err := LoadSources(dbConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, ok := sources[0].(*XLSXSource)
+	if !ok {
+		t.Fatalf("expected xlsx source, got %T", sources[0])
+	}
+	if fmt.Sprintf("%v", source.FieldNames()) != "[from to country.iso_code id location.latitude]" {
+		t.Fatalf("unexpected fields: %v", source.FieldNames())
+	}
+
+	var entries []string
+	for {
+		entry, err := source.NextEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry == nil {
+			break
+		}
+		entries = append(entries, fmt.Sprintf("%d:%s-%s:%s:%s:%s", entry.Line, entry.From, entry.To,
+			entry.Values["country.iso_code"].Value, entry.Values["id"].Value, entry.Values["location.latitude"].Value))
+	}
+	if source.Err() != nil {
+		t.Fatal(source.Err())
+	}
+	expected := "[3:192.0.2.0-192.0.2.255:AT:4294967295:48.2082 6:192.0.3.0-192.0.3.255::150:0.0000001]"
+	if fmt.Sprintf("%v", entries) != expected {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+
+	// Sheets are selected by index, too.
+	dbConfig.Inputs[0].Sheet = "2"
+	sources, err = LoadSources(dbConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = sources[0].Close()
+	dbConfig.Inputs[0].Sheet = "Missing"
+	if _, err := LoadSources(dbConfig); err == nil || !strings.Contains(err.Error(), `sheet "Missing" not found, the workbook has: Notes, Data`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestFormatXLSXNumber(t *testing.T) {
+	t.Parallel()
+
+	for value, expected := range map[string]string{
+		"42":                   "42",
+		"-7":                   "-7",
+		"18446744073709551615": "18446744073709551615",
+		"0.30000000000000004":  "0.3",
+		"1.2345678901234E+15":  "1234567890123400",
+		"-2.5E-3":              "-0.0025",
+		"":                     "",
+	} {
+		if formatted := formatXLSXNumber(value); formatted != expected {
+			t.Errorf("formatted %q as %q, expected %q", value, formatted, expected)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package mmdbmeld
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"gopkg.in/yaml.v3"
+)
+
+// YAMLSource reads geoip data from a yaml list of entries.
+// It is meant for small, hand-edited files, which are read at once.
+type YAMLSource struct {
+	file    string
+	entries []*yaml.Node
+	index   int
+	types   map[string]string
+	fields  fieldMapping
+
+	// Names of the fields of the first entry.
+	fieldNames []string
+
+	valueProcessing
+	errorHandling
+	networkFilter
+	err error
+}
+
+// yamlEntry is an entry of a yaml source.
+type yamlEntry struct {
+	Network string    `yaml:"network"`
+	Start   string    `yaml:"start"`
+	End     string    `yaml:"end"`
+	Values  yaml.Node `yaml:"values"`
+}
+
+// LoadYAMLSource returns a new YAMLSource.
+func LoadYAMLSource(input DatabaseInput, types map[string]string) (*YAMLSource, error) {
+	filter, err := newNetworkFilter(input)
+	if err != nil {
+		return nil, err
+	}
+	file, err := openInput(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close() //nolint:errcheck
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	// Parse the list of entries, but keep the entries as nodes to parse them
+	// one by one.
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse yaml: %w", err)
+	}
+	var entries []*yaml.Node
+	if len(doc.Content) > 0 {
+		list := doc.Content[0]
+		if list.Kind != yaml.SequenceNode {
+			return nil, fmt.Errorf("line %d: expected a list of entries", list.Line)
+		}
+		entries = list.Content
+	}
+
+	return &YAMLSource{
+		file:            inputName(input),
+		entries:         entries,
+		types:           types,
+		fields:          newFieldMapping(input),
+		valueProcessing: newValueProcessing(input, types),
+		errorHandling:   newErrorHandling(input),
+		networkFilter:   filter,
+	}, nil
+}
+
+// Name returns an identifying name for the source.
+func (ys *YAMLSource) Name() string {
+	return ys.file
+}
+
+// NextEntry returns the next entry of the source.
+// If nil, nil is returned, stop reading and check Err().
+func (ys *YAMLSource) NextEntry() (*SourceEntry, error) {
+	return ys.NextEntryContext(context.Background())
+}
+
+// NextEntryContext returns the next entry of the source.
+// If nil, nil is returned, stop reading and check Err().
+// If the context is canceled, reading stops and Err() returns the context error.
+func (ys *YAMLSource) NextEntryContext(ctx context.Context) (*SourceEntry, error) {
+	for {
+		se, err := ys.nextEntry(ctx)
+		if se != nil {
+			if err = ys.processValues(se); err != nil {
+				err = fmt.Errorf("%s: %w", entryPosition(ys, se), err)
+				se = nil
+			}
+		}
+		if err != nil {
+			if ys.skip(err) {
+				continue
+			}
+			if ys.fail() {
+				ys.err = err
+				return nil, nil //nolint:nilerr
+			}
+		}
+		return se, err
+	}
+}
+
+func (ys *YAMLSource) nextEntry(ctx context.Context) (*SourceEntry, error) {
+	// Check if there is an error, do not read if there is an error.
+	if ys.err != nil {
+		return nil, nil //nolint:nilerr
+	}
+
+	// Check if the context was canceled.
+	if err := ctx.Err(); err != nil {
+		ys.err = err
+		return nil, nil //nolint:nilerr
+	}
+
+	// Check if all entries were read.
+	if ys.index >= len(ys.entries) {
+		ys.err = io.EOF
+		return nil, nil //nolint:nilerr
+	}
+	node := ys.entries[ys.index]
+	ys.index++
+
+	// Parse entry.
+	var entry yamlEntry
+	if err := node.Decode(&entry); err != nil {
+		return nil, errorAtLine(ys.Name(), node.Line, err)
+	}
+	se := &SourceEntry{
+		Values: make(map[string]SourceValue),
+		Line:   node.Line,
+	}
+	switch {
+	case entry.Network != "":
+		_, ipNet, err := net.ParseCIDR(entry.Network)
+		if err != nil {
+			return nil, errorAtLine(ys.Name(), node.Line, fmt.Errorf("failed to parse net %s: %w", entry.Network, err))
+		}
+		se.Net = ipNet
+	case entry.Start != "" && entry.End != "":
+		from, err := parseJSONIP(entry.Start)
+		if err != nil {
+			return nil, errorAtLine(ys.Name(), node.Line, err)
+		}
+		to, err := parseJSONIP(entry.End)
+		if err != nil {
+			return nil, errorAtLine(ys.Name(), node.Line, err)
+		}
+		se.From, se.To = from, to
+	default:
+		return nil, errorAtLine(ys.Name(), node.Line, errors.New("entry is missing network or start and end"))
+	}
+
+	// Flatten values.
+	switch entry.Values.Kind {
+	case 0:
+		// No values.
+	case yaml.MappingNode:
+		if ys.fieldNames == nil {
+			var values map[string]any
+			if err := entry.Values.Decode(&values); err != nil {
+				return nil, errorAtLine(ys.Name(), node.Line, err)
+			}
+			ys.fieldNames = ys.fields.fieldNames(values, "", ys.types)
+		}
+		if err := ys.addValues(se, "", &entry.Values); err != nil {
+			return nil, errorAtLine(ys.Name(), node.Line, err)
+		}
+	default:
+		return nil, errorAtLine(ys.Name(), entry.Values.Line, errors.New("values must be a map"))
+	}
+
+	return se, nil
+}
+
+// addValues adds the values of the given yaml map node to the source entry.
+// Nested maps are flattened into dotted keys, which are then renamed by
+// the field mapping.
+func (ys *YAMLSource) addValues(se *SourceEntry, prefix string, node *yaml.Node) error {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		sourceKey := node.Content[i].Value
+		if prefix != "" {
+			sourceKey = prefix + "." + sourceKey
+		}
+		value := node.Content[i+1]
+		if value.Kind == yaml.AliasNode {
+			value = value.Alias
+		}
+		key := ys.fields.targetKey(sourceKey)
+		fieldType, ok := fieldTypeFor(ys.types, key)
+
+		switch {
+		case ok && fieldType == "json":
+			// Keep json values as they are.
+			var v any
+			if err := value.Decode(&v); err != nil {
+				return fmt.Errorf("failed to read %s: %w", sourceKey, err)
+			}
+			if v == nil {
+				continue
+			}
+			data, err := json.Marshal(v)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", sourceKey, err)
+			}
+			if err := ys.fields.setValue(se, sourceKey, key, SourceValue{
+				Type:  fieldType,
+				Value: string(data),
+			}); err != nil {
+				return err
+			}
+
+		case value.Kind == yaml.MappingNode:
+			// Flatten nested maps.
+			if err := ys.addValues(se, sourceKey, value); err != nil {
+				return err
+			}
+
+		case !ok || isYAMLNull(value):
+			// Ignore values without type and null values.
+
+		case value.Kind == yaml.SequenceNode:
+			fields := make([]string, 0, len(value.Content))
+			for j, arrayValue := range value.Content {
+				if arrayValue.Kind != yaml.ScalarNode {
+					return fmt.Errorf("failed to read %s array entry #%d: unsupported type", sourceKey, j)
+				}
+				fields = append(fields, arrayValue.Value)
+			}
+			if err := ys.fields.setValue(se, sourceKey, key, SourceValue{
+				Type:  fieldType,
+				Value: joinArray(fieldType, ys.arraySeparator, fields),
+			}); err != nil {
+				return err
+			}
+
+		case value.Kind == yaml.ScalarNode:
+			if err := ys.fields.setValue(se, sourceKey, key, SourceValue{
+				Type:  fieldType,
+				Value: value.Value,
+			}); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("failed to read %s: unsupported type", sourceKey)
+		}
+	}
+	return nil
+}
+
+// isYAMLNull returns whether the node is a null value.
+func isYAMLNull(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.ShortTag() == "!!null"
+}
+
+// FieldNames returns the names of the fields of the first entry.
+// It is empty until the first entry was read.
+func (ys *YAMLSource) FieldNames() []string {
+	return ys.fieldNames
+}
+
+// EstimateCount returns the amount of entries of the source, which is exact,
+// as the file is read when the source is loaded.
+func (ys *YAMLSource) EstimateCount() (int, bool) {
+	return len(ys.entries), true
+}
+
+// Close releases the entries of the source and stops reading.
+// The file is read when the source is loaded, so there is no file to close.
+func (ys *YAMLSource) Close() error {
+	if ys.err == nil {
+		ys.err = errSourceClosed
+	}
+	ys.entries = nil
+	return nil
+}
+
+// Err returns the processing error encountered by the source.
+func (ys *YAMLSource) Err() error {
+	switch {
+	case ys.err == nil:
+		return nil
+	case errors.Is(ys.err, io.EOF):
+		return nil
+	default:
+		return ys.err
+	}
+}
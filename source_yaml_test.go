@@ -0,0 +1,95 @@
+package mmdbmeld
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestYAMLSource(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "overrides.yaml")
+	err := os.WriteFile(file, []byte(`
+- network: 192.0.2.0/24
+  values:
+    country:
+      iso_code: AT
+    autonomous_system_number: 64496
+    tags: [a, b]
+    ignored: x
+- start: 198.51.100.0
+  end: 198.51.100.127
+  values:
+    country:
+      iso_code: ~
+- network: invalid
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := LoadSources(DatabaseConfig{
+		Inputs: []DatabaseInput{{File: file}},
+		Types: map[string]string{
+			"country.iso_code":         "string",
+			"autonomous_system_number": "uint32",
+			"tags":                     "array:string",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := sources[0]
+
+	entry, err := source.NextEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := entry.ToMMDBMap(Optimizations{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Net.String() != "192.0.2.0/24" || entry.Line != 2 {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+	if fmt.Sprintf("%v", m) != "map[autonomous_system_number:64496 country:map[iso_code:AT] tags:[a b]]" {
+		t.Fatalf("unexpected map: %v", m)
+	}
+
+	entry, err = source.NextEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.From.String() != "198.51.100.0" || entry.To.String() != "198.51.100.127" || len(entry.Values) != 0 {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+
+	// Invalid entries are returned with their line.
+	_, err = source.NextEntry()
+	if err == nil || err.Error() != file+" line 14: failed to parse net invalid: invalid CIDR address: invalid" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	entry, err = source.NextEntry()
+	if entry != nil || err != nil || source.Err() != nil {
+		t.Fatalf("expected end of source, got %+v, %v, %v", entry, err, source.Err())
+	}
+
+	// Reading stops after closing.
+	sources, err = LoadSources(DatabaseConfig{
+		Inputs: []DatabaseInput{{File: file}},
+		Types:  map[string]string{"country.iso_code": "string"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	source = sources[0]
+	if err := source.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if entry, _ := source.NextEntry(); entry != nil || !errors.Is(source.Err(), errSourceClosed) {
+		t.Fatalf("expected closed source, got %+v, %v", entry, source.Err())
+	}
+}
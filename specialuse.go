@@ -0,0 +1,86 @@
+package mmdbmeld
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+
+	"go4.org/netipx"
+)
+
+// specialUseNetworks are the IANA special-use networks skipped with
+// SkipSpecialUse: private, loopback, link-local, documentation and
+// multicast networks.
+var specialUseNetworks = []string{
+	// IPv4
+	"10.0.0.0/8",      // Private (RFC 1918)
+	"172.16.0.0/12",   // Private (RFC 1918)
+	"192.168.0.0/16",  // Private (RFC 1918)
+	"127.0.0.0/8",     // Loopback (RFC 1122)
+	"169.254.0.0/16",  // Link-local (RFC 3927)
+	"192.0.2.0/24",    // Documentation, TEST-NET-1 (RFC 5737)
+	"198.51.100.0/24", // Documentation, TEST-NET-2 (RFC 5737)
+	"203.0.113.0/24",  // Documentation, TEST-NET-3 (RFC 5737)
+	"224.0.0.0/4",     // Multicast (RFC 5771)
+
+	// IPv6
+	"fc00::/7",      // Unique local (RFC 4193)
+	"::1/128",       // Loopback (RFC 4291)
+	"fe80::/10",     // Link-local (RFC 4291)
+	"2001:db8::/32", // Documentation (RFC 3849)
+	"3fff::/20",     // Documentation (RFC 9637)
+	"ff00::/8",      // Multicast (RFC 4291)
+}
+
+// specialUseSet returns the special-use networks as an IP set.
+var specialUseSet = sync.OnceValue(func() *netipx.IPSet {
+	var b netipx.IPSetBuilder
+	for _, network := range specialUseNetworks {
+		b.AddPrefix(netip.MustParsePrefix(network))
+	}
+	set, err := b.IPSet()
+	if err != nil {
+		panic(err)
+	}
+	return set
+})
+
+// SpecialUseNetworks returns the special-use networks that are skipped if
+// SkipSpecialUse is enabled.
+func SpecialUseNetworks() []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(specialUseNetworks))
+	for _, network := range specialUseNetworks {
+		_, ipNet, _ := net.ParseCIDR(network)
+		networks = append(networks, ipNet)
+	}
+	return networks
+}
+
+// withoutSpecialUse removes the special-use networks from the given networks.
+// Networks that partially overlap with special-use networks are split into
+// the remaining networks. It returns the remaining networks and the amount
+// of networks that were removed or split.
+func withoutSpecialUse(networks []*net.IPNet) (remaining []*net.IPNet, removed int) {
+	special := specialUseSet()
+	remaining = make([]*net.IPNet, 0, len(networks))
+	for _, network := range networks {
+		prefix, ok := netipx.FromStdIPNet(NormalizeNetwork(network))
+		if !ok || !special.OverlapsPrefix(prefix) {
+			remaining = append(remaining, network)
+			continue
+		}
+		removed++
+
+		var b netipx.IPSetBuilder
+		b.AddPrefix(prefix)
+		b.RemoveSet(special)
+		set, err := b.IPSet()
+		if err != nil {
+			continue
+		}
+		for _, p := range set.Prefixes() {
+			remaining = append(remaining, netipx.PrefixIPNet(p))
+		}
+	}
+	return remaining, removed
+}
@@ -0,0 +1,246 @@
+package mmdbmeld
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// TransformFunc transforms a raw source value before it is converted.
+type TransformFunc func(value string) (string, error)
+
+var (
+	transforms = map[string]TransformFunc{
+		"upper":   func(value string) (string, error) { return strings.ToUpper(value), nil },
+		"lower":   func(value string) (string, error) { return strings.ToLower(value), nil },
+		"trim":    func(value string) (string, error) { return strings.TrimSpace(value), nil },
+		"div10":   divideBy(1),
+		"div100":  divideBy(2),
+		"div1000": divideBy(3),
+	}
+	transformsLock sync.RWMutex
+)
+
+// RegisterTransform registers a named transform for use in the transforms of
+// inputs. Built-in transforms cannot be replaced.
+func RegisterTransform(name string, fn TransformFunc) error {
+	transformsLock.Lock()
+	defer transformsLock.Unlock()
+
+	switch {
+	case name == "" || strings.ContainsAny(name, ":|"):
+		return fmt.Errorf("invalid transform name %q", name)
+	case transforms[name] != nil:
+		return fmt.Errorf("transform %s is already registered", name)
+	}
+	transforms[name] = fn
+	return nil
+}
+
+// parseTransform returns the transform defined by the given spec.
+// Multiple transforms are separated by "|" and applied in order.
+func parseTransform(spec string) (TransformFunc, error) {
+	names := strings.Split(spec, "|")
+	fns := make([]TransformFunc, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		switch {
+		case strings.HasPrefix(name, "trimPrefix:"):
+			prefix := strings.TrimPrefix(name, "trimPrefix:")
+			fns = append(fns, func(value string) (string, error) {
+				return strings.TrimPrefix(value, prefix), nil
+			})
+		case strings.HasPrefix(name, "trimSuffix:"):
+			suffix := strings.TrimPrefix(name, "trimSuffix:")
+			fns = append(fns, func(value string) (string, error) {
+				return strings.TrimSuffix(value, suffix), nil
+			})
+		default:
+			transformsLock.RLock()
+			fn, ok := transforms[name]
+			transformsLock.RUnlock()
+			if !ok {
+				return nil, fmt.Errorf("unknown transform %q", name)
+			}
+			fns = append(fns, fn)
+		}
+	}
+
+	if len(fns) == 1 {
+		return fns[0], nil
+	}
+	return func(value string) (string, error) {
+		var err error
+		for _, fn := range fns {
+			value, err = fn(value)
+			if err != nil {
+				return "", err
+			}
+		}
+		return value, nil
+	}, nil
+}
+
+// divideBy returns a transform that divides a number by 10^decimals.
+func divideBy(decimals int) TransformFunc {
+	divisor := math.Pow10(decimals)
+	return func(value string) (string, error) {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse number %q: %w", value, err)
+		}
+		return strconv.FormatFloat(f/divisor, 'f', -1, 64), nil
+	}
+}
+
+// validateFunc checks a processed value, as converted to its mmdb type, and
+// returns why it is invalid.
+type validateFunc func(value SourceValue) error
+
+// parseValidator returns the validator defined by the given rule for fields
+// of the given type. Values are converted with the given optimizations
+// before they are checked, so that rules see the values as stored. Rules of
+// numeric fields are ranges in the form "min:max", where either bound may be
+// empty. Rules of all other fields are regular expressions, which must match
+// the stored string, or the raw value for fields that are not strings.
+func parseValidator(fieldType, rule string, optim Optimizations) (validateFunc, error) {
+	if strings.HasPrefix(fieldType, "array:") {
+		return nil, errors.New("arrays cannot be validated")
+	}
+	// Only check the value, do not count or report its conversion.
+	optim.overflows = nil
+	optim.interner = nil
+	optim.RoundingWarning = nil
+
+	if !isNumericType(fieldType) {
+		re, err := regexp.Compile(rule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %w", err)
+		}
+		return func(value SourceValue) error {
+			text := value.Value
+			if value.Type == "string" {
+				v, err := value.ToMMDBType(optim)
+				if err != nil {
+					return err
+				}
+				if s, ok := v.(mmdbtype.String); ok {
+					text = string(s)
+				}
+			}
+			if !re.MatchString(text) {
+				return fmt.Errorf("does not match %s", rule)
+			}
+			return nil
+		}, nil
+	}
+
+	minRule, maxRule, ok := strings.Cut(rule, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid range %q, expected min:max", rule)
+	}
+	minValue, maxValue := math.Inf(-1), math.Inf(1)
+	if minRule = strings.TrimSpace(minRule); minRule != "" {
+		v, err := strconv.ParseFloat(minRule, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid minimum of range %q: %w", rule, err)
+		}
+		minValue = v
+	}
+	if maxRule = strings.TrimSpace(maxRule); maxRule != "" {
+		v, err := strconv.ParseFloat(maxRule, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maximum of range %q: %w", rule, err)
+		}
+		maxValue = v
+	}
+	if minValue > maxValue {
+		return nil, fmt.Errorf("invalid range %q, minimum exceeds maximum", rule)
+	}
+	return func(value SourceValue) error {
+		converted, err := value.ToMMDBType(optim)
+		if err != nil {
+			return err
+		}
+		if converted == nil {
+			// Skipped values are not stored.
+			return nil
+		}
+		v, ok := mmdbNumber(converted)
+		if !ok {
+			return fmt.Errorf("is not a number, expected range %s", rule)
+		}
+		if v < minValue || v > maxValue {
+			return fmt.Errorf("is not within range %s", rule)
+		}
+		return nil
+	}, nil
+}
+
+// mmdbNumber returns the numeric mmdb value as float64.
+func mmdbNumber(value mmdbtype.DataType) (float64, bool) {
+	switch v := value.(type) {
+	case mmdbtype.Int32:
+		return float64(v), true
+	case mmdbtype.Uint16:
+		return float64(v), true
+	case mmdbtype.Uint32:
+		return float64(v), true
+	case mmdbtype.Uint64:
+		return float64(v), true
+	case *mmdbtype.Uint128:
+		f, _ := new(big.Float).SetInt((*big.Int)(v)).Float64()
+		return f, true
+	case mmdbtype.Float32:
+		return float64(v), true
+	case mmdbtype.Float64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// conditionFunc reports whether a condition holds for the raw values of an
+// entry.
+type conditionFunc func(values map[string]SourceValue) bool
+
+// parseCondition returns the condition defined by the given expression in the
+// form "field==literal" or "field!=literal". Missing fields compare as empty.
+func parseCondition(expression string) (conditionFunc, error) {
+	i := strings.Index(expression, "==")
+	if j := strings.Index(expression, "!="); j >= 0 && (i < 0 || j < i) {
+		i = j
+	}
+	if i < 0 {
+		return nil, fmt.Errorf("invalid condition %q, expected field==value or field!=value", expression)
+	}
+	field := strings.TrimSpace(expression[:i])
+	equal := expression[i] == '='
+	literal := strings.TrimSpace(expression[i+2:])
+	if field == "" {
+		return nil, fmt.Errorf("invalid condition %q, field is missing", expression)
+	}
+
+	return func(values map[string]SourceValue) bool {
+		return (values[field].Value == literal) == equal
+	}, nil
+}
+
+// isNumericType returns whether the field type holds numbers.
+func isNumericType(fieldType string) bool {
+	if _, ok := intTypes[fieldType]; ok || strings.HasPrefix(fieldType, "scaledint:") {
+		return true
+	}
+	switch fieldType {
+	case "uint128", "float32", "float64", "percent", "permille", "latitude", "longitude":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package mmdbmeld
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Validate loads all sources of the given config and converts every entry,
+// without building the database. If collectAll is false, it returns at the
+// first error. Otherwise, all errors are collected and returned together.
+func Validate(dbConfig DatabaseConfig, collectAll bool) error {
+	if err := dbConfig.MMDB.Validate(); err != nil {
+		return fmt.Errorf("invalid mmdb config for %s: %w", dbConfig.Name, err)
+	}
+	if err := dbConfig.Merge.Validate(); err != nil {
+		return fmt.Errorf("invalid merge config for %s: %w", dbConfig.Name, err)
+	}
+	if err := dbConfig.Optimize.Validate(); err != nil {
+		return fmt.Errorf("invalid optimizations for %s: %w", dbConfig.Name, err)
+	}
+
+	sources, err := LoadSources(dbConfig)
+	if err != nil {
+		return err
+	}
+	defer closeSources(sources)
+
+	var errs []error
+	for _, source := range sources {
+		for {
+			entry, err := source.NextEntry()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to parse entry: %w", err))
+				if !collectAll {
+					return errs[0]
+				}
+				continue
+			}
+			if entry == nil {
+				break
+			}
+
+			if err := entry.ApplyMappings(dbConfig.Mappings, dbConfig.Optimize); err != nil {
+				errs = append(errs, fmt.Errorf("%s: failed to apply mappings: %w", entryPosition(source, entry), err))
+				if !collectAll {
+					return errs[0]
+				}
+				continue
+			}
+
+			_, mapErrs := entry.ToMMDBMapCollect(dbConfig.Optimize)
+			for _, err := range mapErrs {
+				errs = append(errs, fmt.Errorf("%s: %w", entryPosition(source, entry), err))
+				if !collectAll {
+					return errs[0]
+				}
+			}
+
+			if _, err := entry.Networks(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", entryPosition(source, entry), err))
+				if !collectAll {
+					return errs[0]
+				}
+			}
+		}
+		if source.Err() != nil {
+			errs = append(errs, fmt.Errorf("source %s failed: %w", source.Name(), source.Err()))
+			if !collectAll {
+				return errs[0]
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// CheckTypes loads all sources of the given config and compares the declared
+// types with the fields of the first entry of every source. It reports
+// declared types of fields that do not appear in any source, and fields of
+// sources that have no type declared. Fields ignored with the "-" type are
+// not reported.
+// If lenient is false, the findings are returned as an error. Otherwise, they
+// are returned as warnings and only failing sources return an error.
+func CheckTypes(dbConfig DatabaseConfig, lenient bool) (warnings []string, err error) {
+	sources, err := LoadSources(dbConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer closeSources(sources)
+
+	seen := make(map[string]bool)
+	inputs, err := expandInputs(dbConfig.Inputs)
+	if err != nil {
+		return nil, err
+	}
+	inputs = inputsByPriority(inputs, dbConfig.Merge)
+	for i, source := range sources {
+		types := inputTypes(dbConfig.Types, inputs[i])
+		entry, err := source.NextEntry()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse entry: %w", err)
+		}
+		if source.Err() != nil {
+			return nil, fmt.Errorf("source %s failed: %w", source.Name(), source.Err())
+		}
+		if entry == nil {
+			continue
+		}
+
+		// Values may also be set by defaults.
+		for field := range entry.Values {
+			seen[field] = true
+		}
+
+		s, ok := source.(interface{ FieldNames() []string })
+		if !ok {
+			continue
+		}
+		switch source.(type) {
+		case *CSVSource, *XLSXSource:
+			// Already checked when loading the source.
+			types, _ = resolveColumnTypes(types, s.FieldNames())
+		}
+		names := slices.Clone(s.FieldNames())
+		slices.Sort(names)
+		for _, field := range slices.Compact(names) {
+			field = strings.TrimSuffix(field, arrayKeySuffix)
+			switch field {
+			case "", "-", "from", "to", "network", "start", "end":
+				continue
+			}
+			seen[field] = true
+			if _, ok := types[field]; !ok {
+				warnings = append(warnings, fmt.Sprintf("%s: field %s has no type", source.Name(), field))
+			}
+		}
+	}
+
+	typeKeys := make([]string, 0, len(dbConfig.Types))
+	for field := range dbConfig.Types {
+		// Positional types of columns are resolved to field names.
+		if _, ok := columnIndex(field); ok {
+			continue
+		}
+		typeKeys = append(typeKeys, field)
+	}
+	slices.Sort(typeKeys)
+	for _, field := range typeKeys {
+		if !seen[field] && dbConfig.Types[field] != "-" {
+			warnings = append(warnings, fmt.Sprintf("type of %s is declared, but the field does not appear in any source", field))
+		}
+	}
+
+	if lenient || len(warnings) == 0 {
+		return warnings, nil
+	}
+	errs := make([]error, 0, len(warnings))
+	for _, warning := range warnings {
+		errs = append(errs, errors.New(warning))
+	}
+	return nil, errors.Join(errs...)
+}
@@ -0,0 +1,96 @@
+package mmdbmeld
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "test.csv")
+	data := "192.0.2.0,192.0.2.255,AT,1\n" +
+		"x,192.0.3.255,AT,1\n" +
+		"192.0.4.0,192.0.4.255,DE,x\n"
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dbConfig := DatabaseConfig{
+		Name: "Test",
+		Types: map[string]string{
+			"country.iso_code":         "string",
+			"autonomous_system_number": "uint32",
+		},
+		Inputs: []DatabaseInput{{
+			File:   file,
+			Fields: []string{"from", "to", "country.iso_code", "autonomous_system_number"},
+		}},
+	}
+
+	// Fail fast.
+	err := Validate(dbConfig, false)
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected error for line 2, got %v", err)
+	}
+
+	// Collect all errors.
+	err = Validate(dbConfig, true)
+	var joined interface{ Unwrap() []error }
+	if !errors.As(err, &joined) || len(joined.Unwrap()) != 2 {
+		t.Fatalf("expected two errors, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "line 3") {
+		t.Fatalf("expected error for line 3, got %v", err)
+	}
+}
+
+func TestCheckTypes(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	csvFile := filepath.Join(dir, "test.csv")
+	if err := os.WriteFile(csvFile, []byte("192.0.2.0,192.0.2.255,AT,64496\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	jsonFile := filepath.Join(dir, "test.json")
+	if err := os.WriteFile(jsonFile, []byte(`[{"network":"198.51.100.0/24","location":{"latitude":1,"longitude":2}}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dbConfig := DatabaseConfig{
+		Name: "Test",
+		Types: map[string]string{
+			"country.iso_code":         "string",
+			"autonomous_system_nubmer": "uint32",
+			"location.latitude":        "float32",
+			"is_anycast":               "bool",
+			"ignored":                  "-",
+		},
+		Inputs: []DatabaseInput{
+			{File: csvFile, Fields: []string{"from", "to", "country.iso_code", "autonomous_system_number"}},
+			{File: jsonFile, Defaults: map[string]string{"is_anycast": "false"}},
+		},
+	}
+	expected := []string{
+		csvFile + ": field autonomous_system_number has no type",
+		jsonFile + ": field location.longitude has no type",
+		"type of autonomous_system_nubmer is declared, but the field does not appear in any source",
+	}
+
+	// Findings are returned as an error.
+	_, err := CheckTypes(dbConfig, false)
+	if err == nil || err.Error() != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Findings are returned as warnings in lenient mode.
+	warnings, err := CheckTypes(dbConfig, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(warnings, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected warnings: %v", warnings)
+	}
+}
@@ -0,0 +1,96 @@
+package mmdbmeld
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// BuildAndVerify builds the database of the given config in memory and looks
+// up every IP of the checks. The fields of the record must match the
+// expected values, which are given by their dotted key, eg.
+// "country.iso_code". A nil value expects the field to be missing.
+// Values are compared by their formatted value, so that eg. an expected int
+// matches a stored uint32. All mismatches are returned together.
+func BuildAndVerify(dbConfig DatabaseConfig, checks map[string]map[string]any) error {
+	// Check IPs before building.
+	ips := make([]string, 0, len(checks))
+	for ip := range checks {
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("invalid ip %q in checks", ip)
+		}
+		ips = append(ips, ip)
+	}
+	slices.Sort(ips)
+
+	var buf bytes.Buffer
+	dbConfig.Compress = "" // The checks read the database from memory.
+	if err := BuildToWriter(dbConfig, &buf); err != nil {
+		return err
+	}
+	reader, err := maxminddb.FromBytes(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to read built database: %w", err)
+	}
+
+	var errs []error
+	for _, ip := range ips {
+		var record any
+		if err := reader.Lookup(net.ParseIP(ip), &record); err != nil {
+			errs = append(errs, fmt.Errorf("%s: lookup failed: %w", ip, err))
+			continue
+		}
+
+		keys := make([]string, 0, len(checks[ip]))
+		for key := range checks[ip] {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			expected := checks[ip][key]
+			value, ok := lookupKey(record, key)
+			switch {
+			case !ok && expected == nil:
+				// Expected to be missing.
+			case !ok:
+				errs = append(errs, fmt.Errorf("%s: %s is missing, expected %v", ip, key, expected))
+			case expected == nil:
+				errs = append(errs, fmt.Errorf("%s: %s is %v, expected it to be missing", ip, key, value))
+			case fmt.Sprintf("%v", value) != fmt.Sprintf("%v", expected):
+				errs = append(errs, fmt.Errorf("%s: %s is %v, expected %v", ip, key, value, expected))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// lookupKey returns the value of the dotted key in the decoded record.
+// Numeric key parts are used as indexes of arrays.
+func lookupKey(record any, key string) (any, bool) {
+	value := record
+	for _, part := range strings.Split(key, ".") {
+		switch v := value.(type) {
+		case map[string]any:
+			next, ok := v[part]
+			if !ok {
+				return nil, false
+			}
+			value = next
+		case []any:
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil, false
+			}
+			value = v[index]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
@@ -0,0 +1,77 @@
+package mmdbmeld
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildAndVerify(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "test.csv")
+	data := "192.0.2.0,192.0.2.255,AT,64496,AS 1|AS 2\n" +
+		"198.51.100.0,198.51.100.255,DE,64497,\n"
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dbConfig := DatabaseConfig{
+		Name: "Test",
+		MMDB: MMDBConfig{IPVersion: 6, RecordSize: 24},
+		Types: map[string]string{
+			"country.iso_code":         "string",
+			"autonomous_system_number": "uint32",
+			"names":                    "array:string:|",
+		},
+		Inputs: []DatabaseInput{{
+			File:   file,
+			Fields: []string{"from", "to", "country.iso_code", "autonomous_system_number", "names"},
+		}},
+	}
+
+	err := BuildAndVerify(dbConfig, map[string]map[string]any{
+		"192.0.2.1": {
+			"country.iso_code":         "AT",
+			"autonomous_system_number": 64496,
+			"names.1":                  "AS 2",
+		},
+		"198.51.100.1": {
+			"country.iso_code": "DE",
+			"names.0":          nil,
+		},
+		"203.0.113.1": {
+			"country.iso_code": nil,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Mismatches are reported together.
+	err = BuildAndVerify(dbConfig, map[string]map[string]any{
+		"192.0.2.1": {
+			"country.iso_code":         "DE",
+			"autonomous_system_number": nil,
+		},
+		"203.0.113.1": {
+			"country.iso_code": "AT",
+		},
+	})
+	if err == nil {
+		t.Fatal("expected error for mismatches")
+	}
+	for _, expected := range []string{
+		"192.0.2.1: country.iso_code is AT, expected DE",
+		"192.0.2.1: autonomous_system_number is 64496, expected it to be missing",
+		"203.0.113.1: country.iso_code is missing, expected AT",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf("expected %q in error: %s", expected, err)
+		}
+	}
+
+	if err := BuildAndVerify(dbConfig, map[string]map[string]any{"x": nil}); err == nil {
+		t.Fatal("expected error for invalid ip")
+	}
+}
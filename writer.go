@@ -1,42 +1,285 @@
 package mmdbmeld
 
 import (
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net"
-	"net/netip"
 	"os"
 	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/maxmind/mmdbwriter"
 	"github.com/maxmind/mmdbwriter/inserter"
 	"github.com/maxmind/mmdbwriter/mmdbtype"
-	"go4.org/netipx"
 )
 
 const reportSlotSize = 100_000
 
+// progressInterval is the amount of entries after which the progress function
+// is called.
+const progressInterval = 10_000
+
 // WriteMMDB writes a mmdb file using given config and sources.
 // Supply an updates channel to receive update messages about the progress.
 func WriteMMDB(dbConfig DatabaseConfig, sources []Source, updates chan string) error {
+	return WriteMMDBContext(context.Background(), dbConfig, sources, updates)
+}
+
+// WriteMMDBContext is like WriteMMDB, but stops reading the sources when the
+// context is canceled.
+func WriteMMDBContext(ctx context.Context, dbConfig DatabaseConfig, sources []Source, updates chan string) error {
+	_, err := WriteMMDBWithStats(ctx, dbConfig, sources, updates)
+	return err
+}
+
+// BuildStats holds statistics about a database build.
+type BuildStats struct {
+	// Records is the amount of entries inserted into the database.
+	Records int
+	// Networks is the amount of networks inserted into the database, summed
+	// over all outputs.
+	Networks int
+	// Duplicates is the amount of inserts dropped by DedupInserts, summed
+	// over all outputs.
+	Duplicates int
+	// InternLookups is the amount of values of interned fields, of which
+	// InternHits reused a previous equal value.
+	InternLookups int
+	InternHits    int
+	// Sources holds the statistics of every source, in processing order.
+	Sources []SourceStats
+	// Outputs holds the statistics of every output, in the order of
+	// DatabaseConfig.Output and DatabaseConfig.Outputs.
+	Outputs []OutputStats
+	// BytesWritten is the size of the written database, summed over all
+	// outputs.
+	BytesWritten int64
+	// UncompressedBytes is the size of the database before compression,
+	// summed over all outputs.
+	UncompressedBytes int64
+	// Duration is the time the build took.
+	Duration time.Duration
+}
+
+// InternHitRate returns the share of values of interned fields that reused a
+// previous equal value, from 0 to 1.
+func (s *BuildStats) InternHitRate() float64 {
+	if s.InternLookups == 0 {
+		return 0
+	}
+	return float64(s.InternHits) / float64(s.InternLookups)
+}
+
+// EmptySources returns the names of the sources that yielded no entries.
+func (s *BuildStats) EmptySources() []string {
+	var names []string
+	for _, source := range s.Sources {
+		if source.Entries == 0 {
+			names = append(names, source.Name)
+		}
+	}
+	return names
+}
+
+// OutputStats holds statistics about an output of a database build.
+type OutputStats struct {
+	Output string
+	// Networks is the amount of networks inserted into the output.
+	Networks int
+	// BytesWritten is the size of the written output.
+	BytesWritten int64
+	// UncompressedBytes is the size of the output before compression. It
+	// equals BytesWritten for uncompressed outputs.
+	UncompressedBytes int64
+}
+
+// SourceStats holds statistics about a source of a database build.
+type SourceStats struct {
+	Name string
+	// Entries is the amount of entries read from the source.
+	Entries int
+	// Records is the amount of entries of the source inserted into the database.
+	Records int
+	// Filtered is the amount of networks of the source skipped by the
+	// network filter of the input.
+	Filtered int
+	// SpecialUse is the amount of networks of the source that were skipped
+	// or clipped, as they overlap with special-use networks.
+	SpecialUse int
+}
+
+// WriteMMDBWithStats is like WriteMMDBContext, but also returns statistics
+// about the build.
+func WriteMMDBWithStats(ctx context.Context, dbConfig DatabaseConfig, sources []Source, updates chan string) (*BuildStats, error) {
+	// Close update channel when finished.
+	if updates != nil {
+		defer close(updates)
+	}
+
+	// Open output files to detect errors before processing.
+	outputs := dbConfig.outputs()
+	for i, output := range outputs {
+		path, err := expandPath(output.Output)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path of output file %s for %s: %w", output.Output, dbConfig.Name, err)
+		}
+		output.Output = path
+		outputs[i].Output = compressedPath(output)
+	}
+	if err := validateOutputs(outputs); err != nil {
+		return nil, fmt.Errorf("invalid outputs of %s: %w", dbConfig.Name, err)
+	}
+	outputFiles := make([]*os.File, 0, len(outputs))
+	defer func() {
+		for _, outputFile := range outputFiles {
+			_ = outputFile.Close()
+		}
+	}()
+	for _, output := range outputs {
+		outputFile, err := os.Create(output.Output)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open output file %s for %s: %w", output.Output, dbConfig.Name, err)
+		}
+		outputFiles = append(outputFiles, outputFile)
+	}
+
+	totalStartTime := time.Now()
+	writers, stats, err := buildMMDB(ctx, dbConfig, sources, updates, outputFields(outputs))
+	if err != nil {
+		return nil, err
+	}
+
+	// Write final dbs to files.
+	outputNames := make([]string, 0, len(outputs))
+	for i, writer := range writers {
+		outputStats := &stats.Outputs[i]
+		outputStats.Output = outputs[i].Output
+		outputStats.BytesWritten, outputStats.UncompressedBytes, err = writeOutput(writer, outputFiles[i], outputs[i].Compress)
+		if err != nil {
+			return nil, fmt.Errorf("faild to write %s to output file %s: %w", dbConfig.Name, outputs[i].Output, err)
+		}
+		if err := outputFiles[i].Close(); err != nil {
+			return nil, fmt.Errorf("failed to close output file %s of %s: %w", outputs[i].Output, dbConfig.Name, err)
+		}
+		stats.BytesWritten += outputStats.BytesWritten
+		stats.UncompressedBytes += outputStats.UncompressedBytes
+		outputNames = append(outputNames, outputs[i].Output)
+	}
+	stats.Duration = time.Since(totalStartTime)
+
+	// Send final upate.
+	sendUpdate(updates, fmt.Sprintf(
+		"---\n%s finished: inserted %d entries in %s, resulting in %.2f MB written to %s",
+		dbConfig.Name,
+		stats.Records,
+		stats.Duration.Round(time.Second),
+		float64(stats.BytesWritten)/1000000,
+		strings.Join(outputNames, ", "),
+	))
+
+	return stats, nil
+}
+
+// BuildToWriter loads the sources of the given config, builds the mmdb and
+// writes it to w, compressed as defined by DatabaseConfig.Compress. The output
+// file of the config is not used, and additional outputs are not built.
+// The writer is not closed.
+func BuildToWriter(dbConfig DatabaseConfig, w io.Writer) error {
+	if err := validateCompress(dbConfig.Compress); err != nil {
+		return err
+	}
+	sources, err := LoadSources(dbConfig)
+	if err != nil {
+		return err
+	}
+
+	writers, _, err := buildMMDB(context.Background(), dbConfig, sources, nil, [][][]string{nil})
+	if err != nil {
+		return err
+	}
+
+	_, _, err = writeOutput(writers[0], w, dbConfig.Compress)
+	if err != nil {
+		return fmt.Errorf("faild to write %s: %w", dbConfig.Name, err)
+	}
+	return nil
+}
+
+// buildMMDB builds a mmdb tree for each of the given field selections of the
+// outputs from the given sources, which are read once.
+// It returns the trees and the statistics of the build, without the output
+// sizes and duration.
+func buildMMDB(ctx context.Context, dbConfig DatabaseConfig, sources []Source, updates chan string, outputFields [][][]string) ([]*mmdbwriter.Tree, *BuildStats, error) {
+	defer closeSources(sources)
+
 	// Init writer.
 	opts := mmdbwriter.Options{
 		DatabaseType:            dbConfig.Name,
-		IncludeReservedNetworks: true,
-		DisableIPv4Aliasing:     true,
+		IncludeReservedNetworks: dbConfig.MMDB.ReservedNetworksIncluded(),
+		DisableIPv4Aliasing:     dbConfig.MMDB.IPv4AliasingDisabled(),
 		IPVersion:               dbConfig.MMDB.IPVersion,
-		RecordSize:              dbConfig.MMDB.RecordSize,
+		RecordSize:              dbConfig.MMDB.RecordSizeOrDefault(),
 		Description: map[string]string{
 			"en": "IPv4 and IPv6 GeoIP Database",
 		},
 		Languages: []string{
 			"en",
-		},		
+		},
+		BuildEpoch: dbConfig.MMDB.BuildEpoch,
 	}
-	writer, err := mmdbwriter.New(opts)
-	if err != nil {
-		return fmt.Errorf("failed to create mmdb writer for %s: %w", dbConfig.Name, err)
+	if dbConfig.MMDB.DatabaseType != "" {
+		opts.DatabaseType = dbConfig.MMDB.DatabaseType
+	}
+	if len(dbConfig.MMDB.Description) > 0 {
+		opts.Description = dbConfig.MMDB.Description
+	}
+	if len(dbConfig.MMDB.Languages) > 0 {
+		opts.Languages = dbConfig.MMDB.Languages
+	}
+	if err := dbConfig.MMDB.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid mmdb config for %s: %w", dbConfig.Name, err)
+	}
+	if err := dbConfig.Merge.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid merge config for %s: %w", dbConfig.Name, err)
+	}
+	if err := dbConfig.Optimize.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid optimizations for %s: %w", dbConfig.Name, err)
+	}
+	overflows := &atomic.Int64{}
+	dbConfig.Optimize.overflows = overflows
+	dbConfig.Merge.onConflict = dbConfig.OnConflict
+	for _, input := range dbConfig.Inputs {
+		if input.AddSourceField != "" && !slices.Contains(dbConfig.Merge.sourceFields, input.AddSourceField) {
+			dbConfig.Merge.sourceFields = append(dbConfig.Merge.sourceFields, input.AddSourceField)
+		}
+	}
+	var interner *valueInterner
+	if len(dbConfig.Optimize.Intern) > 0 {
+		interner = newValueInterner()
+		dbConfig.Optimize.interner = interner
+	}
+	targets := make([]*buildTarget, 0, len(outputFields))
+	for _, fields := range outputFields {
+		writer, err := mmdbwriter.New(opts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create mmdb writer for %s: %w", dbConfig.Name, err)
+		}
+		target := &buildTarget{fields: fields, writer: writer}
+		// Drop duplicate inserts, if enabled.
+		if dbConfig.Optimize.DedupInserts {
+			target.inserted = make(map[[sha256.Size]byte]struct{})
+		}
+		// Aggregate networks of consecutive entries with identical records, if enabled.
+		if dbConfig.Optimize.AggregateNetworks {
+			target.aggregator = &networkAggregator{}
+		}
+		targets = append(targets, target)
 	}
 	sendUpdate(updates, fmt.Sprintf(
 		"database options set: IPVersion=%d RecordSize=%d (IncludeReservedNetworks=%v DisableIPv4Aliasing=%v)",
@@ -57,163 +300,516 @@ func WriteMMDB(dbConfig DatabaseConfig, sources []Source, updates chan string) e
 		strings.Join(typeKeys, ", "),
 	))
 	sendUpdate(updates, fmt.Sprintf(
-		"optimizations set: FloatDecimals=%d ForceIPVersion=%v MaxPrefix=%d",
+		"optimizations set: FloatDecimals=%d FieldFloatDecimals=%v ForceIPVersion=%v MaxPrefix=%d ShrinkInts=%v AggregateNetworks=%v DedupInserts=%v LenientNumbers=%v NormalizeStrings=%v OverflowMode=%s",
 		dbConfig.Optimize.FloatDecimals,
+		dbConfig.Optimize.FieldFloatDecimals,
 		dbConfig.Optimize.ForceIPVersionEnabled(),
 		dbConfig.Optimize.MaxPrefix,
+		dbConfig.Optimize.ShrinkInts,
+		dbConfig.Optimize.AggregateNetworks,
+		dbConfig.Optimize.DedupInserts,
+		dbConfig.Optimize.LenientNumbers,
+		dbConfig.Optimize.NormalizeStrings,
+		dbConfig.Optimize.OverflowMode,
 	))
 	sendUpdate(updates, fmt.Sprintf(
-		"merge config: AlwaysReplace=%v MergeArrays=%v ConditionalResets=%+v",
+		"merge config: Strategy=%s AlwaysReplace=%v MergeArrays=%v ArrayMerge=%s FieldArrayMerge=%v ConditionalResets=%+v",
+		dbConfig.Merge.StrategyName(),
 		dbConfig.Merge.AlwaysReplace,
 		dbConfig.Merge.MergeArrays,
+		dbConfig.Merge.arrayMergeFor(""),
+		dbConfig.Merge.FieldArrayMerge,
 		dbConfig.Merge.ConditionalResets,
 	))
 
-	// Close update channel when finished.
-	if updates != nil {
-		defer close(updates)
-	}
-
-	// Open output file to detect errors before processing.
-	outputFile, err := os.Create(dbConfig.Output)
-	if err != nil {
-		return fmt.Errorf("failed to open output file for %s: %w", dbConfig.Name, err)
+	// Track networks to detect overlaps, if needed.
+	var overlaps *overlapTracker
+	if dbConfig.StrictOverlap || dbConfig.OnOverlap != nil {
+		overlaps = &overlapTracker{}
 	}
 
 	// Process sources.
 	var (
-		totalInserts   int
-		totalStartTime = time.Now()
-		slotStartTime  = time.Now()
+		stats         = &BuildStats{}
+		slotStartTime = time.Now()
 	)
-	for _, source := range sources {
-		var inserted int
+
+	// Read and convert sources in parallel, if enabled.
+	// Entries are still inserted in source order.
+	var channels []chan preparedEntry
+	if dbConfig.Workers > 1 {
+		readCtx, cancel := context.WithCancel(ctx)
+		channels = readSourcesParallel(readCtx, dbConfig, sources, dbConfig.Workers)
+		// Stop the readers and wait for them to finish, before the sources are closed.
+		defer func() {
+			cancel()
+			for _, c := range channels {
+				for range c {
+					// Discard remaining entries.
+				}
+			}
+		}()
+	}
+
+	for i, source := range sources {
 		sendUpdate(updates, fmt.Sprintf("---\nprocessing %s...", source.Name()))
+		stats.Sources = append(stats.Sources, SourceStats{Name: source.Name()})
+		sourceStats := &stats.Sources[len(stats.Sources)-1]
+		var skippedIPv6 int
+		filter, _ := source.(interface{ IncludesNetwork(*net.IPNet) bool })
+		progress := newSourceProgress(dbConfig, source)
+
+		next := func() (preparedEntry, bool) {
+			return prepareEntry(ctx, dbConfig, source)
+		}
+		if channels != nil {
+			c := channels[i]
+			next = func() (preparedEntry, bool) {
+				prepared, ok := <-c
+				return prepared, ok
+			}
+		}
 
 		for {
-			entry, err := source.NextEntry()
-			if err != nil {
-				sendUpdate(updates, fmt.Sprintf("failed to parse entry: %s", err.Error()))
+			prepared, ok := next()
+			if !ok {
+				break
+			}
+			if prepared.entry != nil {
+				sourceStats.Entries++
+				if sourceStats.Entries%progressInterval == 0 {
+					progress.report(sourceStats.Entries, false)
+				}
+			}
+			if prepared.err != nil {
+				sendUpdate(updates, prepared.err.Error())
 				continue
 			}
-			if entry == nil {
-				break
+			entry := prepared.entry
+
+			// Select the fields of every output.
+			records := make([]mmdbtype.Map, len(targets))
+			for j, target := range targets {
+				records[j] = projectRecord(prepared.record, target.fields)
 			}
 
-			mmdbMap, err := entry.ToMMDBMap(dbConfig.Optimize)
-			if err != nil {
-				sendUpdate(updates, fmt.Sprintf("failed to convert %+v to mmdb map: %s", entry, err.Error()))
-				continue
+			// Remove special-use networks, if enabled.
+			networks := prepared.networks
+			if dbConfig.SkipSpecialUse {
+				var removed int
+				networks, removed = withoutSpecialUse(networks)
+				sourceStats.SpecialUse += removed
 			}
 
-			if entry.Net != nil {
-				// Handle Network/Prefix Format.
+			var insertedNetworks int
+			for _, network := range networks {
+				// Store IPv4-mapped IPv6 networks as IPv4, so that they end up in the same subtree.
+				network = NormalizeNetwork(network)
 
-				// Ignore entry if the IP version is forced and it does not match the mmdb DB.
-				if dbConfig.Optimize.ForceIPVersionEnabled() && ipVersion(entry.Net.IP) != opts.IPVersion {
+				// Skip networks filtered by the input.
+				if filter != nil && !filter.IncludesNetwork(network) {
+					sourceStats.Filtered++
 					continue
 				}
 
-				// Ignore entry if prefix is greater than the max prefix.
+				// Ignore network if the IP version is forced and it does not match the mmdb DB.
+				if dbConfig.Optimize.ForceIPVersionEnabled() && ipVersion(network.IP) != opts.IPVersion {
+					continue
+				}
+
+				// Skip IPv6 networks in IPv4 databases.
+				if opts.IPVersion == 4 && ipVersion(network.IP) == 6 {
+					skippedIPv6++
+					continue
+				}
+
+				// Ignore network if prefix is greater than the max prefix.
 				if dbConfig.Optimize.MaxPrefix > 0 {
-					prefixBits, _ := entry.Net.Mask.Size()
+					prefixBits, _ := network.Mask.Size()
 					if prefixBits > dbConfig.Optimize.MaxPrefix {
 						continue
 					}
 				}
 
-				err = writer.InsertFunc(entry.Net, Inserter(mmdbMap, dbConfig.Merge))
-				if err != nil {
-					sendUpdate(updates, fmt.Sprintf("failed to insert %+v: %s", entry, err.Error()))
-					continue
+				// Check for overlaps with previously inserted networks.
+				if overlaps != nil {
+					if overlap := overlaps.insert(network, source.Name()); overlap != nil {
+						if dbConfig.OnOverlap != nil {
+							dbConfig.OnOverlap(*overlap)
+						}
+						if dbConfig.StrictOverlap && dbConfig.Merge.StrategyName() != MergeStrategyDeep {
+							return nil, nil, fmt.Errorf("strict overlap check failed: %s", overlap)
+						}
+					}
 				}
-			} else {
-				// Handle From-To IP Format.
 
-				// Ignore entry if the IP version is forced and it does not match the mmdb DB.
-				if dbConfig.Optimize.ForceIPVersionEnabled() && ipVersion(entry.From) != opts.IPVersion {
-					continue
-				}
+				var inserted bool
+				for j, target := range targets {
+					// Skip outputs without any of their fields.
+					record := records[j]
+					if record == nil {
+						continue
+					}
 
-				start, ok1 := netip.AddrFromSlice(entry.From)
-				end, ok2 := netip.AddrFromSlice(entry.To)
-				if !ok1 || !ok2 {
-					sendUpdate(updates, fmt.Sprintf("range with invalid IPs: %s - %s", entry.From, entry.To))
-					continue
-				}
+					// Skip exact duplicates of previous inserts, if enabled.
+					if target.duplicate(network, record) {
+						stats.Duplicates++
+						continue
+					}
 
-				r := netipx.IPRangeFrom(start, end)
-				if !r.IsValid() {
-					sendUpdate(updates, fmt.Sprintf("range is invalid: %s - %s", entry.From, entry.To))
-					continue
-				}
-				subnets := r.Prefixes()
-				for _, subnet := range subnets {
-					// Ignore entry if prefix is greater than the max prefix.
-					if dbConfig.Optimize.MaxPrefix > 0 && subnet.Bits() > dbConfig.Optimize.MaxPrefix {
+					// Collect network for aggregation, if enabled.
+					if target.aggregator != nil {
+						if !target.aggregator.add(network, record) {
+							if err := target.flush(dbConfig.Merge, updates); err != nil {
+								return nil, nil, err
+							}
+							if !target.aggregator.add(network, record) {
+								sendUpdate(updates, fmt.Sprintf("failed to aggregate %s of %+v", network, entry))
+								continue
+							}
+						}
+						inserted = true
 						continue
 					}
 
-					err = writer.InsertFunc(netipx.PrefixIPNet(subnet), Inserter(mmdbMap, dbConfig.Merge))
+					err := target.writer.InsertFunc(network, insertFunc(network, record, dbConfig.Merge))
 					if err != nil {
+						if errors.As(err, new(*conflictError)) {
+							return nil, nil, err
+						}
 						sendUpdate(updates, fmt.Sprintf("failed to insert %+v: %s", entry, err.Error()))
 						continue
 					}
+					target.networks++
+					inserted = true
 				}
+				if inserted {
+					insertedNetworks++
+				}
+			}
+			if insertedNetworks == 0 {
+				continue
 			}
 
-			inserted++
-			totalInserts++
-			if inserted%reportSlotSize == 0 {
+			sourceStats.Records++
+			stats.Records++
+			if sourceStats.Records%reportSlotSize == 0 {
 				sendUpdate(updates, fmt.Sprintf(
 					"inserted %d entries - batch in %s (%s/op)",
-					inserted,
+					sourceStats.Records,
 					time.Since(slotStartTime).Round(time.Millisecond),
 					(time.Since(slotStartTime)/reportSlotSize).Round(time.Microsecond),
 				))
 				slotStartTime = time.Now()
 			}
 		}
+		for _, target := range targets {
+			if err := target.flush(dbConfig.Merge, updates); err != nil {
+				return nil, nil, err
+			}
+		}
+		progress.report(sourceStats.Entries, source.Err() == nil && ctx.Err() == nil)
 		if source.Err() != nil {
-			return fmt.Errorf("source %s failed: %w", source.Name(), source.Err())
+			return nil, nil, fmt.Errorf("source %s failed: %w", source.Name(), source.Err())
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, nil, fmt.Errorf("source %s failed: %w", source.Name(), err)
+		}
+		if s, ok := source.(interface{ Skipped() int }); ok && s.Skipped() > 0 {
+			sendUpdate(updates, fmt.Sprintf("skipped %d invalid entries", s.Skipped()))
+		}
+		if s, ok := source.(interface{ Summaries() int }); ok && s.Summaries() > 0 {
+			sendUpdate(updates, fmt.Sprintf("skipped %d version headers and summary lines", s.Summaries()))
+		}
+		if s, ok := source.(interface{ Unsupported() int }); ok && s.Unsupported() > 0 {
+			sendUpdate(updates, fmt.Sprintf("skipped %d records of unsupported types", s.Unsupported()))
+		}
+		if s, ok := source.(interface{ Ambiguous() int }); ok && s.Ambiguous() > 0 {
+			sendUpdate(updates, fmt.Sprintf("skipped %d prefixes without an unambiguous origin AS", s.Ambiguous()))
+		}
+		if s, ok := source.(interface{ Undelegated() int }); ok && s.Undelegated() > 0 {
+			sendUpdate(updates, fmt.Sprintf("skipped %d records of resources that are not delegated", s.Undelegated()))
+		}
+		if s, ok := source.(interface{ RaggedRows() int }); ok && s.RaggedRows() > 0 {
+			sendUpdate(updates, fmt.Sprintf("warning: read %d rows with fewer or more columns than fields", s.RaggedRows()))
+		}
+		if sourceStats.Filtered > 0 {
+			sendUpdate(updates, fmt.Sprintf("skipped %d networks by network filter", sourceStats.Filtered))
+		}
+		if sourceStats.SpecialUse > 0 {
+			sendUpdate(updates, fmt.Sprintf("skipped or clipped %d networks overlapping special-use networks", sourceStats.SpecialUse))
+		}
+		if skippedIPv6 > 0 {
+			sendUpdate(updates, fmt.Sprintf("warning: skipped %d IPv6 networks, as the database is IPv4 only", skippedIPv6))
+		}
+		if sourceStats.Entries == 0 {
+			sendUpdate(updates, "warning: source yielded no entries")
 		}
 		sendUpdate(updates, fmt.Sprintf(
 			"inserted %d entries - batch in %s (%s/op)",
-			inserted,
+			sourceStats.Records,
 			time.Since(slotStartTime).Round(time.Millisecond),
 			(time.Since(slotStartTime)/reportSlotSize).Round(time.Microsecond),
 		))
 	}
+	if stats.Duplicates > 0 {
+		sendUpdate(updates, fmt.Sprintf("dropped %d duplicate inserts (DedupInserts=true)", stats.Duplicates))
+	}
+	if interner != nil {
+		stats.InternLookups, stats.InternHits = interner.stats()
+		sendUpdate(updates, fmt.Sprintf(
+			"reused %d of %d values of interned fields (%.1f%% hit rate)",
+			stats.InternHits,
+			stats.InternLookups,
+			stats.InternHitRate()*100,
+		))
+	}
+	if n := overflows.Load(); n > 0 {
+		sendUpdate(updates, fmt.Sprintf(
+			"handled %d integer values that overflowed their type (OverflowMode=%s)",
+			n,
+			dbConfig.Optimize.OverflowMode,
+		))
+	}
+	if empty := stats.EmptySources(); dbConfig.RequireNonEmpty && len(empty) > 0 {
+		return nil, nil, fmt.Errorf("sources of %s yielded no entries: %s", dbConfig.Name, strings.Join(empty, ", "))
+	}
 
-	// Write final db to file.
-	_, err = writer.WriteTo(outputFile)
-	if err != nil {
-		return fmt.Errorf("faild to write %s to output file: %w", dbConfig.Name, err)
+	writers := make([]*mmdbwriter.Tree, 0, len(targets))
+	for _, target := range targets {
+		writers = append(writers, target.writer)
+		stats.Outputs = append(stats.Outputs, OutputStats{Networks: target.networks})
+		stats.Networks += target.networks
 	}
+	return writers, stats, nil
+}
 
-	// Send final upate.
-	var fileSize int64
-	stat, err := os.Stat(dbConfig.Output)
-	if err == nil {
-		fileSize = stat.Size()
+// buildTarget is the tree of an output of a build.
+type buildTarget struct {
+	fields     [][]string
+	writer     *mmdbwriter.Tree
+	aggregator *networkAggregator
+	networks   int
+
+	// Hashes of the inserted networks and records, if duplicates are dropped.
+	inserted map[[sha256.Size]byte]struct{}
+}
+
+// duplicate returns whether the network was already inserted with the same
+// record, and otherwise remembers it. It always returns false if duplicates
+// are not dropped.
+func (bt *buildTarget) duplicate(network *net.IPNet, record mmdbtype.Map) bool {
+	if bt.inserted == nil {
+		return false
 	}
-	sendUpdate(updates, fmt.Sprintf(
-		"---\n%s finished: inserted %d entries in %s, resulting in %.2f MB written to %s",
-		dbConfig.Name,
-		totalInserts,
-		time.Since(totalStartTime).Round(time.Second),
-		float64(fileSize)/1000000,
-		dbConfig.Output,
-	))
+	// Maps are formatted with sorted keys, and with types to tell apart
+	// values like "1" and 1.
+	key := sha256.Sum256([]byte(fmt.Sprintf("%s %#v", network, record)))
+	if _, ok := bt.inserted[key]; ok {
+		return true
+	}
+	bt.inserted[key] = struct{}{}
+	return false
+}
 
+// flush inserts the networks collected by the aggregator, if enabled.
+// Only errors of the conflict callback are returned.
+func (bt *buildTarget) flush(cfg MergeConfig, updates chan string) error {
+	if bt.aggregator == nil {
+		return nil
+	}
+	record, networks := bt.aggregator.flush()
+	for _, network := range networks {
+		if err := bt.writer.InsertFunc(network, insertFunc(network, record, cfg)); err != nil {
+			if errors.As(err, new(*conflictError)) {
+				return err
+			}
+			sendUpdate(updates, fmt.Sprintf("failed to insert %s: %s", network, err.Error()))
+			continue
+		}
+		bt.networks++
+	}
 	return nil
 }
 
+// conflictError is returned by the conflict callback to abort the build.
+type conflictError struct {
+	network *net.IPNet
+	err     error
+}
+
+func (e *conflictError) Error() string {
+	return fmt.Sprintf("conflict at %s: %s", e.network, e.err)
+}
+
+func (e *conflictError) Unwrap() error {
+	return e.err
+}
+
+// insertFunc returns the inserter of the record into the network. If the
+// merge config has a conflict callback, it is called for existing records.
+func insertFunc(network *net.IPNet, record mmdbtype.DataType, cfg MergeConfig) inserter.Func {
+	merge := Inserter(record, cfg)
+	if cfg.onConflict == nil {
+		return merge
+	}
+	return func(existingValue mmdbtype.DataType) (mmdbtype.DataType, error) {
+		if existingValue == nil {
+			return merge(existingValue)
+		}
+		value, err := cfg.onConflict(network, existingValue.Copy(), record.Copy())
+		switch {
+		case err != nil:
+			return nil, &conflictError{network: network, err: err}
+		case value != nil:
+			return value, nil
+		default:
+			return merge(existingValue)
+		}
+	}
+}
+
+// sourceProgress reports the progress of a source to the progress functions.
+type sourceProgress struct {
+	name          string
+	progressFunc  func(sourceName string, processed int64)
+	percentFunc   func(sourceName string, percent float64)
+	estimate      int
+	estimateKnown bool
+}
+
+// newSourceProgress returns the progress reporting of the source.
+// The amount of entries is only estimated if there is a percent function.
+func newSourceProgress(dbConfig DatabaseConfig, source Source) sourceProgress {
+	sp := sourceProgress{
+		name:         source.Name(),
+		progressFunc: dbConfig.ProgressFunc,
+		percentFunc:  dbConfig.ProgressPercentFunc,
+	}
+	if estimator, ok := source.(interface{ EstimateCount() (int, bool) }); ok && sp.percentFunc != nil {
+		sp.estimate, sp.estimateKnown = estimator.EstimateCount()
+	}
+	return sp
+}
+
+// report reports the amount of processed entries. The percentage is capped
+// at 100, as the estimate may be too low, and is 100 if the source is done.
+func (sp sourceProgress) report(processed int, done bool) {
+	if sp.progressFunc != nil {
+		sp.progressFunc(sp.name, int64(processed))
+	}
+	if sp.percentFunc == nil || !sp.estimateKnown {
+		return
+	}
+	percent := 100.0
+	if !done && sp.estimate > 0 {
+		percent = math.Min(float64(processed)*100/float64(sp.estimate), 100)
+	}
+	sp.percentFunc(sp.name, percent)
+}
+
+// parallelBuffer is the amount of entries buffered per source when reading
+// sources in parallel.
+const parallelBuffer = 1000
+
+// preparedEntry is a source entry that is converted and ready to be inserted.
+// If err is set, the entry is invalid, and entry may be nil.
+type preparedEntry struct {
+	entry    *SourceEntry
+	record   mmdbtype.Map
+	networks []*net.IPNet
+	err      error
+}
+
+// prepareEntry reads the next entry from the source and converts it.
+// It returns false if there are no more entries.
+func prepareEntry(ctx context.Context, dbConfig DatabaseConfig, source Source) (preparedEntry, bool) {
+	entry, err := source.NextEntryContext(ctx)
+	if err != nil {
+		return preparedEntry{err: fmt.Errorf("failed to parse entry: %w", err)}, true
+	}
+	if entry == nil {
+		return preparedEntry{}, false
+	}
+
+	if err := entry.ApplyMappings(dbConfig.Mappings, dbConfig.Optimize); err != nil {
+		return preparedEntry{
+			entry: entry,
+			err:   fmt.Errorf("%s: failed to apply mappings to %+v: %w", entryPosition(source, entry), entry, err),
+		}, true
+	}
+
+	mmdbMap, err := entry.ToMMDBMap(dbConfig.Optimize)
+	if err != nil {
+		return preparedEntry{
+			entry: entry,
+			err:   fmt.Errorf("%s: failed to convert %+v to mmdb map: %w", entryPosition(source, entry), entry, err),
+		}, true
+	}
+
+	// Get networks of entry, decomposing IP ranges if needed.
+	networks, err := entry.Networks()
+	if err != nil {
+		return preparedEntry{entry: entry, err: err}, true
+	}
+
+	return preparedEntry{
+		entry:    entry,
+		record:   mmdbMap,
+		networks: networks,
+	}, true
+}
+
+// readSourcesParallel reads and converts the sources with the given amount
+// of workers. The entries of each source are sent in order to the channel of
+// the source, which is closed when the source is finished.
+// Sources are started in order, so that the source that is inserted next is
+// always being read.
+func readSourcesParallel(ctx context.Context, dbConfig DatabaseConfig, sources []Source, workers int) []chan preparedEntry {
+	channels := make([]chan preparedEntry, len(sources))
+	for i := range channels {
+		channels[i] = make(chan preparedEntry, parallelBuffer)
+	}
+
+	go func() {
+		slots := make(chan struct{}, workers)
+		for i, source := range sources {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				for _, c := range channels[i:] {
+					close(c)
+				}
+				return
+			}
+
+			go func(source Source, out chan<- preparedEntry) {
+				defer func() { <-slots }()
+				defer close(out)
+
+				for {
+					prepared, ok := prepareEntry(ctx, dbConfig, source)
+					if !ok {
+						return
+					}
+					select {
+					case out <- prepared:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}(source, channels[i])
+		}
+	}()
+
+	return channels
+}
+
 // Inserter is based on TopLevelMergeWith, but does addition processing based on config.
 func Inserter(newValue mmdbtype.DataType, cfg MergeConfig) inserter.Func {
 	return func(existingValue mmdbtype.DataType) (mmdbtype.DataType, error) {
 		// Always fully replace.
-		if cfg.AlwaysReplace {
+		if cfg.AlwaysReplace || cfg.Strategy == MergeStrategyOverwrite {
 			return newValue, nil
 		}
 
@@ -237,24 +833,31 @@ func Inserter(newValue mmdbtype.DataType, cfg MergeConfig) inserter.Func {
 		}
 
 		// Start merging.
-
-		// First, do a normal top-level merge.
 		returnMap := existingMap.Copy().(mmdbtype.Map) //nolint:forcetypeassert
-		for k, v := range newMap {
-			newValue := v.Copy()
-
-			// Check if we should merge an array type.
-			if cfg.MergeArrays {
-				if newArray, ok := newValue.(mmdbtype.Slice); ok {
-					if returnArray, ok := returnMap[k].(mmdbtype.Slice); ok {
-						returnMap[k] = append(returnArray, newArray...)
-						continue
-					}
+		switch cfg.Strategy {
+		case MergeStrategyDeep:
+			// First, do a deep merge.
+			deepMergeInto(returnMap, newMap, true, cfg, "")
+
+		case MergeStrategyFirstWins:
+			// Only fill in missing values, existing values are never reset.
+			deepMergeInto(returnMap, newMap, false, cfg, "")
+			return returnMap, nil
+
+		default:
+			// First, do a normal top-level merge.
+			for k, v := range newMap {
+				newValue := v.Copy()
+
+				// Check if we should merge an array type.
+				if merged, ok := mergeArrays(returnMap[k], newValue, true, cfg.arrayMergeFor(string(k))); ok {
+					returnMap[k] = merged
+					continue
 				}
-			}
 
-			// Simply assign new value if no special processing was needed.
-			returnMap[k] = newValue
+				// Simply assign new value if no special processing was needed.
+				returnMap[k] = newValue
+			}
 		}
 
 		// Then check which fields changed.
@@ -298,6 +901,99 @@ func Inserter(newValue mmdbtype.DataType, cfg MergeConfig) inserter.Func {
 	}
 }
 
+// deepMergeInto recursively merges src into dst.
+// If both values of a key are maps, they are merged. Otherwise, the value of
+// src is used if srcWins is true and the value of dst is kept if false.
+// Arrays are merged according to the array merge policy of their key, which
+// is prefixed with the keys of the parent maps.
+// Values of src are copied before they are added to dst.
+func deepMergeInto(dst, src mmdbtype.Map, srcWins bool, cfg MergeConfig, prefix string) {
+	for k, v := range src {
+		key := prefix + string(k)
+		dstValue, ok := dst[k]
+		if !ok {
+			dst[k] = v.Copy()
+			continue
+		}
+
+		// Recurse into maps.
+		if dstMap, ok := dstValue.(mmdbtype.Map); ok {
+			if srcMap, ok := v.(mmdbtype.Map); ok {
+				deepMergeInto(dstMap, srcMap, srcWins, cfg, key+".")
+				continue
+			}
+		}
+
+		// Collect the names of all sources of the network.
+		if cfg.StrategyName() == MergeStrategyDeep && slices.Contains(cfg.sourceFields, key) {
+			dst[k], _ = mergeArrays(asSlice(dstValue), asSlice(v.Copy()), srcWins, ArrayMergeAppendUnique)
+			continue
+		}
+
+		// Check if we should merge an array type.
+		if merged, ok := mergeArrays(dstValue, v.Copy(), srcWins, cfg.arrayMergeFor(key)); ok {
+			dst[k] = merged
+			continue
+		}
+
+		// Resolve conflict.
+		if srcWins {
+			dst[k] = v.Copy()
+		}
+	}
+}
+
+// mergeArrays merges the arrays according to the policy. It returns false
+// if the values are not both arrays or the policy replaces arrays.
+// The arrays are concatenated in the order of the precedence of the new
+// value, as given by srcWins.
+func mergeArrays(existingValue, newValue mmdbtype.DataType, srcWins bool, policy string) (mmdbtype.Slice, bool) {
+	if policy != ArrayMergeAppend && policy != ArrayMergeAppendUnique {
+		return nil, false
+	}
+	existingArray, ok := existingValue.(mmdbtype.Slice)
+	if !ok {
+		return nil, false
+	}
+	newArray, ok := newValue.(mmdbtype.Slice)
+	if !ok {
+		return nil, false
+	}
+
+	var merged mmdbtype.Slice
+	if srcWins {
+		merged = append(existingArray, newArray...)
+	} else {
+		merged = append(newArray, existingArray...)
+	}
+	if policy == ArrayMergeAppendUnique {
+		unique := merged[:0]
+		for _, entry := range merged {
+			if !slices.ContainsFunc(unique, entry.Equal) {
+				unique = append(unique, entry)
+			}
+		}
+		merged = unique
+	}
+	return merged, true
+}
+
+// asSlice returns the value as an array, wrapping values that are not.
+func asSlice(value mmdbtype.DataType) mmdbtype.Slice {
+	if slice, ok := value.(mmdbtype.Slice); ok {
+		return slice
+	}
+	return mmdbtype.Slice{value}
+}
+
+// entryPosition returns the source name and the line of the entry, if known.
+func entryPosition(source Source, entry *SourceEntry) string {
+	if entry.Line > 0 {
+		return fmt.Sprintf("%s line %d", source.Name(), entry.Line)
+	}
+	return source.Name()
+}
+
 func sendUpdate(to chan string, msg string) {
 	if to == nil {
 		return
@@ -309,6 +1005,23 @@ func sendUpdate(to chan string, msg string) {
 	}
 }
 
+// NormalizeNetwork returns IPv4-mapped IPv6 networks (::ffff:0:0/96) as IPv4
+// networks. All other networks are returned as is.
+func NormalizeNetwork(network *net.IPNet) *net.IPNet {
+	ones, bits := network.Mask.Size()
+	if bits != 8*net.IPv6len || ones < 96 {
+		return network
+	}
+	v4 := network.IP.To4()
+	if v4 == nil {
+		return network
+	}
+	return &net.IPNet{
+		IP:   v4,
+		Mask: net.CIDRMask(ones-96, 8*net.IPv4len),
+	}
+}
+
 func ipVersion(ip net.IP) int {
 	if ip.To4() != nil {
 		return 4
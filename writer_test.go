@@ -0,0 +1,1282 @@
+package mmdbmeld
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+func TestInserterStrategies(t *testing.T) {
+	t.Parallel()
+
+	existing := mmdbtype.Map{
+		"country": mmdbtype.Map{
+			"iso_code": mmdbtype.String("AT"),
+		},
+		"location": mmdbtype.Map{
+			"latitude":  mmdbtype.Float32(48.12),
+			"longitude": mmdbtype.Float32(16.22),
+		},
+	}
+	update := mmdbtype.Map{
+		"location": mmdbtype.Map{
+			"latitude":        mmdbtype.Float32(48.2),
+			"accuracy_radius": mmdbtype.Uint16(100),
+		},
+		"autonomous_system_number": mmdbtype.Uint32(12345),
+	}
+
+	tests := map[string]string{
+		MergeStrategyTopLevel:  "map[autonomous_system_number:12345 country:map[iso_code:AT] location:map[accuracy_radius:100 latitude:48.2]]",
+		MergeStrategyDeep:      "map[autonomous_system_number:12345 country:map[iso_code:AT] location:map[accuracy_radius:100 latitude:48.2 longitude:16.22]]",
+		MergeStrategyOverwrite: "map[autonomous_system_number:12345 location:map[accuracy_radius:100 latitude:48.2]]",
+		MergeStrategyFirstWins: "map[autonomous_system_number:12345 country:map[iso_code:AT] location:map[accuracy_radius:100 latitude:48.12 longitude:16.22]]",
+	}
+	for strategy, expected := range tests {
+		merged, err := Inserter(update, MergeConfig{Strategy: strategy})(existing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := fmt.Sprintf("%v", merged); s != expected {
+			t.Fatalf("unexpected result for strategy %s: %s", strategy, s)
+		}
+	}
+
+	// Existing value must not be modified.
+	if s := fmt.Sprintf("%v", existing); s != "map[country:map[iso_code:AT] location:map[latitude:48.12 longitude:16.22]]" {
+		t.Fatalf("existing value was modified: %s", s)
+	}
+}
+
+func TestArrayMerge(t *testing.T) {
+	t.Parallel()
+
+	existing := mmdbtype.Map{
+		"tags": mmdbtype.Slice{mmdbtype.String("a"), mmdbtype.String("b")},
+		"location": mmdbtype.Map{
+			"sources": mmdbtype.Slice{mmdbtype.String("x")},
+		},
+	}
+	update := mmdbtype.Map{
+		"tags": mmdbtype.Slice{mmdbtype.String("c"), mmdbtype.String("a"), mmdbtype.String("c")},
+		"location": mmdbtype.Map{
+			"sources": mmdbtype.Slice{mmdbtype.String("x"), mmdbtype.String("y")},
+		},
+	}
+
+	tests := []struct {
+		cfg      MergeConfig
+		expected string
+	}{
+		{
+			MergeConfig{Strategy: MergeStrategyDeep},
+			"map[location:map[sources:[x y]] tags:[c a c]]",
+		},
+		{
+			MergeConfig{Strategy: MergeStrategyDeep, MergeArrays: true},
+			"map[location:map[sources:[x x y]] tags:[a b c a c]]",
+		},
+		{
+			MergeConfig{Strategy: MergeStrategyDeep, ArrayMerge: ArrayMergeAppendUnique},
+			"map[location:map[sources:[x y]] tags:[a b c]]",
+		},
+		{
+			MergeConfig{
+				Strategy:        MergeStrategyDeep,
+				ArrayMerge:      ArrayMergeAppend,
+				FieldArrayMerge: map[string]string{"tags": ArrayMergeAppendUnique, "location.sources": ArrayMergeReplace},
+			},
+			"map[location:map[sources:[x y]] tags:[a b c]]",
+		},
+		{
+			MergeConfig{Strategy: MergeStrategyFirstWins, ArrayMerge: ArrayMergeAppendUnique},
+			"map[location:map[sources:[x y]] tags:[c a b]]",
+		},
+		{
+			MergeConfig{ArrayMerge: ArrayMergeAppendUnique},
+			"map[location:map[sources:[x y]] tags:[a b c]]",
+		},
+	}
+	for _, test := range tests {
+		if err := test.cfg.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		merged, err := Inserter(update, test.cfg)(existing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := fmt.Sprintf("%v", merged); s != test.expected {
+			t.Fatalf("unexpected result for %+v: %s", test.cfg, s)
+		}
+	}
+
+	// Existing value must not be modified.
+	if s := fmt.Sprintf("%v", existing); s != "map[location:map[sources:[x]] tags:[a b]]" {
+		t.Fatalf("existing value was modified: %s", s)
+	}
+	if err := (MergeConfig{FieldArrayMerge: map[string]string{"tags": "merge"}}).Validate(); err == nil {
+		t.Fatal("expected error for unknown array merge policy")
+	}
+}
+
+// testSource is a Source returning predefined entries.
+type testSource struct {
+	name    string
+	entries []*SourceEntry
+	err     error
+	closed  bool
+}
+
+func newTestSource(name string, networks ...string) *testSource {
+	ts := &testSource{name: name}
+	for _, network := range networks {
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			panic(err)
+		}
+		ts.entries = append(ts.entries, &SourceEntry{
+			Net: ipNet,
+			Values: map[string]SourceValue{
+				"source": {Type: "string", Value: name},
+			},
+		})
+	}
+	return ts
+}
+
+func (ts *testSource) Name() string {
+	return ts.name
+}
+
+func (ts *testSource) NextEntry() (*SourceEntry, error) {
+	return ts.NextEntryContext(context.Background())
+}
+
+func (ts *testSource) NextEntryContext(ctx context.Context) (*SourceEntry, error) {
+	if err := ctx.Err(); err != nil {
+		ts.err = err
+		return nil, nil
+	}
+	if len(ts.entries) == 0 {
+		return nil, nil
+	}
+	entry := ts.entries[0]
+	ts.entries = ts.entries[1:]
+	return entry, nil
+}
+
+func (ts *testSource) Err() error {
+	return ts.err
+}
+
+func (ts *testSource) Close() error {
+	ts.closed = true
+	return nil
+}
+
+func TestStrictOverlap(t *testing.T) {
+	t.Parallel()
+
+	newDBConfig := func() DatabaseConfig {
+		return DatabaseConfig{
+			Name:   "Test",
+			MMDB:   MMDBConfig{IPVersion: 6, RecordSize: 24},
+			Types:  map[string]string{"source": "string"},
+			Output: filepath.Join(t.TempDir(), "test.mmdb"),
+		}
+	}
+	newSources := func() []Source {
+		return []Source{
+			newTestSource("a", "192.0.2.0/24", "2001:db8::/32"),
+			newTestSource("b", "192.0.2.128/25", "2001:db8:1::/48", "198.51.100.0/24"),
+			newTestSource("c", "2001::/16"),
+		}
+	}
+
+	// Collect overlaps in non-strict mode.
+	var overlaps []string
+	dbConfig := newDBConfig()
+	dbConfig.OnOverlap = func(o Overlap) {
+		overlaps = append(overlaps, o.String())
+	}
+	if err := WriteMMDB(dbConfig, newSources(), nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"network 192.0.2.128/25 of b overlaps with 192.0.2.0/24 of a",
+		"network 2001:db8:1::/48 of b overlaps with 2001:db8::/32 of a",
+		"network 2001::/16 of c overlaps with 2001:db8::/32 of a",
+	}
+	if fmt.Sprintf("%q", overlaps) != fmt.Sprintf("%q", expected) {
+		t.Fatalf("unexpected overlaps: %q", overlaps)
+	}
+
+	// Fail in strict mode.
+	dbConfig = newDBConfig()
+	dbConfig.StrictOverlap = true
+	err := WriteMMDB(dbConfig, newSources(), nil)
+	if err == nil || !strings.Contains(err.Error(), expected[0]) {
+		t.Fatalf("expected overlap error, got %v", err)
+	}
+
+	// Deep merges may overlap.
+	dbConfig = newDBConfig()
+	dbConfig.StrictOverlap = true
+	dbConfig.Merge.Strategy = MergeStrategyDeep
+	if err := WriteMMDB(dbConfig, newSources(), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOnConflict(t *testing.T) {
+	t.Parallel()
+
+	newSources := func() []Source {
+		return []Source{
+			newTestSource("a", "192.0.2.0/24"),
+			newTestSource("b", "192.0.2.128/25", "198.51.100.0/24"),
+			newTestSource("c", "192.0.2.0/23"),
+		}
+	}
+	dbConfig := DatabaseConfig{
+		Name:   "Test",
+		MMDB:   MMDBConfig{IPVersion: 4, RecordSize: 24},
+		Types:  map[string]string{"source": "string"},
+		Output: filepath.Join(t.TempDir(), "test.mmdb"),
+	}
+
+	// Keep the existing records of a, and use the default strategy for c.
+	var conflicts []string
+	dbConfig.OnConflict = func(network *net.IPNet, existing, incoming mmdbtype.DataType) (mmdbtype.DataType, error) {
+		conflicts = append(conflicts, fmt.Sprintf("%s %v %v", network, existing, incoming))
+		if existing.(mmdbtype.Map)["source"] == mmdbtype.String("a") { //nolint:forcetypeassert
+			return existing, nil
+		}
+		return nil, nil
+	}
+	if err := WriteMMDB(dbConfig, newSources(), nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"192.0.2.128/25 map[source:a] map[source:b]",
+		"192.0.2.0/23 map[source:a] map[source:c]",
+	}
+	if fmt.Sprintf("%q", conflicts) != fmt.Sprintf("%q", expected) {
+		t.Fatalf("unexpected conflicts: %q", conflicts)
+	}
+	reader, err := maxminddb.Open(dbConfig.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close() //nolint:errcheck
+	for ip, expected := range map[string]string{
+		"192.0.2.1":    "map[source:a]",
+		"192.0.2.129":  "map[source:a]",
+		"192.0.3.1":    "map[source:c]",
+		"198.51.100.1": "map[source:b]",
+	} {
+		var record map[string]any
+		if err := reader.Lookup(net.ParseIP(ip), &record); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprintf("%v", record) != expected {
+			t.Fatalf("unexpected record of %s: %v", ip, record)
+		}
+	}
+
+	// Errors abort the build.
+	dbConfig.OnConflict = func(*net.IPNet, mmdbtype.DataType, mmdbtype.DataType) (mmdbtype.DataType, error) {
+		return nil, errors.New("vetoed")
+	}
+	if err := WriteMMDB(dbConfig, newSources(), nil); err == nil || !strings.Contains(err.Error(), "conflict at 192.0.2.128/25: vetoed") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestBuildToWriter(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "test.csv")
+	if err := os.WriteFile(file, []byte("192.0.2.0,192.0.2.255,AT\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dbConfig := DatabaseConfig{
+		Name:  "Test",
+		MMDB:  MMDBConfig{IPVersion: 6, RecordSize: 24},
+		Types: map[string]string{"country.iso_code": "string"},
+		Inputs: []DatabaseInput{{
+			File:   file,
+			Fields: []string{"from", "to", "country.iso_code"},
+		}},
+	}
+
+	var buf bytes.Buffer
+	if err := BuildToWriter(dbConfig, &buf); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := maxminddb.FromBytes(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record map[string]any
+	if err := reader.Lookup(net.ParseIP("192.0.2.1"), &record); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%v", record) != "map[country:map[iso_code:AT]]" {
+		t.Fatalf("unexpected record: %v", record)
+	}
+}
+
+func TestInputPriority(t *testing.T) {
+	t.Parallel()
+
+	// Each input sets the country of the same network, and a field of its own.
+	dir := t.TempDir()
+	var inputs []DatabaseInput
+	for _, input := range []struct {
+		name     string
+		priority int
+	}{
+		{"a", 10},
+		{"b", 0},
+		{"c", 10},
+		{"d", 5},
+	} {
+		file := filepath.Join(dir, input.name+".csv")
+		data := fmt.Sprintf("192.0.2.0,192.0.2.255,%s,%s\n", input.name, input.name)
+		if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, DatabaseInput{
+			File:     file,
+			Fields:   []string{"from", "to", "country", "source." + input.name},
+			Priority: input.priority,
+		})
+	}
+	dbConfig := DatabaseConfig{
+		Name: "Test",
+		MMDB: MMDBConfig{IPVersion: 6, RecordSize: 24},
+		Types: map[string]string{
+			"country":  "string",
+			"source.a": "string",
+			"source.b": "string",
+			"source.c": "string",
+			"source.d": "string",
+		},
+		Inputs: inputs,
+		Merge:  MergeConfig{Strategy: MergeStrategyDeep},
+	}
+
+	var buf bytes.Buffer
+	if err := BuildToWriter(dbConfig, &buf); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := maxminddb.FromBytes(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record map[string]any
+	if err := reader.Lookup(net.ParseIP("192.0.2.1"), &record); err != nil {
+		t.Fatal(err)
+	}
+	// The highest priority wins, and c wins over a as it is defined later.
+	// Values without conflict are kept from all inputs.
+	if fmt.Sprintf("%v", record) != "map[country:c source:map[a:a b:b c:c d:d]]" {
+		t.Fatalf("unexpected record: %v", record)
+	}
+
+	// Sources are loaded in processing order.
+	sources, err := LoadSources(dbConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeSources(sources)
+	names := make([]string, 0, len(sources))
+	for _, source := range sources {
+		names = append(names, filepath.Base(source.Name()))
+	}
+	if fmt.Sprintf("%v", names) != "[b.csv d.csv a.csv c.csv]" {
+		t.Fatalf("unexpected source order: %v", names)
+	}
+
+	// With first-wins, the highest priority wins as well, and a wins over c
+	// as it is defined earlier.
+	dbConfig.Merge.Strategy = MergeStrategyFirstWins
+	buf.Reset()
+	if err := BuildToWriter(dbConfig, &buf); err != nil {
+		t.Fatal(err)
+	}
+	reader, err = maxminddb.FromBytes(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	record = nil
+	if err := reader.Lookup(net.ParseIP("192.0.2.1"), &record); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%v", record) != "map[country:a source:map[a:a b:b c:c d:d]]" {
+		t.Fatalf("unexpected first-wins record: %v", record)
+	}
+}
+
+func TestAddSourceField(t *testing.T) {
+	t.Parallel()
+
+	// Both inputs set the country of the same network, b also a larger one.
+	dir := t.TempDir()
+	var inputs []DatabaseInput
+	for name, data := range map[string]string{
+		"a": "192.0.2.0,192.0.2.255,AT\n",
+		"b": "192.0.2.0,192.0.3.255,DE\n",
+	} {
+		file := filepath.Join(dir, name+".csv")
+		if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, DatabaseInput{
+			Name:           name,
+			File:           file,
+			Fields:         []string{"from", "to", "country"},
+			AddSourceField: "meta.sources",
+			Priority:       int(name[0]),
+		})
+	}
+	dbConfig := DatabaseConfig{
+		Name:   "Test",
+		MMDB:   MMDBConfig{IPVersion: 4, RecordSize: 24},
+		Types:  map[string]string{"country": "string"},
+		Inputs: inputs,
+		Merge:  MergeConfig{Strategy: MergeStrategyDeep},
+	}
+
+	// Entries carry the name of their source.
+	sources, err := LoadSources(dbConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := sources[0].NextEntry()
+	closeSources(sources)
+	if err != nil || entry == nil {
+		t.Fatalf("unexpected entry: %+v, %v", entry, err)
+	}
+	if fmt.Sprintf("%v", entry.Values["meta.sources"]) != "{string a}" {
+		t.Fatalf("unexpected source value: %v", entry.Values["meta.sources"])
+	}
+
+	// Deep merges collect the names of all sources of a network.
+	for strategy, expected := range map[string][2]string{
+		MergeStrategyDeep:     {"map[country:DE meta:map[sources:[a b]]]", "map[country:DE meta:map[sources:b]]"},
+		MergeStrategyTopLevel: {"map[country:DE meta:map[sources:b]]", "map[country:DE meta:map[sources:b]]"},
+	} {
+		dbConfig.Merge.Strategy = strategy
+		var buf bytes.Buffer
+		if err := BuildToWriter(dbConfig, &buf); err != nil {
+			t.Fatal(err)
+		}
+		reader, err := maxminddb.FromBytes(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, ip := range []string{"192.0.2.1", "192.0.3.1"} {
+			var record map[string]any
+			if err := reader.Lookup(net.ParseIP(ip), &record); err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprintf("%v", record) != expected[i] {
+				t.Fatalf("unexpected %s record of %s: %v", strategy, ip, record)
+			}
+		}
+	}
+}
+
+func TestBuildStats(t *testing.T) {
+	t.Parallel()
+
+	dbConfig := DatabaseConfig{
+		Name:     "Test",
+		MMDB:     MMDBConfig{IPVersion: 6, RecordSize: 24},
+		Types:    map[string]string{"source": "string"},
+		Output:   filepath.Join(t.TempDir(), "test.mmdb"),
+		Optimize: Optimizations{MaxPrefix: 24},
+	}
+	a := newTestSource("a", "192.0.2.0/24")
+	a.entries = append(a.entries, &SourceEntry{
+		From:   net.ParseIP("198.51.100.0").To4(),
+		To:     net.ParseIP("198.51.102.255").To4(),
+		Values: map[string]SourceValue{"source": {Type: "string", Value: "a"}},
+	})
+	sources := []Source{
+		a,
+		newTestSource("b", "192.0.2.128/25"), // Exceeds max prefix.
+	}
+
+	stats, err := WriteMMDBWithStats(context.Background(), dbConfig, sources, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Records != 2 || stats.Networks != 3 {
+		t.Fatalf("unexpected records or networks: %+v", stats)
+	}
+	expected := []SourceStats{
+		{Name: "a", Entries: 2, Records: 2},
+		{Name: "b", Entries: 1, Records: 0},
+	}
+	if fmt.Sprintf("%+v", stats.Sources) != fmt.Sprintf("%+v", expected) {
+		t.Fatalf("unexpected source stats: %+v", stats.Sources)
+	}
+	stat, err := os.Stat(dbConfig.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.BytesWritten != stat.Size() {
+		t.Fatalf("unexpected bytes written: %d (file has %d)", stats.BytesWritten, stat.Size())
+	}
+}
+
+func TestCompressOutput(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	dbConfig := DatabaseConfig{
+		Name:     "Test",
+		MMDB:     MMDBConfig{IPVersion: 4, RecordSize: 24},
+		Types:    map[string]string{"source": "string"},
+		Output:   filepath.Join(dir, "test.mmdb"),
+		Compress: CompressGzip,
+		Outputs: []OutputConfig{
+			{Output: filepath.Join(dir, "explicit.mmdb.gz"), Compress: CompressGzip},
+			{Output: filepath.Join(dir, "plain.mmdb")},
+		},
+	}
+	stats, err := WriteMMDBWithStats(context.Background(), dbConfig, []Source{newTestSource("a", "192.0.2.0/24")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Compressed outputs get the suffix, unless they already have it.
+	for i, name := range []string{"test.mmdb.gz", "explicit.mmdb.gz", "plain.mmdb"} {
+		output := stats.Outputs[i]
+		if output.Output != filepath.Join(dir, name) {
+			t.Fatalf("unexpected output %s", output.Output)
+		}
+		data, err := os.ReadFile(output.Output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(len(data)) != output.BytesWritten {
+			t.Fatalf("unexpected bytes written of %s: %d (file has %d)", name, output.BytesWritten, len(data))
+		}
+		if strings.HasSuffix(name, ".gz") {
+			gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, err = io.ReadAll(gzipReader); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if int64(len(data)) != output.UncompressedBytes {
+			t.Fatalf("unexpected uncompressed bytes of %s: %d (database has %d)", name, output.UncompressedBytes, len(data))
+		}
+		if _, err := maxminddb.FromBytes(data); err != nil {
+			t.Fatalf("failed to read %s: %s", name, err)
+		}
+	}
+	if stats.UncompressedBytes != 3*stats.Outputs[2].BytesWritten || stats.BytesWritten >= stats.UncompressedBytes {
+		t.Fatalf("unexpected total bytes: %d written, %d uncompressed", stats.BytesWritten, stats.UncompressedBytes)
+	}
+
+	// BuildToWriter compresses the same way.
+	dbConfig.Inputs = []DatabaseInput{{File: filepath.Join(dir, "test.csv"), Fields: []string{"from", "to", "source"}}}
+	if err := os.WriteFile(dbConfig.Inputs[0].File, []byte("192.0.2.0,192.0.2.255,a\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := BuildToWriter(dbConfig, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gzip.NewReader(&buf); err != nil {
+		t.Fatalf("expected gzipped database: %s", err)
+	}
+
+	// Unknown compressions fail.
+	dbConfig.Compress = "zstd"
+	if err := BuildToWriter(dbConfig, &buf); err == nil {
+		t.Fatal("expected error for unknown compression")
+	}
+}
+
+func TestOutputs(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	dbConfig := DatabaseConfig{
+		Name: "Test",
+		MMDB: MMDBConfig{IPVersion: 6, RecordSize: 24},
+		Types: map[string]string{
+			"country.iso_code":         "string",
+			"country.names.en":         "string",
+			"autonomous_system_number": "uint32",
+		},
+		Output: filepath.Join(dir, "full.mmdb"),
+		Outputs: []OutputConfig{{
+			Output: filepath.Join(dir, "country.mmdb"),
+			Fields: []string{"country.iso_code"},
+		}, {
+			Output: filepath.Join(dir, "asn.mmdb"),
+			Fields: []string{"autonomous_system_number", "country", "country.iso_code"},
+		}},
+	}
+	source := &testSource{name: "a"}
+	for network, values := range map[string]map[string]string{
+		"192.0.2.0/24":    {"country.iso_code": "AT", "country.names.en": "Austria", "autonomous_system_number": "64496"},
+		"198.51.100.0/24": {"autonomous_system_number": "64497"},
+	} {
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry := &SourceEntry{Net: ipNet, Values: make(map[string]SourceValue)}
+		for key, value := range values {
+			entry.Values[key] = SourceValue{Type: dbConfig.Types[key], Value: value}
+		}
+		source.entries = append(source.entries, entry)
+	}
+
+	stats, err := WriteMMDBWithStats(context.Background(), dbConfig, []Source{source}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Records != 2 || stats.Networks != 5 || len(stats.Outputs) != 3 || stats.Outputs[1].Networks != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// Networks without any of the selected fields are not inserted.
+	expected := map[string][]string{
+		"full.mmdb":    {"map[autonomous_system_number:64496 country:map[iso_code:AT names:map[en:Austria]]]", "map[autonomous_system_number:64497]"},
+		"country.mmdb": {"map[country:map[iso_code:AT]]", "map[]"},
+		"asn.mmdb":     {"map[autonomous_system_number:64496 country:map[iso_code:AT names:map[en:Austria]]]", "map[autonomous_system_number:64497]"},
+	}
+	for output, records := range expected {
+		reader, err := maxminddb.Open(filepath.Join(dir, output))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, ip := range []string{"192.0.2.1", "198.51.100.1"} {
+			var record map[string]any
+			if err := reader.Lookup(net.ParseIP(ip), &record); err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprintf("%v", record) != records[i] {
+				t.Fatalf("unexpected record of %s in %s: %v", ip, output, record)
+			}
+		}
+		_ = reader.Close()
+	}
+
+	// Outputs must be distinct.
+	dbConfig.Outputs[0].Output = dbConfig.Output
+	if _, err := WriteMMDBWithStats(context.Background(), dbConfig, nil, nil); err == nil {
+		t.Fatal("expected error for duplicate output")
+	}
+}
+
+func TestRequireNonEmpty(t *testing.T) {
+	t.Parallel()
+
+	dbConfig := DatabaseConfig{
+		Name:   "Test",
+		MMDB:   MMDBConfig{IPVersion: 6, RecordSize: 24},
+		Types:  map[string]string{"source": "string"},
+		Output: filepath.Join(t.TempDir(), "test.mmdb"),
+	}
+	newSources := func() []Source {
+		return []Source{
+			newTestSource("a", "192.0.2.0/24"),
+			newTestSource("empty"),
+			newTestSource("also-empty"),
+		}
+	}
+
+	// Empty sources are reported in the stats.
+	stats, err := WriteMMDBWithStats(context.Background(), dbConfig, newSources(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%v", stats.EmptySources()) != "[empty also-empty]" {
+		t.Fatalf("unexpected empty sources: %v", stats.EmptySources())
+	}
+
+	// And fail the build if required.
+	dbConfig.RequireNonEmpty = true
+	_, err = WriteMMDBWithStats(context.Background(), dbConfig, newSources(), nil)
+	if err == nil || !strings.Contains(err.Error(), "empty, also-empty") {
+		t.Fatalf("expected error naming empty sources, got %v", err)
+	}
+	if _, err := WriteMMDBWithStats(context.Background(), dbConfig, newSources()[:1], nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCloseSources(t *testing.T) {
+	t.Parallel()
+
+	for _, workers := range []int{0, 2} {
+		dbConfig := DatabaseConfig{
+			Name:    "Test",
+			MMDB:    MMDBConfig{IPVersion: 6, RecordSize: 24},
+			Types:   map[string]string{"source": "string"},
+			Output:  filepath.Join(t.TempDir(), "test.mmdb"),
+			Workers: workers,
+		}
+		a := newTestSource("a", "192.0.2.0/24")
+		b := newTestSource("b", "198.51.100.0/24")
+		if err := WriteMMDB(dbConfig, []Source{a, b}, nil); err != nil {
+			t.Fatal(err)
+		}
+		if !a.closed || !b.closed {
+			t.Fatalf("workers=%d: sources were not closed", workers)
+		}
+
+		// Sources are also closed if the build fails.
+		a = newTestSource("a", "192.0.2.0/24")
+		a.err = errors.New("test error")
+		b = newTestSource("b", "198.51.100.0/24")
+		if err := WriteMMDB(dbConfig, []Source{a, b}, nil); err == nil {
+			t.Fatal("expected error for failing source")
+		}
+		if !a.closed || !b.closed {
+			t.Fatalf("workers=%d: sources were not closed after failing build", workers)
+		}
+	}
+}
+
+func TestSkipSpecialUse(t *testing.T) {
+	t.Parallel()
+
+	dbConfig := DatabaseConfig{
+		Name:           "Test",
+		MMDB:           MMDBConfig{IPVersion: 6, RecordSize: 24},
+		Types:          map[string]string{"source": "string"},
+		Output:         filepath.Join(t.TempDir(), "test.mmdb"),
+		SkipSpecialUse: true,
+	}
+	source := newTestSource("a",
+		"10.1.0.0/16",          // Private, skipped.
+		"0.0.0.0/4",            // Contains 10.0.0.0/8, clipped.
+		"2001:db8::/48",        // Documentation, skipped.
+		"2001:4860::/32",       // Kept.
+		"::ffff:127.0.0.0/104", // IPv4-mapped loopback, skipped.
+	)
+	stats, err := WriteMMDBWithStats(context.Background(), dbConfig, []Source{source}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Sources[0].SpecialUse != 4 || stats.Records != 2 || stats.Networks != 5 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	reader, err := maxminddb.Open(dbConfig.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close() //nolint:errcheck
+	for ip, found := range map[string]bool{
+		"10.1.1.1":      false,
+		"10.200.0.1":    false,
+		"9.255.255.255": true,
+		"11.0.0.1":      true,
+		"2001:db8::1":   false,
+		"2001:4860::1":  true,
+		"127.0.0.1":     false,
+	} {
+		var record map[string]any
+		if err := reader.Lookup(net.ParseIP(ip), &record); err != nil {
+			t.Fatal(err)
+		}
+		if (record != nil) != found {
+			t.Fatalf("unexpected record for %s: %v", ip, record)
+		}
+	}
+
+	// The special-use networks are exposed.
+	if networks := SpecialUseNetworks(); len(networks) == 0 || networks[0].String() != "10.0.0.0/8" {
+		t.Fatalf("unexpected special-use networks: %v", networks)
+	}
+}
+
+func TestAggregateNetworks(t *testing.T) {
+	t.Parallel()
+
+	dbConfig := DatabaseConfig{
+		Name:     "Test",
+		MMDB:     MMDBConfig{IPVersion: 6, RecordSize: 24},
+		Types:    map[string]string{"source": "string"},
+		Output:   filepath.Join(t.TempDir(), "test.mmdb"),
+		Optimize: Optimizations{AggregateNetworks: true},
+	}
+	a := newTestSource("a", "192.0.2.0/25", "192.0.2.128/26", "192.0.2.192/26", "198.51.100.0/25")
+	b := newTestSource("b", "198.51.100.128/25")
+	// Entries of other records must not be merged across.
+	a.entries = append(a.entries[:3], append(b.entries, a.entries[3:]...)...)
+
+	stats, err := WriteMMDBWithStats(context.Background(), dbConfig, []Source{a}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Records != 5 || stats.Networks != 3 {
+		t.Fatalf("unexpected records or networks: %+v", stats)
+	}
+
+	reader, err := maxminddb.Open(dbConfig.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close() //nolint:errcheck
+	var record map[string]any
+	network, ok, err := reader.LookupNetwork(net.ParseIP("192.0.2.200"), &record)
+	if err != nil || !ok {
+		t.Fatalf("failed to look up network: %v", err)
+	}
+	if network.String() != "192.0.2.0/24" {
+		t.Fatalf("expected aggregated network, got %s", network)
+	}
+}
+
+func TestDedupInserts(t *testing.T) {
+	t.Parallel()
+
+	dbConfig := DatabaseConfig{
+		Name:     "Test",
+		MMDB:     MMDBConfig{IPVersion: 6, RecordSize: 24},
+		Types:    map[string]string{"source": "string"},
+		Output:   filepath.Join(t.TempDir(), "test.mmdb"),
+		Optimize: Optimizations{DedupInserts: true},
+	}
+	sources := []Source{
+		newTestSource("a", "192.0.2.0/24", "198.51.100.0/24", "192.0.2.0/24", "198.51.100.0/24"),
+		// Same network with another record is not a duplicate.
+		newTestSource("b", "192.0.2.0/24"),
+	}
+
+	stats, err := WriteMMDBWithStats(context.Background(), dbConfig, sources, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Records != 3 || stats.Duplicates != 2 || stats.Networks != 3 {
+		t.Fatalf("unexpected records, duplicates or networks: %+v", stats)
+	}
+}
+
+func TestInternFields(t *testing.T) {
+	t.Parallel()
+
+	dbConfig := DatabaseConfig{
+		Name:     "Test",
+		MMDB:     MMDBConfig{IPVersion: 6, RecordSize: 24},
+		Types:    map[string]string{"source": "string"},
+		Output:   filepath.Join(t.TempDir(), "test.mmdb"),
+		Optimize: Optimizations{Intern: []string{"source"}},
+	}
+	sources := []Source{
+		newTestSource("a", "192.0.2.0/24", "198.51.100.0/24", "203.0.113.0/24"),
+		newTestSource("b", "2001:db8::/32"),
+	}
+
+	stats, err := WriteMMDBWithStats(context.Background(), dbConfig, sources, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.InternLookups != 4 || stats.InternHits != 2 || stats.InternHitRate() != 0.5 {
+		t.Fatalf("unexpected intern stats: %+v", stats)
+	}
+	if stats.Records != 4 {
+		t.Fatalf("unexpected records: %d", stats.Records)
+	}
+}
+
+func TestTreeOptions(t *testing.T) {
+	t.Parallel()
+
+	build := func(mmdbConfig MMDBConfig) (*BuildStats, *maxminddb.Reader) {
+		dbConfig := DatabaseConfig{
+			Name:   "Test",
+			MMDB:   mmdbConfig,
+			Types:  map[string]string{"source": "string"},
+			Output: filepath.Join(t.TempDir(), "test.mmdb"),
+		}
+		sources := []Source{newTestSource("a", "1.1.1.0/24", "192.0.2.0/24")}
+		stats, err := WriteMMDBWithStats(context.Background(), dbConfig, sources, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader, err := maxminddb.Open(dbConfig.Output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = reader.Close() })
+		return stats, reader
+	}
+	lookup := func(reader *maxminddb.Reader, ip string) bool {
+		var record map[string]any
+		_, ok, err := reader.LookupNetwork(net.ParseIP(ip), &record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+	disabled := false
+
+	// By default, IPv4 is not aliased and reserved networks are included.
+	stats, reader := build(MMDBConfig{IPVersion: 6, RecordSize: 24})
+	if stats.Networks != 2 || lookup(reader, "2002:101:101::") {
+		t.Fatalf("unexpected default build: %+v", stats)
+	}
+
+	// Aliasing makes IPv4 data available via 6to4 addresses.
+	_, reader = build(MMDBConfig{IPVersion: 6, RecordSize: 24, DisableIPv4Aliasing: &disabled})
+	if !lookup(reader, "2002:101:101::") || !lookup(reader, "1.1.1.1") {
+		t.Fatal("expected IPv4 data for 6to4 address with aliasing")
+	}
+
+	// Excluding reserved networks fails inserts into them.
+	stats, reader = build(MMDBConfig{IPVersion: 6, RecordSize: 24, IncludeReservedNetworks: &disabled})
+	if stats.Networks != 1 || lookup(reader, "192.0.2.1") {
+		t.Fatalf("unexpected build without reserved networks: %+v", stats)
+	}
+}
+
+func TestParallelSources(t *testing.T) {
+	t.Parallel()
+
+	build := func(workers int) string {
+		dbConfig := DatabaseConfig{
+			Name:    "Test",
+			MMDB:    MMDBConfig{IPVersion: 6, RecordSize: 24},
+			Types:   map[string]string{"source": "string"},
+			Output:  filepath.Join(t.TempDir(), "test.mmdb"),
+			Workers: workers,
+		}
+		sources := []Source{
+			newTestSource("a", "192.0.2.0/24", "198.51.100.0/24"),
+			newTestSource("b", "192.0.2.128/25"),
+			newTestSource("c", "198.51.100.0/25", "203.0.113.0/24"),
+			newTestSource("d", "192.0.2.0/26"),
+		}
+		stats, err := WriteMMDBWithStats(context.Background(), dbConfig, sources, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Records != 6 {
+			t.Fatalf("unexpected records with %d workers: %d", workers, stats.Records)
+		}
+
+		// Dump all networks with their records.
+		reader, err := maxminddb.Open(dbConfig.Output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close() //nolint:errcheck
+		var dump strings.Builder
+		networks := reader.Networks(maxminddb.SkipAliasedNetworks)
+		for networks.Next() {
+			var record map[string]any
+			network, err := networks.Network(&record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprintf(&dump, "%s=%v\n", network, record)
+		}
+		if err := networks.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return dump.String()
+	}
+
+	// Parallel reading must result in the same database.
+	expected := build(1)
+	for _, workers := range []int{2, 8} {
+		if dump := build(workers); dump != expected {
+			t.Fatalf("database built with %d workers differs:\n%s\nexpected:\n%s", workers, dump, expected)
+		}
+	}
+}
+
+func TestProgressFunc(t *testing.T) {
+	t.Parallel()
+
+	var progress []string
+	dbConfig := DatabaseConfig{
+		Name:   "Test",
+		MMDB:   MMDBConfig{IPVersion: 6, RecordSize: 24},
+		Types:  map[string]string{"source": "string"},
+		Output: filepath.Join(t.TempDir(), "test.mmdb"),
+		ProgressFunc: func(sourceName string, processed int64) {
+			progress = append(progress, fmt.Sprintf("%s:%d", sourceName, processed))
+		},
+	}
+	a := &testSource{name: "a"}
+	for i := 0; i < progressInterval+1; i++ {
+		a.entries = append(a.entries, &SourceEntry{
+			Net: &net.IPNet{
+				IP:   net.IPv4(10, byte(i>>8), byte(i), 0).To4(),
+				Mask: net.CIDRMask(24, 32),
+			},
+			Values: map[string]SourceValue{"source": {Type: "string", Value: "a"}},
+		})
+	}
+	sources := []Source{a, newTestSource("b", "192.0.2.0/24")}
+
+	if err := WriteMMDB(dbConfig, sources, nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"a:10000", "a:10001", "b:1"}
+	if fmt.Sprintf("%v", progress) != fmt.Sprintf("%v", expected) {
+		t.Fatalf("unexpected progress: %v", progress)
+	}
+}
+
+// estimatingSource is a testSource with an estimated amount of entries.
+type estimatingSource struct {
+	*testSource
+	estimate int
+}
+
+func (es estimatingSource) EstimateCount() (int, bool) {
+	return es.estimate, true
+}
+
+func TestProgressPercentFunc(t *testing.T) {
+	t.Parallel()
+
+	var progress []string
+	dbConfig := DatabaseConfig{
+		Name:   "Test",
+		MMDB:   MMDBConfig{IPVersion: 6, RecordSize: 24},
+		Types:  map[string]string{"source": "string"},
+		Output: filepath.Join(t.TempDir(), "test.mmdb"),
+		ProgressPercentFunc: func(sourceName string, percent float64) {
+			progress = append(progress, fmt.Sprintf("%s:%.1f", sourceName, percent))
+		},
+	}
+	a := &testSource{name: "a"}
+	for i := 0; i < 3*progressInterval; i++ {
+		a.entries = append(a.entries, &SourceEntry{
+			Net: &net.IPNet{
+				IP:   net.IPv4(10, byte(i>>8), byte(i), 0).To4(),
+				Mask: net.CIDRMask(24, 32),
+			},
+			Values: map[string]SourceValue{"source": {Type: "string", Value: "a"}},
+		})
+	}
+	// The estimate is too low, so the percentage is capped. Sources without
+	// an estimate are not reported.
+	sources := []Source{
+		estimatingSource{testSource: a, estimate: 2*progressInterval + progressInterval/2},
+		newTestSource("b", "192.0.2.0/24"),
+	}
+
+	if err := WriteMMDB(dbConfig, sources, nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"a:40.0", "a:80.0", "a:100.0", "a:100.0"}
+	if fmt.Sprintf("%v", progress) != fmt.Sprintf("%v", expected) {
+		t.Fatalf("unexpected progress: %v", progress)
+	}
+}
+
+func TestNormalizeNetwork(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"::ffff:192.0.2.0/120": "192.0.2.0/24",
+		"::ffff:0:0/96":        "0.0.0.0/0",
+		"192.0.2.0/24":         "192.0.2.0/24",
+		"2001:db8::/32":        "2001:db8::/32",
+		"::192.0.2.0/120":      "::c000:200/120",
+	}
+	for network, expected := range tests {
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			t.Fatal(err)
+		}
+		normalized := NormalizeNetwork(ipNet)
+		if normalized.String() != expected {
+			t.Fatalf("unexpected normalization of %s: %s", network, normalized)
+		}
+	}
+
+	// Mapped and plain IPv4 must end up in the same place.
+	dbConfig := DatabaseConfig{
+		Name:   "Test",
+		MMDB:   MMDBConfig{IPVersion: 4, RecordSize: 24},
+		Types:  map[string]string{"source": "string"},
+		Output: filepath.Join(t.TempDir(), "test.mmdb"),
+	}
+	sources := []Source{
+		newTestSource("a", "::ffff:192.0.2.0/120", "2001:db8::/32"),
+		newTestSource("b", "192.0.2.128/25"),
+	}
+	stats, err := WriteMMDBWithStats(context.Background(), dbConfig, sources, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Records != 2 || stats.Networks != 2 {
+		t.Fatalf("unexpected records or networks: %+v", stats)
+	}
+}
+
+func TestRecordSize(t *testing.T) {
+	t.Parallel()
+
+	for recordSize, valid := range map[int]bool{0: true, 24: true, 28: true, 32: true, 16: false, 30: false} {
+		dbConfig := DatabaseConfig{
+			Name:   "Test",
+			MMDB:   MMDBConfig{IPVersion: 6, RecordSize: recordSize},
+			Types:  map[string]string{"source": "string"},
+			Output: filepath.Join(t.TempDir(), "test.mmdb"),
+		}
+		err := WriteMMDB(dbConfig, []Source{newTestSource("a", "192.0.2.0/24")}, nil)
+		if valid != (err == nil) {
+			t.Fatalf("unexpected result for record size %d: %v", recordSize, err)
+		}
+		if !valid {
+			continue
+		}
+
+		reader, err := maxminddb.Open(dbConfig.Output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int(reader.Metadata.RecordSize) != dbConfig.MMDB.RecordSizeOrDefault() {
+			t.Fatalf("unexpected record size %d, expected %d", reader.Metadata.RecordSize, dbConfig.MMDB.RecordSizeOrDefault())
+		}
+		_ = reader.Close()
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	t.Parallel()
+
+	dbConfig := DatabaseConfig{
+		Name: "Test",
+		MMDB: MMDBConfig{
+			IPVersion:    6,
+			RecordSize:   24,
+			DatabaseType: "Test-Type",
+			Description:  map[string]string{"en": "Test DB", "de": "Test-DB"},
+			Languages:    []string{"en", "de"},
+			BuildEpoch:   1700000000,
+		},
+		Types:  map[string]string{"source": "string"},
+		Output: filepath.Join(t.TempDir(), "test.mmdb"),
+	}
+	if err := WriteMMDB(dbConfig, []Source{newTestSource("a", "192.0.2.0/24")}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := maxminddb.Open(dbConfig.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close() //nolint:errcheck
+	metadata := reader.Metadata
+	if metadata.DatabaseType != "Test-Type" ||
+		fmt.Sprintf("%v", metadata.Description) != "map[de:Test-DB en:Test DB]" ||
+		fmt.Sprintf("%v", metadata.Languages) != "[en de]" ||
+		metadata.BuildEpoch != 1700000000 {
+		t.Fatalf("unexpected metadata: %+v", metadata)
+	}
+}
+
+func TestReproducibleBuild(t *testing.T) {
+	t.Parallel()
+
+	build := func() []byte {
+		dbConfig := DatabaseConfig{
+			Name: "Test",
+			MMDB: MMDBConfig{IPVersion: 6, RecordSize: 24, BuildEpoch: 1700000000},
+			Types: map[string]string{
+				"source":             "string",
+				"location.latitude":  "float64",
+				"location.longitude": "float64",
+			},
+			Output: filepath.Join(t.TempDir(), "test.mmdb"),
+		}
+		sources := []Source{
+			newTestSource("a", "192.0.2.0/24", "2001:db8::/32"),
+			newTestSource("b", "192.0.2.128/25", "198.51.100.0/24"),
+		}
+		for _, source := range sources {
+			for _, entry := range source.(*testSource).entries { //nolint:forcetypeassert
+				entry.Values["location.latitude"] = SourceValue{Type: "float64", Value: "48.2"}
+				entry.Values["location.longitude"] = SourceValue{Type: "float64", Value: "16.37"}
+			}
+		}
+		if err := WriteMMDB(dbConfig, sources, nil); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(dbConfig.Output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	if !bytes.Equal(build(), build()) {
+		t.Fatal("builds with identical inputs differ")
+	}
+}
+
+func TestNetworkFilter(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "test.csv")
+	data := "192.0.2.0,192.0.3.127,a\n198.51.100.0,198.51.100.255,b\n203.0.113.0,203.0.113.255,c\n"
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dbConfig := DatabaseConfig{
+		Name:  "Test",
+		MMDB:  MMDBConfig{IPVersion: 6, RecordSize: 24},
+		Types: map[string]string{"source": "string"},
+		Inputs: []DatabaseInput{{
+			File:            file,
+			Fields:          []string{"from", "to", "source"},
+			IncludeNetworks: []string{"192.0.2.0/23", "198.51.100.0/24"},
+			ExcludeNetworks: []string{"192.0.3.0/24", "198.51.100.0/24"},
+		}},
+		Output: filepath.Join(t.TempDir(), "test.mmdb"),
+	}
+	sources, err := LoadSources(dbConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The range is split into 192.0.2.0/24 and 192.0.3.0/25, of which only
+	// the first passes the filter.
+	stats, err := WriteMMDBWithStats(context.Background(), dbConfig, sources, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Records != 1 || stats.Networks != 1 || stats.Sources[0].Filtered != 3 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// Invalid networks fail loading the input.
+	dbConfig.Inputs[0].ExcludeNetworks = []string{"192.0.2.0"}
+	if _, err := LoadSources(dbConfig); err == nil {
+		t.Fatal("expected error for invalid network")
+	}
+}